@@ -0,0 +1,120 @@
+package shopigo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ShopifyAPIError is returned for non-2xx Admin API responses. Errors holds
+// the normalized error details by attribute, errors not tied to an attribute
+// are listed under "base".
+type ShopifyAPIError struct {
+	StatusCode int
+	Errors     map[string][]string
+	Message    string
+	Body       string
+}
+
+func (e *ShopifyAPIError) Error() string {
+	return fmt.Sprintf("request failed, status: %d, detail: %s", e.StatusCode, e.Message)
+}
+
+// ErrInvalidToken matches 401 Admin API responses, returned for revoked or
+// invalid access tokens: {"errors":"[API] Invalid API key or access token ..."}.
+// Clients returned by App.ClientFor delete the stale session on it, so the
+// shop is asked to reauthorize on its next request.
+var ErrInvalidToken = errors.New("invalid access token")
+
+// Is makes errors.Is(err, ErrInvalidToken) report unauthorized responses.
+func (e *ShopifyAPIError) Is(target error) bool {
+	return target == ErrInvalidToken && e.StatusCode == http.StatusUnauthorized
+}
+
+// ErrResponseTooLarge is returned reading responses larger than allowed by
+// WithMaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response too large")
+
+func IsUnprocessable(err error) bool {
+	var apiErr *ShopifyAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+func newShopifyAPIError(resp *http.Response) *ShopifyAPIError {
+	bs, _ := io.ReadAll(resp.Body)
+	e := &ShopifyAPIError{StatusCode: resp.StatusCode, Errors: map[string][]string{}, Body: string(bs)}
+	var body struct {
+		Errors json.RawMessage `json:"errors"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := jsonUnmarshal(bs, &body); err == nil {
+		raw := body.Errors
+		if len(raw) == 0 {
+			raw = body.Error
+		}
+		e.Errors = normalizeAPIErrors(raw)
+	}
+	e.Message = e.flatten()
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// normalizeAPIErrors handles the shapes of the errors field: a string, a list
+// of strings or an object of attributes to a string or a list of strings.
+func normalizeAPIErrors(raw json.RawMessage) map[string][]string {
+	errs := map[string][]string{}
+	if msgs := apiErrorMessages(raw); len(msgs) > 0 {
+		errs["base"] = msgs
+		return errs
+	}
+	var fields map[string]json.RawMessage
+	if err := jsonUnmarshal(raw, &fields); err != nil {
+		return errs
+	}
+	for field, v := range fields {
+		if msgs := apiErrorMessages(v); len(msgs) > 0 {
+			errs[field] = msgs
+		}
+	}
+	return errs
+}
+
+func apiErrorMessages(raw json.RawMessage) []string {
+	var msg string
+	if err := jsonUnmarshal(raw, &msg); err == nil {
+		if msg == "" {
+			return nil
+		}
+		return []string{msg}
+	}
+	var msgs []string
+	if err := jsonUnmarshal(raw, &msgs); err == nil {
+		return msgs
+	}
+	return nil
+}
+
+func (e *ShopifyAPIError) flatten() string {
+	fields := make([]string, 0, len(e.Errors))
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var msgs []string
+	for _, field := range fields {
+		for _, msg := range e.Errors[field] {
+			if field == "base" {
+				msgs = append(msgs, msg)
+			} else {
+				msgs = append(msgs, field+" "+msg)
+			}
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
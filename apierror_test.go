@@ -0,0 +1,80 @@
+package shopigo
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+type APIErrorTestSuite struct {
+	suite.Suite
+}
+
+func TestAPIErrorTestSuite(t *testing.T) {
+	suite.Run(t, new(APIErrorTestSuite))
+}
+
+func (s *APIErrorTestSuite) TestErrorShapes() {
+	for name, tc := range map[string]struct {
+		status  int
+		body    string
+		errors  map[string][]string
+		message string
+	}{
+		"string": {
+			status:  http.StatusNotFound,
+			body:    `{"errors":"Not Found"}`,
+			errors:  map[string][]string{"base": {"Not Found"}},
+			message: "Not Found",
+		},
+		"fields": {
+			status:  http.StatusUnprocessableEntity,
+			body:    `{"errors":{"title":["can't be blank"],"handle":["is taken","is too short"]}}`,
+			errors:  map[string][]string{"title": {"can't be blank"}, "handle": {"is taken", "is too short"}},
+			message: "handle is taken; handle is too short; title can't be blank",
+		},
+		"list": {
+			status:  http.StatusBadRequest,
+			body:    `{"errors":["first","second"]}`,
+			errors:  map[string][]string{"base": {"first", "second"}},
+			message: "first; second",
+		},
+		"empty": {
+			status:  http.StatusBadGateway,
+			body:    ``,
+			errors:  map[string][]string{},
+			message: "Bad Gateway",
+		},
+		"html": {
+			status:  http.StatusServiceUnavailable,
+			body:    `<html><body>Service Unavailable</body></html>`,
+			errors:  map[string][]string{},
+			message: "Service Unavailable",
+		},
+	} {
+		err := newShopifyAPIError(response(tc.status, nil, tc.body))
+		s.Equal(tc.status, err.StatusCode, name)
+		s.Equal(tc.errors, err.Errors, name)
+		s.Equal(tc.message, err.Message, name)
+		s.Equal(tc.body, err.Body, name)
+	}
+}
+
+func (s *APIErrorTestSuite) TestHelpers() {
+	notFound := fmt.Errorf("request failed: %w", newShopifyAPIError(response(http.StatusNotFound, nil, `{"errors":"Not Found"}`)))
+	unprocessable := newShopifyAPIError(response(http.StatusUnprocessableEntity, nil, `{"errors":{"title":["can't be blank"]}}`))
+	s.True(IsNotFound(notFound))
+	s.False(IsUnprocessable(notFound))
+	s.True(IsUnprocessable(unprocessable))
+	s.False(IsNotFound(unprocessable))
+	s.True(IsNotFound(ErrNotFound))
+}
+
+func (s *APIErrorTestSuite) TestInvalidToken() {
+	err := newShopifyAPIError(response(http.StatusUnauthorized, nil,
+		`{"errors":"[API] Invalid API key or access token (unrecognized login or wrong password)"}`))
+	s.ErrorIs(fmt.Errorf("request failed: %w", err), ErrInvalidToken)
+	s.Equal("[API] Invalid API key or access token (unrecognized login or wrong password)", err.Message)
+	s.NotErrorIs(newShopifyAPIError(response(http.StatusForbidden, nil, `{"errors":"Forbidden"}`)), ErrInvalidToken)
+}
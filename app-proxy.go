@@ -9,37 +9,63 @@ import (
 	"github.com/gin-gonic/gin"
 	log "log/slog"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 )
 
+// VerifyAppProxy checks the signature parameter of an app proxy request. The
+// signed message is the sorted parameters except signature, concatenated as
+// key=value without separator, with the values of repeated keys comma joined.
+func VerifyAppProxy(query url.Values, secret string) bool {
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(appProxyMessage(query)))
+	return hmac.Equal(signature, hash.Sum(nil))
+}
+
+func appProxyMessage(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, v := range query {
+		if k == "signature" {
+			continue
+		}
+		pairs = append(pairs, k+"="+strings.Join(v, ","))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "")
+}
+
+// VerifyAppProxyRequest authenticates app proxy requests and sets the offline
+// session of the shop on the context.
 func (a *App) VerifyAppProxyRequest(c *gin.Context) {
-	shop := c.Query("shop")
-	logger := a.logger(c).With(log.String("shop", shop))
+	logger := a.logger(c).With(log.String("shop", c.Query("shop")))
 	logger.Debug("verifying app proxy request")
 
-	sorted := fmt.Sprintf("logged_in_customer_id=%spath_prefix=%sshop=%stimestamp=%s",
-		c.Query("logged_in_customer_id"),
-		c.Query("path_prefix"),
-		shop,
-		c.Query("timestamp"),
-	)
-	hash := hmac.New(sha256.New, []byte(a.Credentials.ClientSecret))
-	hash.Write([]byte(sorted))
-	calcSignature := []byte(hex.EncodeToString(hash.Sum(nil)))
-	signature := []byte(c.Query("signature"))
-
-	logger.Debug("checking hmac signature")
-	if !hmac.Equal(calcSignature, signature) {
+	if !VerifyAppProxy(c.Request.URL.Query(), a.Credentials.ClientSecret) {
 		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("hmac signature mismatch"))
 		return
 	}
+	if err := a.checkRequestAge(c.Request.URL.Query()); err != nil {
+		_ = c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	shop, err := a.sanitizeShop(c.Query("shop"))
+	if err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
 
 	logger.Debug("retrieving session")
-	sess, err := a.SessionStore.Get(c.Request.Context(), shop)
+	sess, err := a.SessionStore.Get(c.Request.Context(), OfflineSessionID(shop))
 	if IsNotFound(err) {
 		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("session not found"))
 		return
 	} else if err != nil {
-		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve session for: %w", err))
+		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve session for %s: %w", shop, err))
 		return
 	}
 	c.Set(ShopSessionKey, sess)
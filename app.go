@@ -1,25 +1,56 @@
 package shopigo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"html/template"
 	log "log/slog"
+	"net/http"
 	"net/url"
 	"regexp"
-	"sort"
 	"strings"
+	"time"
 )
 
 var (
-	defaultTLDs  = []string{"myshopify.com", "shopify.com", "myshopify.io"}
-	subDomainReg = "[a-zA-Z0-9][a-zA-Z0-9-_]*"
+	defaultTLDs = []string{"myshopify.com", "shopify.com", "myshopify.io"}
+	// subDomainReg matches a lower case DNS label of at most 63 characters,
+	// underscores are accepted for compatibility.
+	subDomainReg = "[a-z0-9][a-z0-9_-]{0,62}"
 	TraceIDKey   = "KeyTraceID"
+	// plainDomainRegexp matches custom shop domains given as plain domain,
+	// which are matched literally rather than as regular expression.
+	plainDomainRegexp = regexp.MustCompile(`(?i)^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
 )
 
+// compileShopRegexp returns the regexp matching a subdomain of one of the
+// domains plus optional trailing slashes. Plain domains are matched
+// literally, others are regular expressions.
+func compileShopRegexp(domains []string) (*regexp.Regexp, error) {
+	patterns := make([]string, len(domains))
+	for i, domain := range domains {
+		patterns[i] = domain
+		if plainDomainRegexp.MatchString(domain) {
+			patterns[i] = regexp.QuoteMeta(strings.ToLower(domain))
+		}
+	}
+	return regexp.Compile(fmt.Sprintf(`^%s\.(?:%s)/*$`, subDomainReg, strings.Join(patterns, "|")))
+}
+
 type App struct {
 	*AppConfig
 	*Client
 	SessionStore
+
+	clients   *shopClients
+	shopInfos ShopInfoCache
+	// grantedScopes caches the results of GrantedScopes.
+	grantedScopes *grantedScopesCache
+	closer        closeOnce
+	// optErr collects the errors of options, returned by NewApp.
+	optErr error
 }
 
 func NewAppConfig() *AppConfig {
@@ -32,15 +63,40 @@ type AppConfig struct {
 
 	embedded                 bool
 	withTraceID              bool
+	accessMode               AccessMode
 	authBeginEndpoint        string
 	authCallbackPath         string
 	authCallbackURL          string
-	scopes                   string
+	tokenEndpoint            string
+	scopes                   Scopes
 	uninstallWebhookEndpoint string
+	webhookSubscriptions     []WebhookSubscription
+	webhookDedup             DedupStore
+	webhookDedupTTL          time.Duration
+	webhookVersionHandler    WebhookVersionHandler
 	shopRegexp               *regexp.Regexp
+	shopValidator            func(shop string) bool
+	nonceStore               NonceStore
+	nonceTTL                 time.Duration
+	sessionTokenLeeway       time.Duration
+	requestMaxAge            time.Duration
+	requestClockSkew         time.Duration
+	encryptionKeys           [][]byte
+	scopeReconciliation      bool
+	shopUnavailableURL       string
+	staticSession            *Session
+	cookieOptions            *CookieOptions
+	cookies                  CookieOptions
+	unauthenticatedHandler   gin.HandlerFunc
+	postInstallRedirect      func(shop string) string
+	topLevelRedirectTemplate *template.Template
+	requireSessionStore      bool
+	unsortedScopes           bool
 
 	installHook   HookInstall
+	uninstallHook HookUninstall
 	sessionIDHook HookSessionID
+	observers     []LifecycleObserver
 }
 
 type Credentials struct {
@@ -53,30 +109,117 @@ func NewApp(c *AppConfig, opts ...Opt) (*App, error) {
 		return nil, err
 	}
 	app := &App{
-		AppConfig: c,
-		Client:    NewShopifyClient(&ClientConfig{hostURL: c.HostURL, clientID: c.ClientID}),
+		AppConfig:     c,
+		Client:        NewShopifyClient(&ClientConfig{hostURL: c.HostURL, clientID: c.ClientID}),
+		clients:       newShopClients(),
+		shopInfos:     newShopInfoCache(),
+		grantedScopes: newGrantedScopesCache(),
 	}
 	applyDefaults(app)
 	for _, opt := range opts {
 		opt(app)
 	}
+	if app.SessionStore == InMemSessionStore {
+		if app.requireSessionStore {
+			app.optError(ErrInMemSessionStore)
+		} else if app.staticSession == nil {
+			log.Warn("using the in-memory session store, installs are lost on restart, configure a store with WithSessionStore")
+		}
+	}
+	if app.optErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, app.optErr)
+	}
+	app.Client.invalidateShop = app.invalidateShop
+	if len(app.encryptionKeys) > 0 {
+		store, err := NewEncryptedSessionStore(app.SessionStore, app.encryptionKeys[0], app.encryptionKeys[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		app.SessionStore = store
+	}
+	app.cookies = defaultCookieOptions(app.embedded)
+	if app.cookieOptions != nil {
+		app.cookies = *app.cookieOptions
+	}
+	if app.nonceStore == nil {
+		nonceStore := NewSignedCookieNonceStore(c.ClientSecret, app.authCallbackPath)
+		nonceStore.cookies = app.cookies
+		app.nonceStore = nonceStore
+	}
+	if app.staticSession != nil {
+		app.staticSession.Scopes = app.scopes.String()
+		if err := app.SessionStore.Store(context.Background(), app.staticSession); err != nil {
+			return nil, fmt.Errorf("failed to store static session: %w", err)
+		}
+	}
 	return app, nil
 }
 
+var (
+	ErrInvalidConfig = errors.New("invalid app config")
+	// ErrInMemSessionStore is returned by NewApp with
+	// WithRequireExplicitSessionStore if no session store is configured.
+	ErrInMemSessionStore = errors.New("no persistent session store configured")
+)
+
+// validate requires credentials and an absolute HostURL without trailing
+// slash. Plain http is only accepted for localhost.
 func validate(c *AppConfig) error {
-	_, err := url.Parse(c.HostURL)
-	return err
+	var errs []error
+	if c.Credentials == nil || c.ClientID == "" {
+		errs = append(errs, fmt.Errorf("%w: ClientID is empty", ErrInvalidConfig))
+	}
+	if c.Credentials == nil || c.ClientSecret == "" {
+		errs = append(errs, fmt.Errorf("%w: ClientSecret is empty", ErrInvalidConfig))
+	}
+	if err := validateHostURL(c.HostURL); err != nil {
+		errs = append(errs, fmt.Errorf("%w: HostURL %q %s", ErrInvalidConfig, c.HostURL, err.Error()))
+	}
+	return errors.Join(errs...)
+}
+
+func validateHostURL(hostURL string) error {
+	u, err := url.Parse(hostURL)
+	if err != nil {
+		return errors.New("is not a valid URL")
+	}
+	switch {
+	case u.Host == "":
+		return errors.New("must be an absolute URL with host")
+	case u.Scheme == "http" && !isLocalhost(u.Hostname()):
+		return errors.New("must use https, http is only allowed for localhost")
+	case u.Scheme != "https" && u.Scheme != "http":
+		return errors.New("must use https")
+	case strings.HasSuffix(hostURL, "/"):
+		return errors.New("must not end with a slash")
+	case u.RawQuery != "" || u.Fragment != "":
+		return errors.New("must not have a query or fragment")
+	}
+	return nil
+}
+
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// optError records an error of an option, so it's returned by NewApp.
+func (a *App) optError(err error) {
+	a.optErr = errors.Join(a.optErr, err)
 }
 
 func applyDefaults(a *App) {
 	a.v = VLatest
+	a.retries = defaultRetries
 	a.embedded = true
 	a.authBeginEndpoint = "/auth/begin"
 	a.authCallbackPath = "/auth/install"
 	authCallbackURL, _ := url.JoinPath(a.HostURL, a.authCallbackPath)
 	a.authCallbackURL = authCallbackURL
+	a.tokenEndpoint = "/oauth/access_token"
 	a.SessionStore = InMemSessionStore
-	a.shopRegexp = regexp.MustCompile(fmt.Sprintf("^%s.(%s)/*$", subDomainReg, strings.Join(defaultTLDs, "|")))
+	a.nonceTTL = defaultNonceTTL
+	a.sessionTokenLeeway = 5 * time.Second
+	a.shopRegexp, _ = compileShopRegexp(defaultTLDs)
 }
 
 func (a *App) logger(c *gin.Context) *log.Logger {
@@ -88,16 +231,26 @@ func (a *App) logger(c *gin.Context) *log.Logger {
 
 type Opt = func(a *App)
 
+type AccessMode int
+
+const (
+	AccessModeOffline AccessMode = iota
+	AccessModeOnline
+)
+
+func WithAccessMode(m AccessMode) Opt {
+	return func(a *App) {
+		a.accessMode = m
+	}
+}
+
 func WithVersion(v Version) Opt {
 	return func(a *App) {
-		switch v {
-		case V202304:
-			a.v = v
-		case V202307:
-			a.v = v
-		default:
+		if _, err := ParseVersion(v.String()); err != nil {
 			a.v = VLatest
+			return
 		}
+		a.v = v
 	}
 }
 
@@ -107,6 +260,154 @@ func WithRetry(n int) Opt {
 	}
 }
 
+// WithRetryableStatuses sets the response statuses retried, by default 429,
+// 500, 502, 503 and 504. Other responses, e.g. validation errors, are returned
+// on the first attempt.
+func WithRetryableStatuses(statuses ...int) Opt {
+	return func(a *App) {
+		a.retryableStatuses = statuses
+	}
+}
+
+// WithAPIHostOverride makes the client send Admin API and token requests to
+// the admin URL returned by host instead of https://<shop>/admin, e.g. a
+// local mock server in end-to-end tests.
+func WithAPIHostOverride(host func(shop string) string) Opt {
+	return func(a *App) {
+		a.apiHostOverride = host
+	}
+}
+
+// WithTokenEndpoint sets the path of access token requests relative to the
+// admin URL, /oauth/access_token by default. Combined with
+// WithAPIHostOverride it points the OAuth flow at a mock server.
+func WithTokenEndpoint(endpoint string) Opt {
+	return func(a *App) {
+		if !strings.HasPrefix(endpoint, "/") {
+			a.optError(fmt.Errorf("token endpoint must be an absolute path, got %q", endpoint))
+			return
+		}
+		a.tokenEndpoint = endpoint
+	}
+}
+
+// WithBackoff sets the base and the cap of the exponential backoff between
+// retries, both must be positive and base must not exceed max.
+func WithBackoff(base time.Duration, max time.Duration) Opt {
+	return func(a *App) {
+		if base <= 0 || max < base {
+			a.optError(fmt.Errorf("backoff base must be positive and at most max, got %s and %s", base, max))
+			return
+		}
+		a.backoffBase = base
+		a.backoffMax = max
+	}
+}
+
+// WithRetryNonIdempotent allows retrying requests such as POST on transient
+// errors, which may cause them to be applied twice.
+func WithRetryNonIdempotent() Opt {
+	return func(a *App) {
+		a.retryNonIdempotent = true
+	}
+}
+
+// WithMaxResponseBytes limits the size of the Admin API responses read by the
+// client to n bytes, 64MB by default. Larger responses fail with
+// ErrResponseTooLarge. Bulk operation results are streamed and not limited.
+func WithMaxResponseBytes(n int64) Opt {
+	return func(a *App) {
+		if n <= 0 {
+			a.optError(fmt.Errorf("max response bytes must be positive, got %d", n))
+			return
+		}
+		a.maxResponseBytes = n
+	}
+}
+
+func WithBulkPollInterval(d time.Duration) Opt {
+	return func(a *App) {
+		a.bulkPollInterval = d
+	}
+}
+
+// WithHTTPClient replaces the HTTP client used for all Shopify requests, e.g.
+// to configure timeouts, proxies or an instrumented transport. Retries and
+// throttling are applied on top of it.
+func WithHTTPClient(client *http.Client) Opt {
+	return func(a *App) {
+		if client != nil {
+			a.http = client
+		}
+	}
+}
+
+func WithLogger(l Logger) Opt {
+	return func(a *App) {
+		if l == nil {
+			l = noopLogger{}
+		}
+		a.Client.logger = l
+	}
+}
+
+// WithMetrics records metrics of all Shopify requests, see MetricsRecorder.
+func WithMetrics(m MetricsRecorder) Opt {
+	return func(a *App) {
+		if m == nil {
+			m = noopMetrics{}
+		}
+		a.Client.metrics = m
+	}
+}
+
+// WithDeprecationHandler is called for every response flagged with the
+// X-Shopify-API-Deprecated-Reason header. By default a warning is logged with
+// the Logger set by WithLogger.
+func WithDeprecationHandler(fn func(reason, url string)) Opt {
+	return func(a *App) {
+		a.deprecationHandler = fn
+	}
+}
+
+func WithGraphQLThrottle(enabled bool) Opt {
+	return func(a *App) {
+		a.throttle.enabled = enabled
+	}
+}
+
+// WithRESTThrottle toggles throttling REST requests by the shop's leaky
+// bucket, enabled by default.
+func WithRESTThrottle(enabled bool) Opt {
+	return func(a *App) {
+		a.rest.enabled = enabled
+	}
+}
+
+// WithGraphQLBucket sets the bucket size and restore rate assumed for shops
+// until Shopify reports their actual throttle status.
+func WithGraphQLBucket(maxAvailable float64, restoreRate float64) Opt {
+	return func(a *App) {
+		a.throttle.defaults = bucketLimits{maxAvailable: maxAvailable, restoreRate: restoreRate}
+	}
+}
+
+func WithShopGraphQLBucket(shop string, maxAvailable float64, restoreRate float64) Opt {
+	return func(a *App) {
+		a.throttle.limits[shop] = bucketLimits{maxAvailable: maxAvailable, restoreRate: restoreRate}
+	}
+}
+
+// WithStaticToken configures a custom app with a fixed Admin API access
+// token. The OAuth flow is disabled and the auth middlewares accept requests
+// with the offline session of shop, which is stored on creation.
+func WithStaticToken(shop string, token string) Opt {
+	return func(a *App) {
+		a.staticSession = &Session{ID: GetOfflineSessionID(shop), Shop: shop, AccessToken: token}
+		a.defaultShop = &Shop{Address: shop, Token: token}
+	}
+}
+
 func WithDefaultAuth(s *Shop) Opt {
 	return func(a *App) {
 		a.defaultShop = s
@@ -115,12 +416,49 @@ func WithDefaultAuth(s *Shop) Opt {
 
 func WithScopes(s []string) Opt {
 	return func(a *App) {
-		scopes := make([]string, len(s))
-		copy(scopes, s)
-		sort.Slice(scopes, func(i, j int) bool {
-			return scopes[i] < scopes[j]
-		})
-		a.scopes = strings.Join(scopes, ",")
+		a.scopes = ParseScopes(strings.Join(s, ","))
+		a.unsortedScopes = false
+	}
+}
+
+// WithUnsortedScopes is WithScopes keeping the order of s, without
+// duplicates, in the OAuth request and the scopes of stored sessions, e.g. to
+// match the scopes of the app's settings. Scopes are still compared
+// regardless of order.
+func WithUnsortedScopes(s []string) Opt {
+	return func(a *App) {
+		a.scopes = ParseScopesUnsorted(strings.Join(s, ","))
+		a.unsortedScopes = true
+	}
+}
+
+// WithShopUnavailableRedirect sends merchants of frozen or locked shops to
+// path, e.g. a page asking them to reactivate their plan, instead of failing
+// with 402 or 423.
+func WithShopUnavailableRedirect(path string) Opt {
+	return func(a *App) {
+		a.shopUnavailableURL = path
+	}
+}
+
+// WithUnauthenticatedHandler handles requests of shops without session before
+// the default, which redirects to the auth begin endpoint: requests with a
+// bearer token get the App Bridge reauthorization headers and a 403, requests
+// with embedded=1 are redirected by /exitiframe, all others directly. The
+// Accept and X-Requested-With headers aren't considered by the default, see
+// UnauthenticatedJSON for fetch requests. Requests the handler doesn't respond
+// to are handled by the default. GetAuthRedirectURI returns the auth URL.
+func WithUnauthenticatedHandler(h gin.HandlerFunc) Opt {
+	return func(a *App) {
+		a.unauthenticatedHandler = h
+	}
+}
+
+// WithScopeReconciliation only invalidates sessions missing configured scopes,
+// regardless of scope order, instead of requiring an exact match.
+func WithScopeReconciliation(enabled bool) Opt {
+	return func(a *App) {
+		a.scopeReconciliation = enabled
 	}
 }
 
@@ -141,7 +479,8 @@ func WithAuthCallbackEndpoint(s string) Opt {
 		a.authCallbackPath = s
 		authCallbackURL, err := url.JoinPath(a.HostURL, a.authCallbackPath)
 		if err != nil {
-			panic(err)
+			a.optError(fmt.Errorf("invalid auth callback endpoint %q: %w", s, err))
+			return
 		}
 		a.authCallbackURL = authCallbackURL
 	}
@@ -153,12 +492,95 @@ func WithSessionStore(sess SessionStore) Opt {
 	}
 }
 
+// WithRequireExplicitSessionStore makes NewApp fail with ErrInMemSessionStore
+// instead of falling back to the in-memory store, which loses all sessions on
+// restart.
+func WithRequireExplicitSessionStore() Opt {
+	return func(a *App) {
+		a.requireSessionStore = true
+	}
+}
+
+func WithNonceStore(s NonceStore) Opt {
+	return func(a *App) {
+		a.nonceStore = s
+	}
+}
+
+func WithNonceTTL(d time.Duration) Opt {
+	return func(a *App) {
+		a.nonceTTL = d
+	}
+}
+
+func WithSessionTokenLeeway(d time.Duration) Opt {
+	return func(a *App) {
+		a.sessionTokenLeeway = d
+	}
+}
+
+// WithRequestMaxAge rejects OAuth callbacks and app proxy requests whose
+// signed timestamp is older than maxAge, limiting the window to replay them.
+// skew allows for clocks running apart, also for timestamps in the future.
+// The age isn't checked by default.
+func WithRequestMaxAge(maxAge time.Duration, skew time.Duration) Opt {
+	return func(a *App) {
+		if maxAge <= 0 || skew < 0 {
+			a.optError(fmt.Errorf("invalid request max age %s with skew %s", maxAge, skew))
+			return
+		}
+		a.requestMaxAge = maxAge
+		a.requestClockSkew = skew
+	}
+}
+
+func WithSessionEncryptionKey(key []byte, fallbackKeys ...[]byte) Opt {
+	return func(a *App) {
+		a.encryptionKeys = append([][]byte{key}, fallbackKeys...)
+	}
+}
+
 func WithUninstallWebhookEndpoint(path string) Opt {
 	return func(a *App) {
 		a.uninstallWebhookEndpoint = path
 	}
 }
 
+// WithWebhookSubscriptions reconciles the shop's webhook subscriptions with
+// subs after each install, see Client.EnsureWebhooks.
+func WithWebhookSubscriptions(subs ...WebhookSubscription) Opt {
+	return func(a *App) {
+		a.webhookSubscriptions = append(a.webhookSubscriptions, subs...)
+	}
+}
+
+// WithWebhookDedup acknowledges webhooks whose X-Shopify-Webhook-Id was
+// already seen within ttl without running the handler.
+func WithWebhookDedup(store DedupStore, ttl time.Duration) Opt {
+	return func(a *App) {
+		a.webhookDedup = store
+		a.webhookDedupTTL = ttl
+	}
+}
+
+// WithWebhookVersionCheck calls h for webhooks whose X-Shopify-API-Version is
+// older than the app's version, e.g. to log the drift or re-register the
+// subscriptions. The webhook is dispatched regardless.
+func WithWebhookVersionCheck(h WebhookVersionHandler) Opt {
+	return func(a *App) {
+		a.webhookVersionHandler = h
+	}
+}
+
+// WithCookieOptions overrides the cookie attributes, which default to
+// SameSite=None, Secure and Partitioned for embedded apps and SameSite=Lax and
+// Secure otherwise.
+func WithCookieOptions(o CookieOptions) Opt {
+	return func(a *App) {
+		a.cookieOptions = &o
+	}
+}
+
 func WithIsEmbedded(e bool) Opt {
 	return func(a *App) {
 		a.embedded = e
@@ -167,7 +589,31 @@ func WithIsEmbedded(e bool) Opt {
 
 func WithCustomShopDomains(domains ...string) Opt {
 	return func(a *App) {
-		a.shopRegexp = regexp.MustCompile(fmt.Sprintf("^%s.(%s)/*$", subDomainReg, strings.Join(append(defaultTLDs, domains...), "|")))
+		shopRegexp, err := compileShopRegexp(append(defaultTLDs[:len(defaultTLDs):len(defaultTLDs)], domains...))
+		if err != nil {
+			a.optError(fmt.Errorf("invalid custom shop domains %q: %w", domains, err))
+			return
+		}
+		a.shopRegexp = shopRegexp
+	}
+}
+
+// WithPostInstallRedirect sets where merchants are sent after installing the
+// app, the shop and host params are added to the returned URL. By default
+// embedded apps redirect to the app in the Shopify admin, other apps to /.
+func WithPostInstallRedirect(redirect func(shop string) string) Opt {
+	return func(a *App) {
+		a.postInstallRedirect = redirect
+	}
+}
+
+// WithShopValidator replaces the shop domain check, by default the subdomain
+// of a Shopify or custom shop domain, with valid. It's used for every shop
+// taken from requests, e.g. to accept an allowlist of custom domains. Shops
+// are lower cased and stripped of scheme and path before being validated.
+func WithShopValidator(valid func(shop string) bool) Opt {
+	return func(a *App) {
+		a.shopValidator = valid
 	}
 }
 
@@ -175,10 +621,19 @@ type Hook interface {
 	hook()
 }
 
-type HookInstall func()
+// HookInstall is called after the offline session of shop has been stored,
+// before LifecycleObserver.OnInstalled.
+// firstInstall is false if the shop had a session before, i.e. the scopes are
+// updated, or if the app was installed before and the session store
+// implements InstallRecorder, which the built-in stores do. With other stores
+// reinstalls after app/uninstalled deleted the sessions count as first
+// installs. Errors are logged, the install completes regardless.
+type HookInstall func(ctx context.Context, shop string, firstInstall bool) error
+type HookUninstall func(ctx context.Context, shop string) error
 type HookSessionID func() (string, string, error)
 
 func (hi HookInstall) hook()   {}
+func (hu HookUninstall) hook() {}
 func (hs HookSessionID) hook() {}
 
 func WithHooks(hooks ...Hook) Opt {
@@ -187,10 +642,12 @@ func WithHooks(hooks ...Hook) Opt {
 			switch h := hook.(type) {
 			case HookInstall:
 				a.installHook = h
+			case HookUninstall:
+				a.uninstallHook = h
 			case HookSessionID:
 				a.sessionIDHook = h
 			default:
-				panic(fmt.Sprintf("%T is not a valid hook", hook))
+				a.optError(fmt.Errorf("%T is not a valid hook", hook))
 			}
 		}
 	}
@@ -0,0 +1,82 @@
+package shopigo
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type AppTestSuite struct {
+	suite.Suite
+}
+
+func TestAppTestSuite(t *testing.T) {
+	suite.Run(t, new(AppTestSuite))
+}
+
+// testAppConfig returns a config passing validation, tests override fields as
+// needed.
+func testAppConfig() *AppConfig {
+	c := NewAppConfig()
+	c.HostURL = "https://app.example.com"
+	c.ClientID = "client-id"
+	c.ClientSecret = "client-secret"
+	return c
+}
+
+func (s *AppTestSuite) TestValidate() {
+	s.NoError(validate(testAppConfig()))
+	for hostURL, valid := range map[string]bool{
+		"https://app.example.com":      true,
+		"https://app.example.com/shop": true,
+		"http://localhost:8080":        true,
+		"http://127.0.0.1:8080":        true,
+		"":                             false,
+		"app.example.com":              false,
+		"/auth":                        false,
+		"http://app.example.com":       false,
+		"ftp://app.example.com":        false,
+		"https://app.example.com/":     false,
+		"https://app.example.com?a=b":  false,
+	} {
+		c := testAppConfig()
+		c.HostURL = hostURL
+		err := validate(c)
+		if valid {
+			s.NoError(err, hostURL)
+		} else {
+			s.ErrorIs(err, ErrInvalidConfig, hostURL)
+			s.ErrorContains(err, "HostURL", hostURL)
+		}
+	}
+	c := testAppConfig()
+	c.ClientSecret = ""
+	s.ErrorContains(validate(c), "ClientSecret")
+	_, err := NewApp(NewAppConfig())
+	s.ErrorIs(err, ErrInvalidConfig)
+}
+
+type invalidHook struct{}
+
+func (invalidHook) hook() {}
+
+func (s *AppTestSuite) TestOptionErrors() {
+	_, err := NewApp(testAppConfig(), WithCustomShopDomains("shop.(example"))
+	s.ErrorIs(err, ErrInvalidConfig)
+	s.ErrorContains(err, "invalid custom shop domains")
+
+	_, err = NewApp(testAppConfig(), WithHooks(invalidHook{}), WithCustomShopDomains("("))
+	s.ErrorContains(err, "is not a valid hook")
+	s.ErrorContains(err, "invalid custom shop domains")
+
+	_, err = NewApp(testAppConfig(), WithCustomShopDomains("example.com"), WithAuthCallbackEndpoint("/auth/callback"))
+	s.NoError(err)
+}
+
+func (s *AppTestSuite) TestRequireExplicitSessionStore() {
+	_, err := NewApp(testAppConfig(), WithRequireExplicitSessionStore())
+	s.ErrorIs(err, ErrInvalidConfig)
+	s.ErrorIs(err, ErrInMemSessionStore)
+
+	_, err = NewApp(testAppConfig(), WithRequireExplicitSessionStore(), WithSessionStore(&inMemSessionStore{}))
+	s.NoError(err)
+}
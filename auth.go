@@ -1,6 +1,7 @@
 package shopigo
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -8,12 +9,11 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/hasura/go-graphql-client"
 	log "log/slog"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,15 +24,24 @@ var (
 )
 
 const (
-	metadataKey       = "metadataKey"
-	ShopSessionKey    = "ShopifyShopSessionKey"
-	AppStateCookie    = "shopify_app_state"
-	AppStateCookieSig = "shopify_app_state.sig"
-	SessionCookie     = "shopify_app_session"
-	SessionCookieSig  = "shopify_app_session.sig"
+	metadataKey         = "metadataKey"
+	accessModeKey       = "accessModeKey"
+	ShopSessionKey      = "ShopifyShopSessionKey"
+	ShopClientKey       = "ShopifyShopClientKey"
+	AppStateCookie      = "shopify_app_state"
+	AppStateCookieSig   = "shopify_app_state.sig"
+	AccessModeCookie    = "shopify_app_access_mode"
+	AccessModeCookieSig = "shopify_app_access_mode.sig"
+	HostCookie          = "shopify_app_host"
+	HostCookieSig       = "shopify_app_host.sig"
+	SessionCookie       = "shopify_app_session"
+	SessionCookieSig    = "shopify_app_session.sig"
 )
 
 func (a *App) EnsureInstalledOnShop(c *gin.Context) {
+	if a.staticAuth(c) {
+		return
+	}
 	logger := a.logger(c).With("action", "EnsureInstalledOnShop")
 	if !a.embedded {
 		logger.Debug("app is not embedded, validating session")
@@ -47,12 +56,12 @@ func (a *App) EnsureInstalledOnShop(c *gin.Context) {
 	setShop(c, shop)
 	logger = logger.With(log.String("shop", shop))
 	logger.Debug("check if app is installed")
-	sess, err := a.SessionStore.Get(c.Request.Context(), GetOfflineSessionID(shop))
+	sess, err := a.SessionStore.Get(c.Request.Context(), OfflineSessionID(shop))
 	if IsNotFound(err) {
 		logger.Debug("no session found")
 		if !exitFrameRegexp.MatchString(c.Request.RequestURI) {
 			logger.Debug("not in exitframe, redirecting to auth")
-			a.redirectToAuth(c)
+			a.unauthenticated(c, shop, a.redirectToAuth)
 			return
 		}
 		logger.Debug("we are in an /exitframe request, serve app")
@@ -64,6 +73,9 @@ func (a *App) EnsureInstalledOnShop(c *gin.Context) {
 	if a.embedded && !isEmbedded(c) {
 		logger.Debug("tried to use embedded app in non-embedded context, validate session")
 		if !a.sessionValid(c, sess) {
+			if c.IsAborted() {
+				return
+			}
 			logger.Debug("session is invalid, redirecting to auth")
 			a.redirectToAuth(c)
 			return
@@ -76,6 +88,9 @@ func (a *App) EnsureInstalledOnShop(c *gin.Context) {
 }
 
 func (a *App) ValidateAuthenticatedSession(c *gin.Context) {
+	if a.staticAuth(c) {
+		return
+	}
 	logger := a.logger(c)
 	logger.Debug("retrieve session ID")
 	sessID, shop, err := a.getSessionID(c)
@@ -89,15 +104,15 @@ func (a *App) ValidateAuthenticatedSession(c *gin.Context) {
 		if shop != "" {
 			logger.With(log.String("shop", shop)).
 				Debug("session not found but shop in bearer token, redirecting to auth")
+			a.notify(func(obs LifecycleObserver) { obs.OnReauth(c.Request.Context(), shop, "session not found") })
 			setShop(c, shop)
-			redirect, err := url.JoinPath(a.HostURL,
-				fmt.Sprintf("%s?%s", a.authBeginEndpoint, url.Values{"shop": {shop}}.Encode()))
+			redirect, err := a.authBeginURL(url.Values{"shop": {shop}})
 			if err != nil {
 				_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
 				return
 			}
 			setRedirectUri(c, redirect)
-			a.redirectOutOfApp(c)
+			a.unauthenticated(c, shop, a.redirectOutOfApp)
 			return
 		}
 		_ = c.AbortWithError(http.StatusUnauthorized, err)
@@ -114,11 +129,13 @@ func (a *App) ValidateAuthenticatedSession(c *gin.Context) {
 		return
 	}
 	if !a.sessionValid(c, sess) {
+		if c.IsAborted() {
+			return
+		}
 		logger.With(log.String("shop", sess.Shop)).
 			Debug("session is invalid, redirecting to auth")
 		setShop(c, sess.Shop)
-		redirect, err := url.JoinPath(a.HostURL,
-			fmt.Sprintf("%s?%s", a.authBeginEndpoint, url.Values{"shop": {sess.Shop}}.Encode()))
+		redirect, err := a.authBeginURL(url.Values{"shop": {sess.Shop}})
 		if err != nil {
 			_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
 			return
@@ -130,11 +147,59 @@ func (a *App) ValidateAuthenticatedSession(c *gin.Context) {
 	c.Set(ShopSessionKey, sess)
 }
 
+// AuthenticatedSession authenticates API requests of embedded apps by the
+// session token App Bridge sends as bearer token. The offline session of the
+// token's shop and a client bound to it are set on the context, see
+// MustGetShopSession and MustGetShopClient. Shops without offline session are
+// asked to reauthorize by the App Bridge reauthorization headers.
+func (a *App) AuthenticatedSession(c *gin.Context) {
+	shop := ""
+	if a.staticAuth(c) {
+		shop = a.staticSession.Shop
+	} else {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			_ = c.AbortWithError(http.StatusUnauthorized, errors.New("missing 'Authorization' header"))
+			return
+		}
+		claims, err := a.DecodeSessionToken(token)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+		shop = claims.Shop()
+		setShop(c, shop)
+	}
+	logger := a.logger(c).With(log.String("shop", shop))
+	client, err := a.ClientFor(c.Request.Context(), shop)
+	if IsNotFound(err) {
+		logger.Debug("no offline session for session token, requesting reauthorization")
+		a.notify(func(obs LifecycleObserver) { obs.OnReauth(c.Request.Context(), shop, "no offline session") })
+		redirect, err := a.authBeginURL(url.Values{"shop": {shop}})
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
+			return
+		}
+		setRedirectUri(c, redirect)
+		a.unauthenticated(c, shop, a.appBridgeHeaderRedirect)
+		return
+	} else if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Set(ShopSessionKey, client.Session())
+	c.Set(ShopClientKey, client)
+}
+
 func (a *App) Begin(c *gin.Context) {
+	if a.staticSession != nil {
+		_ = c.AbortWithError(http.StatusNotFound, errOAuthDisabled)
+		return
+	}
 	shop := getShop(c)
 	if shop == "" {
 		var err error
-		if shop, err = a.sanitizeShop(c.Query("shop")); err != nil {
+		if shop, err = a.NormalizeShop(c.Query("shop")); err != nil {
 			_ = c.AbortWithError(http.StatusBadRequest, err)
 			return
 		}
@@ -142,27 +207,85 @@ func (a *App) Begin(c *gin.Context) {
 
 	logger := a.logger(c).With(log.String("shop", shop))
 	logger.Debug("beginning auth")
+	if a.embedded {
+		if a.inIframe(c) {
+			logger.Debug("begin loaded in iframe, redirecting top window")
+			a.topLevelRedirect(c, shop)
+			return
+		}
+		a.setTopLevelCookie(c)
+	}
 
-	nonce := strconv.FormatInt(rand.Int63(), 10)
-	// online access tokens: grantOptions = "per-user" (not implemented)
-	var grantOptions string
-	query := url.Values{
-		"client_id":       {a.Credentials.ClientID},
-		"scope":           {a.scopes},
-		"redirect_uri":    {a.authCallbackURL},
-		"state":           {nonce},
-		"grant_options[]": {grantOptions},
+	nonce, err := newNonce()
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to generate nonce: %w", err))
+		return
+	}
+	requested := a.requestedAccessMode(c)
+	mode := a.beginAccessMode(c.Request.Context(), shop, requested)
+	if mode == AccessModeOnline {
+		logger.Debug("requesting online access token")
+	} else if requested == AccessModeOnline {
+		// the online token is requested by Install once the app is installed
+		expires := time.Now().Add(a.nonceTTL)
+		a.cookies.setSigned(c, a.Credentials.ClientSecret, AccessModeCookie, "online", a.authCallbackPath, &expires)
+	}
+	host := validHost(c.Query("host"), shop)
+	if host == "" {
+		host = GetHost(c)
+	}
+	if host != "" {
+		// carried to Install in case Shopify doesn't pass it to the callback
+		expires := time.Now().Add(a.nonceTTL)
+		a.cookies.setSigned(c, a.Credentials.ClientSecret, HostCookie, host, a.authCallbackPath, &expires)
+	}
+	if err = a.nonceStore.Set(c, shop, nonce, time.Now().Add(a.nonceTTL)); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to store nonce: %w", err))
+		return
 	}
-	expires := time.Now().Add(time.Hour)
-	SetSignedCookie(c, a.Credentials.ClientSecret, AppStateCookie, nonce, a.authCallbackPath, &expires)
 
-	redirect := fmt.Sprintf("https://%s/admin/oauth/authorize?%s", shop, query.Encode())
+	a.notify(func(obs LifecycleObserver) { obs.OnAuthBegin(c.Request.Context(), shop) })
+	redirect := a.authorizeURL(shop, nonce, mode)
 	logger.With(log.String("redirect", redirect)).Debug("beginning auth, redirecting")
 	c.Redirect(http.StatusFound, redirect)
 	c.Abort()
 }
 
+// AuthorizeURL returns the URL of the OAuth grant screen of shop for the
+// configured scopes, callback and access mode, e.g. to render a link instead
+// of redirecting with Begin. As with Begin, shops without an offline session
+// are asked for an offline token first. The state has to be verified by the
+// callback, Install checks it with the nonce store.
+func (a *App) AuthorizeURL(shop string, state string) (string, error) {
+	return a.AuthorizeURLContext(context.Background(), shop, state)
+}
+
+func (a *App) AuthorizeURLContext(ctx context.Context, shop string, state string) (string, error) {
+	shop, err := a.NormalizeShop(shop)
+	if err != nil {
+		return "", err
+	}
+	return a.authorizeURL(shop, state, a.beginAccessMode(ctx, shop, a.accessMode)), nil
+}
+
+func (a *App) authorizeURL(shop string, state string, mode AccessMode) string {
+	query := url.Values{
+		"client_id":    {a.Credentials.ClientID},
+		"scope":        {a.scopes.String()},
+		"redirect_uri": {a.authCallbackURL},
+		"state":        {state},
+	}
+	if mode == AccessModeOnline {
+		query.Set("grant_options[]", "per-user")
+	}
+	return fmt.Sprintf("https://%s/admin/oauth/authorize?%s", shop, query.Encode())
+}
+
 func (a *App) Install(c *gin.Context) {
+	if a.staticSession != nil {
+		_ = c.AbortWithError(http.StatusNotFound, errOAuthDisabled)
+		return
+	}
 	logger := a.logger(c).With(log.String("shop", c.Query("shop")))
 	logger.Debug("performing install")
 
@@ -173,21 +296,23 @@ func (a *App) Install(c *gin.Context) {
 	}
 
 	state := c.Query("state")
-	defer DeleteCookies(c, AppStateCookie, AppStateCookieSig)
-	if ok, err := CompareSignedCookie(c, a.Credentials.ClientSecret, AppStateCookie, state); !ok {
-		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("app state cookie mismatch"))
-		return
-	} else if err != nil {
-		_ = c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("app state cookie mismatch: %w", err))
+	if err = a.nonceStore.Verify(c, shop, state); err != nil {
+		_ = c.AbortWithError(http.StatusUnauthorized, err)
 		return
 	}
 
-	if !a.ValidHmac(c) {
-		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("hmac validation failed"))
+	if err = verifyOAuthHMAC(c.Request.URL.Query(), a.Credentials.ClientSecret); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("hmac validation failed: %w", err))
 		return
 	}
+	if err = a.checkRequestAge(c.Request.URL.Query()); err != nil {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	setHost(c, a.callbackHost(c, shop))
 
-	token, err := a.AccessToken(shop, c.Query("code"))
+	token, err := a.AccessTokenContext(c.Request.Context(), shop, c.Query("code"))
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve access token: %w", err))
 		return
@@ -196,15 +321,57 @@ func (a *App) Install(c *gin.Context) {
 	logger.Debug("creating new session")
 	sess := a.createSession(shop, state, token)
 	if !a.embedded {
-		SetSignedCookie(c, a.Credentials.ClientSecret, SessionCookie, sess.ID, "/", sess.Expires)
+		a.cookies.setSigned(c, a.Credentials.ClientSecret, SessionCookie, sess.ID, "/", sess.Expires)
+	}
+	firstInstall := false
+	if !sess.IsOnline {
+		prev, err := a.SessionStore.Get(c.Request.Context(), sess.SessionID())
+		firstInstall = IsNotFound(err)
+		if err != nil && !firstInstall {
+			logger.With("error", err).Warn("failed to look up previous session, assuming reinstall")
+		}
+		if prev != nil {
+			sess.Metadata = prev.Metadata
+		}
 	}
 	err = a.SessionStore.Store(c.Request.Context(), sess)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to store session: %w", err))
 		return
 	}
+	if rec, ok := a.SessionStore.(InstallRecorder); ok && !sess.IsOnline {
+		// shops which had a session before the marker was introduced aren't
+		// recorded yet, they still count as reinstalls
+		first, err := rec.RecordInstall(c.Request.Context(), shop)
+		if err != nil {
+			logger.With("error", err).Warn("failed to record install")
+		} else {
+			firstInstall = firstInstall && first
+		}
+	}
+	if err = a.invalidateShop(c.Request.Context(), shop); err != nil {
+		logger.With("error", err).Warn("failed to invalidate shop cache")
+	}
+
+	if sess.IsOnline {
+		redirect := a.installRedirect(c, shop)
+		logger.With(log.String("redirect", redirect)).Debug("online session created, redirecting to app")
+		c.Redirect(http.StatusFound, redirect)
+		c.Abort()
+		return
+	}
 
-	if a.uninstallWebhookEndpoint != "" {
+	if len(a.webhookSubscriptions) > 0 {
+		subs := a.webhookSubscriptions
+		if a.uninstallWebhookEndpoint != "" {
+			subs = append(subs[:len(subs):len(subs)],
+				WebhookSubscription{Topic: "app/uninstalled", CallbackURL: a.uninstallWebhookEndpoint})
+		}
+		logger.Debug("reconciling webhook subscriptions")
+		if err = a.EnsureWebhooks(c.Request.Context(), sess, subs); err != nil {
+			logger.With("error", err).Error("reconciling webhook subscriptions failed")
+		}
+	} else if a.uninstallWebhookEndpoint != "" {
 		wh := Webhook{
 			Topic:   "app/uninstalled",
 			Address: a.uninstallWebhookEndpoint,
@@ -217,76 +384,192 @@ func (a *App) Install(c *gin.Context) {
 		// for concrete errors, so rather assume this won't fail in case the hook
 		// didn't exist yet.
 		// https://community.shopify.com/c/shopify-apps/api-error-response-types/td-p/2268179
-		if _, err = a.RegisterWebhook(&wh, sess); err != nil {
+		if _, err = a.RegisterWebhookContext(c.Request.Context(), &wh, sess); err != nil {
 			logger.With("webhook", wh, "error", err).Debug("registering uninstall webhook failed")
 		}
 	}
 	if a.installHook != nil {
-		logger.Debug("calling install hook")
-		a.installHook()
+		logger.With("first_install", firstInstall).Debug("calling install hook")
+		if err = a.installHook(c.Request.Context(), shop, firstInstall); err != nil {
+			logger.With("error", err).Error("install hook failed")
+		}
+	}
+	a.notify(func(obs LifecycleObserver) { obs.OnInstalled(c.Request.Context(), shop, firstInstall) })
+	if a.accessMode == AccessModeOnline || a.onlineAccessPending(c) {
+		logger.Debug("app installed, beginning auth for online access token")
+		setShop(c, shop)
+		c.Set(accessModeKey, AccessModeOnline)
+		a.Begin(c)
+		return
 	}
-	redirect := "/?" + c.Request.URL.Query().Encode()
+	redirect := a.installRedirect(c, shop)
 	logger.With(log.String("redirect", redirect)).Debug("app installed, redirecting to app")
 	c.Redirect(http.StatusFound, redirect)
 	c.Abort()
 }
 
-func (a *App) getSessionID(c *gin.Context) (string, string, error) {
+// installRedirect returns where to send the merchant after the install. The
+// host param is passed on, embedded apps are opened in the admin of the host
+// for App Bridge to initialize.
+func (a *App) installRedirect(c *gin.Context, shop string) string {
+	if a.postInstallRedirect != nil {
+		redirect := a.postInstallRedirect(shop)
+		u, err := url.Parse(redirect)
+		if err != nil {
+			return redirect
+		}
+		query := u.Query()
+		if query.Get("shop") == "" {
+			query.Set("shop", shop)
+		}
+		if host := GetHost(c); query.Get("host") == "" && host != "" {
+			query.Set("host", host)
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	if !a.embedded {
+		return "/?" + c.Request.URL.Query().Encode()
+	}
+	if host, err := decodeHost(GetHost(c)); err == nil {
+		return "https://" + host + "/apps/" + a.Credentials.ClientID
+	}
+	return "https://" + shop + "/admin/apps/" + a.Credentials.ClientID
+}
+
+var errOAuthDisabled = errors.New("oauth is disabled for apps with a static token")
+
+// staticAuth authenticates requests of apps using WithStaticToken.
+func (a *App) staticAuth(c *gin.Context) bool {
+	if a.staticSession == nil {
+		return false
+	}
+	setShop(c, a.staticSession.Shop)
+	c.Set(ShopSessionKey, a.staticSession)
+	return true
+}
+
+func (a *App) getSessionID(c *gin.Context) (SessionID, string, error) {
 	if a.sessionIDHook != nil {
-		return a.sessionIDHook()
+		id, shop, err := a.sessionIDHook()
+		if err != nil {
+			return SessionID{}, "", err
+		}
+		sessID, err := ParseSessionID(id)
+		return sessID, shop, err
 	}
 	if a.embedded {
 		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
 		if token == "" {
-			return "", "", errors.New("missing 'Authorization' header")
+			return SessionID{}, "", errors.New("missing 'Authorization' header")
 		}
-		return a.parseJWTSessionID(token, false)
+		return a.parseJWTSessionID(token, a.accessMode == AccessModeOnline)
 	}
 	id, err := a.getSessionIDFromCookie(c)
-	return id, "", err
+	if err != nil {
+		return SessionID{}, "", err
+	}
+	sessID, err := ParseSessionID(id)
+	return sessID, "", err
 }
 
 func (a *App) getSessionIDFromCookie(c *gin.Context) (string, error) {
-	if err := ValidateCookieSignature(c, a.Credentials.ClientSecret, SessionCookie); err != nil {
-		DeleteCookies(c, SessionCookie, SessionCookieSig)
+	if err := ValidateCookieSignature(c, a.Credentials.ClientSecret, a.cookies.name(SessionCookie)); err != nil {
+		a.cookies.delete(c, "/", SessionCookie, SessionCookieSig)
 		return "", err
 	}
-	return c.Cookie(SessionCookie)
+	return c.Cookie(a.cookies.name(SessionCookie))
 }
 
 func (a *App) sessionValid(c *gin.Context, sess *Session) bool {
-	logger := a.logger(c)
+	reason := a.sessionInvalidReason(c, sess)
+	if reason == "" {
+		return true
+	}
+	a.logger(c).Debug("session invalid: " + reason)
+	if sess != nil && !c.IsAborted() {
+		a.notify(func(obs LifecycleObserver) { obs.OnReauth(c.Request.Context(), sess.Shop, reason) })
+	}
+	return false
+}
+
+// scopesMatch reports whether scopes satisfy the configured scopes, covering
+// them with scope reconciliation and equal to them otherwise.
+func (a *App) scopesMatch(scopes Scopes) bool {
+	if a.scopeReconciliation {
+		return len(scopes.Missing(a.scopes)) == 0
+	}
+	return scopes.Equal(a.scopes)
+}
+
+// sessionInvalidReason returns why sess must be reauthorized, empty if it's
+// valid.
+func (a *App) sessionInvalidReason(c *gin.Context, sess *Session) string {
 	if sess == nil {
-		logger.Debug("session invalid: nil")
-		return false
+		return "nil"
 	}
 	if sess.AccessToken == "" {
-		logger.Debug("session invalid: empty access token")
-		return false
-	}
-	if sess.Scopes != a.scopes {
-		logger.Debug("session invalid: scopes changed")
-		return false
+		return "empty access token"
+	}
+	scopes := ParseScopes(sess.Scopes)
+	if !a.scopesMatch(scopes) {
+		scopes = a.syncGrantedScopes(c.Request.Context(), sess)
+	}
+	if a.scopeReconciliation {
+		if missing := scopes.Missing(a.scopes); len(missing) > 0 {
+			// Shopify may grant fewer scopes than requested, only ask again
+			// if the configured scopes changed since the session was created.
+			if len(ParseScopes(sess.RequestedScopes).Missing(a.scopes)) > 0 {
+				return "missing scopes " + missing.String()
+			}
+			a.logger(c).Warn("session lacks requested scopes", log.Any("scopes", missing))
+		}
+	} else if !scopes.Equal(a.scopes) {
+		return "scopes changed"
 	}
 	if sess.Expires != nil && time.Now().After(*sess.Expires) {
-		logger.Debug("session invalid: expired")
-		return false
+		return "expired"
 	}
-	client := graphql.NewClient(a.ShopURL(sess.Shop, "graphql.json"), nil).
-		WithRequestModifier(func(r *http.Request) {
-			r.Header.Add("X-Shopify-Access-Token", sess.AccessToken)
-		})
 	var query struct {
 		Shop struct {
 			Name string `json:"name"`
-		} `graphql:"shop"`
+		} `json:"shop"`
+	}
+	err := a.Client.GraphQL(c.Request.Context(), sess, "{ shop { name } }", nil, &query)
+	if errors.Is(err, ErrShopFrozen) || errors.Is(err, ErrShopLocked) {
+		// reauthorizing doesn't help until the shop's plan is settled
+		a.abortUnavailableShop(c, sess.Shop, err)
+		return err.Error()
+	}
+	if errors.Is(err, ErrInvalidToken) {
+		a.discardSession(c.Request.Context(), sess)
+		return "access token rejected"
 	}
-	err := client.Query(c.Request.Context(), &query, nil)
 	if err != nil {
-		logger.Debug(fmt.Sprintf("session invalid: %s", err.Error()))
-		return false
+		return err.Error()
 	}
-	return true
+	return ""
+}
+
+// abortUnavailableShop redirects frozen or locked shops to the page set with
+// WithShopUnavailableRedirect, if any.
+func (a *App) abortUnavailableShop(c *gin.Context, shop string, err error) {
+	if a.shopUnavailableURL == "" {
+		status := http.StatusPaymentRequired
+		if errors.Is(err, ErrShopLocked) {
+			status = http.StatusLocked
+		}
+		_ = c.AbortWithError(status, err)
+		return
+	}
+	redirect, err := url.JoinPath(a.HostURL, a.shopUnavailableURL)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
+		return
+	}
+	setShop(c, shop)
+	setRedirectUri(c, redirect+"?"+url.Values{"shop": {shop}}.Encode())
+	a.redirectOutOfApp(c)
 }
 
 func (a *App) createSession(shop string, state string, token *AccessToken) *Session {
@@ -296,10 +579,12 @@ func (a *App) createSession(shop string, state string, token *AccessToken) *Sess
 	}
 	var exp *time.Time
 	var sessID string
+	var userID int
 
 	if isOnline {
 		expires := time.Now().Add(time.Duration(token.OnlineAccessInfo.Exp * int64(time.Second)))
 		exp = &expires
+		userID = token.OnlineAccessInfo.User.ID
 		if a.embedded {
 			sessID = GetOnlineSessionID(shop, strconv.Itoa(token.OnlineAccessInfo.User.ID))
 		} else {
@@ -313,25 +598,170 @@ func (a *App) createSession(shop string, state string, token *AccessToken) *Sess
 		Shop:             shop,
 		State:            state,
 		IsOnline:         isOnline,
+		UserID:           userID,
 		AccessToken:      token.Token,
 		Scopes:           token.Scopes,
+		RequestedScopes:  a.scopes.String(),
 		Expires:          exp,
 		OnlineAccessInfo: token.OnlineAccessInfo,
 	}
 }
 
+// BeginWithAccessMode returns a Begin handler requesting tokens of mode
+// regardless of WithAccessMode, e.g. to request online tokens for the
+// embedded UI of an app using offline tokens otherwise.
+func (a *App) BeginWithAccessMode(m AccessMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(accessModeKey, m)
+		a.Begin(c)
+	}
+}
+
+// RequireScope responds with 403 to requests whose session, set by the
+// session middleware such as AuthenticatedSession, lacks any of scopes. The
+// App Bridge reauthorize headers point to Begin, which requests the configured
+// scopes, so scopes should be among them.
+func (a *App) RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, _ := c.Get(ShopSessionKey)
+		sess, ok := v.(*Session)
+		if !ok || sess == nil {
+			_ = c.AbortWithError(http.StatusUnauthorized, errors.New("no session, RequireScope must follow the session middleware"))
+			return
+		}
+		missing := ParseScopes(sess.Scopes).Missing(scopes)
+		if len(missing) == 0 {
+			return
+		}
+		a.logger(c).Debug("session lacks required scopes", log.String("shop", sess.Shop), log.Any("scopes", missing))
+		redirect, err := a.authBeginURL(url.Values{"shop": {sess.Shop}})
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
+			return
+		}
+		setRedirectUri(c, redirect)
+		a.appBridgeHeaderRedirect(c)
+	}
+}
+
+// requestedAccessMode returns the mode set by BeginWithAccessMode, the
+// access_mode query parameter, online or offline, or the app's mode.
+func (a *App) requestedAccessMode(c *gin.Context) AccessMode {
+	if m, ok := c.Get(accessModeKey); ok {
+		return m.(AccessMode)
+	}
+	switch c.Query("access_mode") {
+	case "online":
+		return AccessModeOnline
+	case "offline":
+		return AccessModeOffline
+	}
+	return a.accessMode
+}
+
+// onlineAccessPending reports whether Begin deferred a requested online token
+// until the app is installed. The cookie is deleted.
+func (a *App) onlineAccessPending(c *gin.Context) bool {
+	if err := ValidateCookieSignature(c, a.Credentials.ClientSecret, a.cookies.name(AccessModeCookie)); err != nil {
+		return false
+	}
+	mode, err := c.Cookie(a.cookies.name(AccessModeCookie))
+	a.cookies.delete(c, a.authCallbackPath, AccessModeCookie, AccessModeCookieSig)
+	return err == nil && mode == "online"
+}
+
+// callbackHost returns the host param of the callback, or the one Begin
+// stored in a cookie. Hosts not belonging to shop are ignored.
+func (a *App) callbackHost(c *gin.Context, shop string) string {
+	defer a.cookies.delete(c, a.authCallbackPath, HostCookie, HostCookieSig)
+	if host := validHost(c.Query("host"), shop); host != "" {
+		return host
+	}
+	if err := ValidateCookieSignature(c, a.Credentials.ClientSecret, a.cookies.name(HostCookie)); err != nil {
+		return ""
+	}
+	host, err := c.Cookie(a.cookies.name(HostCookie))
+	if err != nil {
+		return ""
+	}
+	return validHost(host, shop)
+}
+
+// beginAccessMode resolves the token type requested by Begin. Online tokens are
+// only requested once an offline token exists for the shop, as the offline
+// token is what marks the app as installed.
+func (a *App) beginAccessMode(ctx context.Context, shop string, requested AccessMode) AccessMode {
+	if requested != AccessModeOnline {
+		return AccessModeOffline
+	}
+	if _, err := a.SessionStore.Get(ctx, OfflineSessionID(shop)); err != nil {
+		return AccessModeOffline
+	}
+	return AccessModeOnline
+}
+
 func (a *App) ValidHmac(c *gin.Context) bool {
-	h, err := hex.DecodeString(c.Query("hmac"))
+	return verifyOAuthHMAC(c.Request.URL.Query(), a.Credentials.ClientSecret) == nil
+}
+
+func verifyOAuthHMAC(query url.Values, secret string) error {
+	mac, err := hex.DecodeString(query.Get("hmac"))
 	if err != nil {
-		return false
+		return errors.New("malformed hmac")
+	}
+	if len(mac) == 0 {
+		return errors.New("missing hmac")
+	}
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(oauthMessage(query)))
+	if !hmac.Equal(mac, hash.Sum(nil)) {
+		return errors.New("hmac mismatch")
+	}
+	return nil
+}
+
+// checkRequestAge checks the timestamp parameter, in Unix seconds, against
+// the max age set by WithRequestMaxAge. The parameter must be verified with
+// the signature first.
+func (a *App) checkRequestAge(query url.Values) error {
+	if a.requestMaxAge == 0 {
+		return nil
+	}
+	secs, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp %q", query.Get("timestamp"))
+	}
+	age := time.Since(time.Unix(secs, 0))
+	if age > a.requestMaxAge+a.requestClockSkew {
+		return fmt.Errorf("request expired, signed %s ago", age.Truncate(time.Second))
 	}
-	q := c.Request.URL.Query()
-	q.Del("hmac")
-	message, _ := url.QueryUnescape(q.Encode())
-	hash := hmac.New(sha256.New, []byte(a.Credentials.ClientSecret))
-	hash.Write([]byte(message))
-	validMac := hash.Sum(nil)
-	return hmac.Equal(h, validMac)
+	if -age > a.requestClockSkew {
+		return errors.New("request timestamp is in the future")
+	}
+	return nil
+}
+
+// oauthMessage builds the message Shopify signs: all params except hmac and
+// signature, sorted by key and joined as key=value pairs. Array params
+// (ids[]=1&ids[]=2) are collapsed into ids=["1", "2"].
+func oauthMessage(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k == "hmac" || k == "signature" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if name, ok := strings.CutSuffix(k, "[]"); ok {
+			pairs = append(pairs, fmt.Sprintf(`%s=["%s"]`, name, strings.Join(query[k], `", "`)))
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, strings.Join(query[k], ",")))
+	}
+	return strings.Join(pairs, "&")
 }
 
 func (a *App) VerifyShopifyOrigin(c *gin.Context) {
@@ -350,6 +780,51 @@ func isEmbedded(c *gin.Context) bool {
 	return c.Query("embedded") == "1"
 }
 
+// unauthenticated handles requests of shops without session by the handler
+// set by WithUnauthenticatedHandler, falling back to fallback.
+func (a *App) unauthenticated(c *gin.Context, shop string, fallback gin.HandlerFunc) {
+	if a.unauthenticatedHandler != nil {
+		setShop(c, shop)
+		if getMetaData(c).redirectUri == "" {
+			redirect, err := a.authBeginURL(url.Values{"shop": {shop}})
+			if err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
+				return
+			}
+			setRedirectUri(c, redirect)
+		}
+		a.unauthenticatedHandler(c)
+		if c.IsAborted() || c.Writer.Written() {
+			c.Abort()
+			return
+		}
+	}
+	fallback(c)
+}
+
+// UnauthenticatedJSON responds with 401 and the auth URL as JSON to fetch
+// requests, i.e. requests accepting JSON but not HTML or sent with
+// X-Requested-With: XMLHttpRequest. Use it with WithUnauthenticatedHandler.
+func UnauthenticatedJSON(c *gin.Context) {
+	accept := c.GetHeader("Accept")
+	xhr := c.GetHeader("X-Requested-With") == "XMLHttpRequest"
+	if !xhr && !(strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")) {
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"error":       "unauthenticated",
+		"redirectUri": GetAuthRedirectURI(c),
+	})
+}
+
+func (a *App) authBeginURL(query url.Values) (string, error) {
+	u, err := url.JoinPath(a.HostURL, a.authBeginEndpoint)
+	if err != nil {
+		return "", err
+	}
+	return u + "?" + query.Encode(), nil
+}
+
 func (a *App) redirectToAuth(c *gin.Context) {
 	shop := mustGetShop(c)
 	logger := a.logger(c).With(log.String("shop", shop))
@@ -359,8 +834,7 @@ func (a *App) redirectToAuth(c *gin.Context) {
 			_ = c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
-		redirect, err := url.JoinPath(a.HostURL,
-			fmt.Sprintf("%s?%s", a.authBeginEndpoint, url.Values{"shop": {shop}, "host": {host}}.Encode()))
+		redirect, err := a.authBeginURL(url.Values{"shop": {shop}, "host": {host}})
 		if err != nil {
 			_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to construct redirect uri: %w", err))
 			return
@@ -0,0 +1,707 @@
+package shopigo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type AuthTestSuite struct {
+	suite.Suite
+}
+
+func TestAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthTestSuite))
+}
+
+// example request from the Shopify OAuth documentation, signed with secret "hush"
+const oauthQuery = "code=0907a61c0c8d55e99db179b68161bc00&hmac=700e2dadb827fcc8609e9d5ce208b2e9cdaab9df07390d2cbca10d7c328fc4bf&shop=some-shop.myshopify.com&state=0.6784241404160823&timestamp=1337178173"
+
+func (s *AuthTestSuite) TestVerifyOAuthHMAC() {
+	q, err := url.ParseQuery(oauthQuery)
+	s.Require().NoError(err)
+	s.NoError(verifyOAuthHMAC(q, "hush"))
+}
+
+func (s *AuthTestSuite) TestFailVerifyOAuthHMAC() {
+	for name, modify := range map[string]func(q url.Values){
+		"tampered param": func(q url.Values) { q.Set("shop", "evil-shop.myshopify.com") },
+		"added param":    func(q url.Values) { q.Set("embedded", "1") },
+		"missing hmac":   func(q url.Values) { q.Del("hmac") },
+		"malformed hmac": func(q url.Values) { q.Set("hmac", "not-hex") },
+	} {
+		q, err := url.ParseQuery(oauthQuery)
+		s.Require().NoError(err)
+		modify(q)
+		s.Error(verifyOAuthHMAC(q, "hush"), name)
+	}
+	q, err := url.ParseQuery(oauthQuery)
+	s.Require().NoError(err)
+	s.Error(verifyOAuthHMAC(q, "wrong-secret"))
+}
+
+func (s *AuthTestSuite) TestSignedCookieNonceStore() {
+	store := NewSignedCookieNonceStore("secret", "/auth/install")
+	for name, tc := range map[string]struct {
+		expires time.Time
+		state   string
+		valid   bool
+	}{
+		"valid":         {expires: time.Now().Add(time.Minute), state: "nonce", valid: true},
+		"stale":         {expires: time.Now().Add(-time.Minute), state: "nonce"},
+		"mismatch":      {expires: time.Now().Add(time.Minute), state: "other"},
+		"missing state": {expires: time.Now().Add(time.Minute), state: ""},
+	} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		s.Require().NoError(store.Set(c, "test.myshopify.com", "nonce", tc.expires))
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/install", nil)
+		for _, cookie := range rec.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		c, _ = gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		err := store.Verify(c, "test.myshopify.com", tc.state)
+		if tc.valid {
+			s.NoError(err, name)
+		} else {
+			s.ErrorIs(err, ErrInvalidNonce, name)
+		}
+	}
+}
+
+func (s *AuthTestSuite) TestMissingScopes() {
+	s.Empty(ParseScopes("write_products,read_orders").Missing(ParseScopes("read_orders,read_products")))
+	s.Empty(ParseScopes("read_orders, write_products").Missing(ParseScopes("write_products,read_orders")))
+	s.Equal(Scopes{"read_customers"}, ParseScopes("read_orders").Missing(ParseScopes("read_customers,read_orders")))
+	s.Equal(Scopes{"write_products"}, ParseScopes("read_products").Missing(ParseScopes("write_products")))
+}
+
+func (s *AuthTestSuite) TestScopeReconciliation() {
+	c := testAppConfig()
+	app, err := NewApp(c, WithScopes([]string{"read_products", "read_orders"}), WithScopeReconciliation(true))
+	s.Require().NoError(err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	s.False(app.sessionValid(ctx, &Session{Shop: "test.myshopify.com", AccessToken: "token",
+		Scopes: "read_products", RequestedScopes: "read_products"}), "new scopes must trigger reauth")
+}
+
+func (s *AuthTestSuite) TestShopUnavailableRedirect() {
+	c := testAppConfig()
+	c.HostURL = "https://app.example.com"
+	app, err := NewApp(c, WithShopUnavailableRedirect("/reactivate"), WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusPaymentRequired, nil, ""), nil
+		}),
+	}))
+	s.Require().NoError(err)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	s.False(app.sessionValid(ctx, &Session{Shop: "test.myshopify.com", AccessToken: "token", Scopes: app.scopes.String()}))
+	s.True(ctx.IsAborted())
+	s.Equal(http.StatusFound, rec.Code)
+	s.Equal("https://app.example.com/reactivate?shop=test.myshopify.com", rec.Header().Get("Location"))
+}
+
+func (s *AuthTestSuite) TestStaticToken() {
+	store := &inMemSessionStore{}
+	app, err := NewApp(testAppConfig(), WithSessionStore(store), WithStaticToken("test.myshopify.com", "shpat_token"))
+	s.Require().NoError(err)
+	sess, err := store.Get(context.Background(), OfflineSessionID("test.myshopify.com"))
+	s.Require().NoError(err)
+	s.Equal("shpat_token", sess.AccessToken)
+
+	rec := httptest.NewRecorder()
+	_, e := gin.CreateTestContext(rec)
+	e.GET("/api", app.ValidateAuthenticatedSession, func(c *gin.Context) {
+		c.String(http.StatusOK, MustGetShopSession(c).AccessToken)
+	})
+	e.GET("/auth/begin", app.Begin)
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("shpat_token", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/begin?shop=test.myshopify.com", nil))
+	s.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (s *AuthTestSuite) TestExchangeCode() {
+	store := &inMemSessionStore{}
+	var params map[string]string
+	c := testAppConfig()
+	c.ClientID, c.ClientSecret = "id", "secret"
+	app, err := NewApp(c, WithSessionStore(store), WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			s.Equal("https://test.myshopify.com/admin/oauth/access_token", req.URL.String())
+			s.NoError(json.NewDecoder(req.Body).Decode(&params))
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":"write_orders,read_customers"}`), nil
+		}),
+	}))
+	s.Require().NoError(err)
+
+	sess, err := app.ExchangeCode(context.Background(), "test.myshopify.com", "code")
+	s.Require().NoError(err)
+	s.Equal(map[string]string{"client_id": "id", "client_secret": "secret", "code": "code"}, params)
+	s.Equal(GetOfflineSessionID("test.myshopify.com"), sess.ID)
+	s.Equal("token", sess.AccessToken)
+	s.Equal("read_customers,write_orders", sess.Scopes)
+	s.Zero(store.Len(), "ExchangeCode must not store the session")
+}
+
+func (s *AuthTestSuite) TestTokenEndpoint() {
+	var params map[string]string
+	c := testAppConfig()
+	c.ClientID, c.ClientSecret = "id", "secret"
+	app, err := NewApp(c, WithTokenEndpoint("/mock/token"),
+		WithAPIHostOverride(func(shop string) string { return "http://localhost:8080/" + shop }),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			s.Equal("http://localhost:8080/test.myshopify.com/mock/token", req.URL.String())
+			s.NoError(json.NewDecoder(req.Body).Decode(&params))
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":"read_products"}`), nil
+		})}))
+	s.Require().NoError(err)
+
+	_, err = app.ExchangeCode(context.Background(), "test.myshopify.com", "code",
+		GrantOptions("per-user"), TokenParam{Key: "code", Value: "other"})
+	s.Require().NoError(err)
+	s.Equal(map[string]string{"client_id": "id", "client_secret": "secret", "code": "code",
+		"grant_options[]": "per-user"}, params)
+
+	_, err = NewApp(testAppConfig(), WithTokenEndpoint("oauth/token"))
+	s.Error(err)
+}
+
+func (s *AuthTestSuite) TestCookieOptions() {
+	for name, tc := range map[string]struct {
+		opts []Opt
+		exp  []string
+	}{
+		"embedded": {
+			exp: []string{"shopify_app_state=", "Path=/auth/install", "HttpOnly", "Secure", "SameSite=None", "Partitioned"},
+		},
+		"standalone": {
+			opts: []Opt{WithIsEmbedded(false)},
+			exp:  []string{"shopify_app_state=", "Path=/auth/install", "HttpOnly", "Secure", "SameSite=Lax"},
+		},
+		"custom": {
+			opts: []Opt{WithCookieOptions(CookieOptions{SameSite: http.SameSiteStrictMode, Domain: "app.example.com",
+				Prefix: "__Host-"})},
+			exp: []string{"__Host-shopify_app_state=", "Path=/auth/install", "Domain=app.example.com", "HttpOnly",
+				"SameSite=Strict"},
+		},
+	} {
+		app, err := NewApp(testAppConfig(), tc.opts...)
+		s.Require().NoError(err)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		s.Require().NoError(app.nonceStore.Set(c, "test.myshopify.com", "nonce", time.Now().Add(time.Minute)))
+
+		cookies := rec.Header().Values("Set-Cookie")
+		s.Require().Len(cookies, 2, name)
+		attrs := strings.Split(cookies[0], "; ")
+		for _, exp := range tc.exp {
+			found := false
+			for _, attr := range attrs {
+				found = found || strings.HasPrefix(attr, exp)
+			}
+			s.True(found, "%s: %s missing in %s", name, exp, cookies[0])
+		}
+		s.Len(attrs, len(tc.exp)+1, "%s: unexpected attributes in %s", name, cookies[0])
+	}
+}
+
+func (s *AuthTestSuite) TestUnauthenticatedHandler() {
+	c := testAppConfig()
+	c.HostURL = "https://app.example.com"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithUnauthenticatedHandler(UnauthenticatedJSON))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/", app.EnsureInstalledOnShop, func(c *gin.Context) {
+		s.Fail("handler must not run without session")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?shop=test.myshopify.com", nil)
+	req.Header.Set("Accept", "application/json")
+	e.ServeHTTP(rec, req)
+	s.Equal(http.StatusUnauthorized, rec.Code)
+	s.JSONEq(`{"error":"unauthenticated","redirectUri":"https://app.example.com/auth/begin?shop=test.myshopify.com"}`,
+		rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/?shop=test.myshopify.com", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	e.ServeHTTP(rec, req)
+	s.Equal(http.StatusFound, rec.Code)
+}
+
+type acceptingNonceStore struct{}
+
+func (acceptingNonceStore) Set(c *gin.Context, shop string, nonce string, expires time.Time) error {
+	return nil
+}
+
+func (acceptingNonceStore) Verify(c *gin.Context, shop string, nonce string) error {
+	return nil
+}
+
+func (s *AuthTestSuite) TestInstallHook() {
+	type call struct {
+		shop         string
+		firstInstall bool
+	}
+	var calls []call
+	c := testAppConfig()
+	c.HostURL = "https://app.example.com"
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+		})}),
+		WithHooks(HookInstall(func(ctx context.Context, shop string, firstInstall bool) error {
+			calls = append(calls, call{shop, firstInstall})
+			return errors.New("failed to seed config")
+		})))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/install", app.Install)
+	install := func() {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/install?"+oauthQuery, nil))
+		s.Equal(http.StatusFound, rec.Code)
+	}
+	install()
+	install()
+	s.Require().NoError(app.HandleUninstalled(context.Background(), &WebhookContext{Shop: "some-shop.myshopify.com"}))
+	install()
+	s.Equal([]call{{"some-shop.myshopify.com", true}, {"some-shop.myshopify.com", false},
+		{"some-shop.myshopify.com", false}}, calls, "reinstalls after uninstall aren't first installs")
+}
+
+func (s *AuthTestSuite) TestInstallRedirect() {
+	host := base64.RawURLEncoding.EncodeToString([]byte("admin.shopify.com/store/some-shop"))
+	q, err := url.ParseQuery(oauthQuery)
+	s.Require().NoError(err)
+	q.Set("host", host)
+	hash := hmac.New(sha256.New, []byte("hush"))
+	hash.Write([]byte(oauthMessage(q)))
+	q.Set("hmac", hex.EncodeToString(hash.Sum(nil)))
+
+	install := func(query string, opts ...Opt) string {
+		c := testAppConfig()
+		c.ClientSecret = "hush"
+		app, err := NewApp(c, append([]Opt{WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+			WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+			})})}, opts...)...)
+		s.Require().NoError(err)
+		_, e := gin.CreateTestContext(httptest.NewRecorder())
+		e.GET("/auth/install", app.Install)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/install?"+query, nil))
+		s.Equal(http.StatusFound, rec.Code)
+		return rec.Header().Get("Location")
+	}
+
+	s.Equal("https://admin.shopify.com/store/some-shop/apps/client-id", install(q.Encode()))
+	s.Equal("https://some-shop.myshopify.com/admin/apps/client-id", install(oauthQuery))
+	s.Equal("https://app.example.com/dashboard?host="+host+"&shop=some-shop.myshopify.com&tab=home",
+		install(q.Encode(), WithPostInstallRedirect(func(shop string) string {
+			return "https://app.example.com/dashboard?tab=home"
+		})))
+}
+
+func (s *AuthTestSuite) TestHostCarriedThroughOAuth() {
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+		})}))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/begin", app.Begin)
+	e.GET("/auth/install", app.Install)
+	flow := func(host string) string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/begin?shop=some-shop.myshopify.com&host="+host, nil))
+		s.Equal(http.StatusFound, rec.Code)
+		req := httptest.NewRequest(http.MethodGet, "/auth/install?"+oauthQuery, nil)
+		for _, cookie := range rec.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		s.Equal(http.StatusFound, rec.Code)
+		return rec.Header().Get("Location")
+	}
+
+	s.Equal("https://admin.shopify.com/store/some-shop/apps/client-id",
+		flow(base64.RawURLEncoding.EncodeToString([]byte("admin.shopify.com/store/some-shop"))))
+	// hosts of other shops are dropped
+	s.Equal("https://some-shop.myshopify.com/admin/apps/client-id",
+		flow(base64.RawURLEncoding.EncodeToString([]byte("admin.shopify.com/store/evil-shop"))))
+}
+
+func (s *AuthTestSuite) TestHTTPHandlers() {
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+		WithAuthBeginEndpoint("/shopify/begin"), WithAuthCallbackEndpoint("/shopify/callback"),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+		})}))
+	s.Require().NoError(err)
+	mux := http.NewServeMux()
+	mux.Handle("/shopify/begin", app.AuthBeginHandler())
+	mux.Handle("/shopify/callback", app.AuthCallbackHandler())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shopify/begin?shop=some-shop.myshopify.com", nil))
+	s.Require().Equal(http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	s.Require().NoError(err)
+	s.Equal("some-shop.myshopify.com", location.Host)
+	s.Equal("https://app.example.com/shopify/callback", location.Query().Get("redirect_uri"))
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shopify/callback?"+oauthQuery, nil))
+	s.Equal(http.StatusFound, rec.Code)
+	_, err = app.SessionStore.Get(context.Background(), OfflineSessionID("some-shop.myshopify.com"))
+	s.NoError(err)
+}
+
+func (s *AuthTestSuite) TestAuthorizeURL() {
+	app, err := NewApp(testAppConfig(), WithSessionStore(&inMemSessionStore{}), WithScopes([]string{"write_products", "read_orders"}))
+	s.Require().NoError(err)
+	u, err := app.AuthorizeURL("Some-Shop.myshopify.com", "nonce")
+	s.Require().NoError(err)
+	s.Equal("https://some-shop.myshopify.com/admin/oauth/authorize?client_id=client-id"+
+		"&redirect_uri=https%3A%2F%2Fapp.example.com%2Fauth%2Finstall&scope=read_orders%2Cwrite_products&state=nonce", u)
+
+	WithAccessMode(AccessModeOnline)(app)
+	u, err = app.AuthorizeURL("some-shop", "nonce")
+	s.Require().NoError(err)
+	parsed, err := url.Parse(u)
+	s.Require().NoError(err)
+	s.Empty(parsed.Query().Get("grant_options[]"), "new installs must request an offline token first")
+
+	s.Require().NoError(app.SessionStore.Store(context.Background(), &Session{
+		ID: GetOfflineSessionID("some-shop.myshopify.com"), Shop: "some-shop.myshopify.com", AccessToken: "token"}))
+	u, err = app.AuthorizeURL("some-shop", "nonce")
+	s.Require().NoError(err)
+	parsed, err = url.Parse(u)
+	s.Require().NoError(err)
+	s.Equal("per-user", parsed.Query().Get("grant_options[]"))
+
+	_, err = app.AuthorizeURL("evil.example.com", "nonce")
+	s.Error(err)
+}
+
+func (s *AuthTestSuite) TestTopLevelRedirect() {
+	app, err := NewApp(testAppConfig(), WithNonceStore(acceptingNonceStore{}))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/begin", app.Begin)
+	begin := func(query string, modify func(r *http.Request)) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/auth/begin?"+query, nil)
+		if modify != nil {
+			modify(req)
+		}
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := begin("shop=some-shop.myshopify.com", func(r *http.Request) { r.Header.Set("Sec-Fetch-Dest", "iframe") })
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), `window.top.location.href = "https://app.example.com/auth/begin?shop=some-shop.myshopify.com"`)
+
+	rec = begin("embedded=1&shop=some-shop.myshopify.com", nil)
+	s.Equal(http.StatusOK, rec.Code)
+	s.NotContains(rec.Body.String(), "embedded")
+
+	rec = begin("shop=some-shop.myshopify.com", func(r *http.Request) { r.Header.Set("Sec-Fetch-Dest", "document") })
+	s.Equal(http.StatusFound, rec.Code)
+	var topLevel *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == TopLevelCookie {
+			topLevel = cookie
+		}
+	}
+	s.Require().NotNil(topLevel)
+	s.Equal(http.SameSiteLaxMode, topLevel.SameSite)
+	rec = begin("embedded=1&shop=some-shop.myshopify.com", func(r *http.Request) { r.AddCookie(topLevel) })
+	s.Equal(http.StatusFound, rec.Code)
+
+	tmpl := template.Must(template.New("custom").Parse(`bouncing {{.Shop}}`))
+	WithTopLevelRedirectTemplate(tmpl)(app)
+	rec = begin("embedded=1&shop=some-shop.myshopify.com", nil)
+	s.Equal("bouncing some-shop.myshopify.com", rec.Body.String())
+}
+
+func (s *AuthTestSuite) TestScopes() {
+	scopes := ParseScopes("write_products, read_orders,read_orders,")
+	s.Equal("read_orders,write_products", scopes.String())
+	s.True(scopes.Has("read_products"))
+	s.False(scopes.Has("write_orders"))
+	s.True(scopes.Equal(ParseScopes("write_products,read_orders")))
+	s.False(scopes.Equal(ParseScopes("read_orders,read_products")))
+	s.Empty(ParseScopes("").String())
+}
+
+func (s *AuthTestSuite) TestUnsortedScopes() {
+	s.Equal("write_products,read_orders", ParseScopesUnsorted("write_products, read_orders,write_products").String())
+
+	c := testAppConfig()
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithUnsortedScopes([]string{"write_products", "read_orders", "write_products"}),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":"read_orders,read_customers,write_products"}`), nil
+		})}))
+	s.Require().NoError(err)
+	s.Equal("write_products,read_orders", app.scopes.String())
+	sess, err := app.ExchangeCode(context.Background(), "test.myshopify.com", "code")
+	s.Require().NoError(err)
+	s.Equal("write_products,read_orders,read_customers", sess.Scopes)
+	s.True(app.scopes.Equal(ParseScopes("read_orders,write_products")))
+}
+
+func (s *AuthTestSuite) TestAccessModeOverride() {
+	store := &inMemSessionStore{}
+	c := testAppConfig()
+	c.HostURL = "https://app.example.com"
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(store), WithNonceStore(acceptingNonceStore{}),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+		})}))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/begin", app.Begin)
+	e.GET("/auth/online", app.BeginWithAccessMode(AccessModeOnline))
+	e.GET("/auth/install", app.Install)
+	perUser := func(rec *httptest.ResponseRecorder) bool {
+		u, err := url.Parse(rec.Header().Get("Location"))
+		s.Require().NoError(err)
+		return u.Query().Get("grant_options[]") == "per-user"
+	}
+
+	// not installed yet, the online token is requested after the offline one
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/online?shop=some-shop.myshopify.com", nil))
+	s.Equal(http.StatusFound, rec.Code)
+	s.False(perUser(rec))
+	req := httptest.NewRequest(http.MethodGet, "/auth/install?"+oauthQuery, nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	s.Equal(http.StatusFound, rec.Code)
+	s.True(perUser(rec))
+	_, err = GetOfflineSession(context.Background(), store, "some-shop.myshopify.com")
+	s.NoError(err)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/begin?shop=some-shop.myshopify.com&access_mode=online", nil))
+	s.True(perUser(rec))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/begin?shop=some-shop.myshopify.com", nil))
+	s.False(perUser(rec))
+}
+
+// example request from the Shopify app proxy documentation, signed with secret "hush"
+const appProxyQuery = "extra=1&extra=2&shop=shop-name.myshopify.com&logged_in_customer_id=1&path_prefix=%2Fapps%2Fawesome_reviews&timestamp=1317327555&signature=4c68c8624d737112c91818c11017d24d334b524cb5c2b8ba08daa056f7395ddb"
+
+func (s *AuthTestSuite) TestVerifyAppProxy() {
+	q, err := url.ParseQuery(appProxyQuery)
+	s.Require().NoError(err)
+	s.True(VerifyAppProxy(q, "hush"))
+	s.False(VerifyAppProxy(q, "wrong-secret"))
+	q.Set("extra", "1")
+	s.False(VerifyAppProxy(q, "hush"))
+
+	store := &inMemSessionStore{}
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(store))
+	s.Require().NoError(err)
+	s.Require().NoError(store.Store(context.Background(), &Session{ID: GetOfflineSessionID("shop-name.myshopify.com"),
+		Shop: "shop-name.myshopify.com", AccessToken: "token"}))
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/proxy", app.VerifyAppProxyRequest, func(c *gin.Context) {
+		c.String(http.StatusOK, MustGetShopSession(c).AccessToken)
+	})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proxy?"+appProxyQuery, nil))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("token", rec.Body.String())
+}
+
+func (s *AuthTestSuite) TestRequestMaxAge() {
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+		WithRequestMaxAge(time.Minute, 10*time.Second))
+	s.Require().NoError(err)
+	now := time.Now()
+	for ts, valid := range map[string]bool{
+		strconv.FormatInt(now.Unix(), 10):                      true,
+		strconv.FormatInt(now.Add(-65*time.Second).Unix(), 10): true,
+		strconv.FormatInt(now.Add(5*time.Second).Unix(), 10):   true,
+		strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10):  false,
+		strconv.FormatInt(now.Add(time.Minute).Unix(), 10):     false,
+		"":          false,
+		"yesterday": false,
+	} {
+		err = app.checkRequestAge(url.Values{"timestamp": {ts}})
+		s.Equal(valid, err == nil, "%s: %v", ts, err)
+	}
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/install", app.Install)
+	e.GET("/proxy", app.VerifyAppProxyRequest)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/install?"+oauthQuery, nil))
+	s.Equal(http.StatusBadRequest, rec.Code, "the example callback is from 2012")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proxy?"+appProxyQuery, nil))
+	s.Equal(http.StatusUnauthorized, rec.Code)
+
+	_, err = NewApp(testAppConfig(), WithRequestMaxAge(0, 0))
+	s.Error(err)
+}
+
+func (s *AuthTestSuite) TestRequireScope() {
+	app, err := NewApp(testAppConfig(), WithSessionStore(&inMemSessionStore{}))
+	s.Require().NoError(err)
+	serve := func(sess *Session, scopes ...string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(rec)
+		e.GET("/api", func(c *gin.Context) {
+			if sess != nil {
+				c.Set(ShopSessionKey, sess)
+			}
+		}, app.RequireScope(scopes...), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		return rec
+	}
+	sess := &Session{Shop: "test.myshopify.com", Scopes: "write_orders,read_products"}
+
+	s.Equal(http.StatusNoContent, serve(sess, "write_orders").Code)
+	s.Equal(http.StatusNoContent, serve(sess, "read_orders", "read_products").Code, "write implies read")
+	rec := serve(sess, "write_products")
+	s.Equal(http.StatusForbidden, rec.Code)
+	s.Equal("1", rec.Header().Get("X-Shopify-API-Request-Failure-Reauthorize"))
+	s.Equal("https://app.example.com/auth/begin?shop=test.myshopify.com",
+		rec.Header().Get("X-Shopify-API-Request-Failure-Reauthorize-Url"))
+	s.Equal(http.StatusUnauthorized, serve(nil, "write_orders").Code)
+}
+
+func (s *AuthTestSuite) TestRequireScopeMiddleware() {
+	app, err := NewApp(testAppConfig(), WithSessionStore(&inMemSessionStore{}))
+	s.Require().NoError(err)
+	handler := app.RequireScopeMiddleware("write_orders")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(sess *Session) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if sess != nil {
+			req = req.WithContext(ContextWithSession(req.Context(), sess))
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	s.Equal(http.StatusNoContent, serve(&Session{Shop: "test.myshopify.com", Scopes: "write_orders"}).Code)
+	rec := serve(&Session{Shop: "test.myshopify.com", Scopes: "read_orders"})
+	s.Equal(http.StatusForbidden, rec.Code)
+	s.Equal("https://app.example.com/auth/begin?shop=test.myshopify.com",
+		rec.Header().Get("X-Shopify-API-Request-Failure-Reauthorize-Url"))
+	s.Equal(http.StatusUnauthorized, serve(nil).Code)
+}
+
+type recordingObserver struct {
+	NoopLifecycleObserver
+	events []string
+}
+
+func (r *recordingObserver) OnAuthBegin(_ context.Context, shop string) {
+	r.events = append(r.events, "begin "+shop)
+}
+
+func (r *recordingObserver) OnInstalled(_ context.Context, shop string, firstTime bool) {
+	r.events = append(r.events, fmt.Sprintf("installed %s %t", shop, firstTime))
+}
+
+func (r *recordingObserver) OnUninstalled(_ context.Context, shop string) {
+	r.events = append(r.events, "uninstalled "+shop)
+}
+
+func (r *recordingObserver) OnReauth(_ context.Context, shop string, reason string) {
+	r.events = append(r.events, "reauth "+shop+": "+reason)
+}
+
+func (r *recordingObserver) OnTokenRefreshed(_ context.Context, shop string) {
+	r.events = append(r.events, "refreshed "+shop)
+}
+
+func (s *AuthTestSuite) TestLifecycleObserver() {
+	obs := &recordingObserver{}
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithNonceStore(acceptingNonceStore{}),
+		WithLifecycleObserver(obs), WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"access_token":"token","scope":""}`), nil
+		})}))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/begin", app.Begin)
+	e.GET("/auth/install", app.Install)
+	for _, target := range []string{"/auth/begin?shop=some-shop.myshopify.com", "/auth/install?" + oauthQuery,
+		"/auth/install?" + oauthQuery} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		s.Equal(http.StatusFound, rec.Code, target)
+	}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	expired := time.Now().Add(-time.Minute)
+	s.False(app.sessionValid(ctx, &Session{Shop: "some-shop.myshopify.com", AccessToken: "token", Expires: &expired}))
+	_, err = app.TokenExchange(context.Background(), "some-shop.myshopify.com", "session-token", OfflineAccessToken)
+	s.Require().NoError(err)
+	s.Require().NoError(app.HandleUninstalled(context.Background(), &WebhookContext{Shop: "some-shop.myshopify.com"}))
+
+	s.Equal([]string{
+		"begin some-shop.myshopify.com",
+		"installed some-shop.myshopify.com true",
+		"installed some-shop.myshopify.com false",
+		"reauth some-shop.myshopify.com: expired",
+		"refreshed some-shop.myshopify.com",
+		"uninstalled some-shop.myshopify.com",
+	}, obs.events)
+}
@@ -0,0 +1,53 @@
+package shopigo
+
+import (
+	"errors"
+	"net/http"
+)
+
+// AuthStrategy adds the credentials of a session to requests to the Admin
+// API, see WithAuthStrategy.
+type AuthStrategy interface {
+	Authenticate(req *http.Request, sess *Session)
+}
+
+// HeaderTokenAuth sends the access token of the session in the
+// X-Shopify-Access-Token header. It's the default.
+type HeaderTokenAuth struct{}
+
+func (HeaderTokenAuth) Authenticate(req *http.Request, sess *Session) {
+	req.Header.Set(XAccessToken, sess.AccessToken)
+}
+
+type basicAuth struct {
+	apiKey   string
+	password string
+}
+
+// BasicAuth authenticates as a legacy private app with its API key and
+// password, the sessions' tokens are ignored.
+func BasicAuth(apiKey string, password string) AuthStrategy {
+	return basicAuth{apiKey: apiKey, password: password}
+}
+
+func (b basicAuth) Authenticate(req *http.Request, _ *Session) {
+	req.SetBasicAuth(b.apiKey, b.password)
+}
+
+func WithAuthStrategy(s AuthStrategy) Opt {
+	return func(a *App) {
+		if s == nil {
+			a.optError(errors.New("auth strategy must not be nil"))
+			return
+		}
+		a.authStrategy = s
+	}
+}
+
+func (c *Client) authenticate(req *http.Request, sess *Session) {
+	if c.authStrategy == nil {
+		HeaderTokenAuth{}.Authenticate(req, sess)
+		return
+	}
+	c.authStrategy.Authenticate(req, sess)
+}
@@ -0,0 +1,295 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GraphQLRequest is a query of a batch. Query is a query document such as
+// `query($id: ID!) { product(id: $id) { title } }`, fragments and mutations
+// aren't supported.
+type GraphQLRequest struct {
+	Query     string
+	Variables map[string]any
+	Out       any
+}
+
+// GraphQLResult holds the outcome of a request of a batch. Err holds the
+// GraphQLErrors or UserErrors of the request, its Out is populated otherwise.
+type GraphQLResult struct {
+	Data json.RawMessage
+	Err  error
+}
+
+// GraphQLBatch runs independent queries in a single request. The top level
+// fields and variables of each query are prefixed with an alias, the response
+// is split back into one result per request in the order of reqs. Errors
+// without a path, e.g. an invalid document or an exceeded cost, fail the whole
+// batch.
+func (c *Client) GraphQLBatch(ctx context.Context, sess *Session, reqs []GraphQLRequest) ([]GraphQLResult, error) {
+	var defs, fields []string
+	vars := map[string]any{}
+	for i, req := range reqs {
+		prefix := batchPrefix(i)
+		def, selection, err := aliasQuery(req.Query, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query %d: %w", i, err)
+		}
+		if def != "" {
+			defs = append(defs, def)
+		}
+		fields = append(fields, selection)
+		for k, v := range req.Variables {
+			vars[prefix+k] = v
+		}
+	}
+	query := "query batch"
+	if len(defs) > 0 {
+		query += "(" + strings.Join(defs, ", ") + ")"
+	}
+	query += " {\n" + strings.Join(fields, "\n") + "\n}"
+
+	body, err := jsonMarshal(graphQLRequest{Query: query, Variables: vars})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request object: %w", err)
+	}
+	gqlResp, err := c.graphQLThrottled(ctx, sess, body)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]json.RawMessage
+	if len(gqlResp.Data) > 0 {
+		if err = jsonUnmarshal(gqlResp.Data, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode response data: %w", err)
+		}
+	}
+	results := make([]GraphQLResult, len(reqs))
+	errs := make([]GraphQLErrors, len(reqs))
+	for _, e := range gqlResp.Errors {
+		i, ok := batchIndex(e.Path, len(reqs))
+		if !ok {
+			return nil, gqlResp.Errors
+		}
+		e.Path[0] = strings.TrimPrefix(e.Path[0].(string), batchPrefix(i))
+		errs[i] = append(errs[i], e)
+	}
+	for i, req := range reqs {
+		prefix := batchPrefix(i)
+		fields := map[string]json.RawMessage{}
+		for k, v := range data {
+			if strings.HasPrefix(k, prefix) {
+				fields[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+		if results[i].Data, err = jsonMarshal(fields); err != nil {
+			return nil, fmt.Errorf("failed to encode response data: %w", err)
+		}
+		if len(errs[i]) > 0 {
+			results[i].Err = errs[i]
+			continue
+		}
+		if req.Out != nil {
+			if err = jsonUnmarshal(results[i].Data, req.Out); err != nil {
+				results[i].Err = fmt.Errorf("failed to decode response data: %w", err)
+				continue
+			}
+		}
+		if userErrs := findUserErrors(results[i].Data); len(userErrs) > 0 {
+			results[i].Err = userErrs
+		}
+	}
+	return results, nil
+}
+
+func batchPrefix(i int) string {
+	return fmt.Sprintf("q%d_", i)
+}
+
+// batchIndex returns the request an error belongs to by the alias of its
+// path.
+func batchIndex(path []any, n int) (int, bool) {
+	if len(path) == 0 {
+		return 0, false
+	}
+	field, ok := path[0].(string)
+	if !ok {
+		return 0, false
+	}
+	var i int
+	if _, err := fmt.Sscanf(field, "q%d_", &i); err != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, strings.HasPrefix(field, batchPrefix(i))
+}
+
+// aliasQuery prefixes the variables and top level fields of the query and
+// returns its variable definitions and selection set without braces.
+func aliasQuery(query string, prefix string) (string, string, error) {
+	query = renameVariables(query, prefix)
+	start := indexOutsideStrings(query, '{')
+	if start < 0 {
+		return "", "", errors.New("missing selection set")
+	}
+	var def string
+	header := strings.TrimSpace(query[:start])
+	if header != "" {
+		name, rest, _ := strings.Cut(header, "(")
+		if op := strings.Fields(name); len(op) == 0 || op[0] != "query" {
+			return "", "", errors.New("only queries can be batched")
+		}
+		if rest != "" {
+			end := strings.LastIndex(rest, ")")
+			if end < 0 {
+				return "", "", errors.New("unterminated variable definitions")
+			}
+			def = strings.TrimSpace(rest[:end])
+		}
+	}
+	end := matchingBrace(query, start)
+	if end < 0 {
+		return "", "", errors.New("unterminated selection set")
+	}
+	if strings.TrimSpace(query[end+1:]) != "" {
+		return "", "", errors.New("fragments and multiple operations aren't supported")
+	}
+	selection, err := aliasFields(query[start+1:end], prefix)
+	return def, selection, err
+}
+
+// aliasFields prefixes the alias of each field at the top level of the
+// selection set, fields without alias are aliased by their name.
+func aliasFields(selection string, prefix string) (string, error) {
+	var b strings.Builder
+	depth := 0
+	prev := byte(0)
+	for i := 0; i < len(selection); {
+		ch := selection[i]
+		switch {
+		case ch == '"' || ch == '#':
+			end := skipIgnored(selection, i)
+			b.WriteString(selection[i:end])
+			i = end
+			continue
+		case ch == '{' || ch == '(':
+			depth++
+		case ch == '}' || ch == ')':
+			depth--
+		case ch == '.' && depth == 0:
+			return "", errors.New("fragments aren't supported")
+		case isNameStart(ch) && depth == 0:
+			end := i
+			for end < len(selection) && isNameChar(selection[end]) {
+				end++
+			}
+			name := selection[i:end]
+			next := strings.TrimLeft(selection[end:], " \t\r\n,")
+			switch {
+			case prev == '@' || prev == ':':
+				b.WriteString(name)
+			case strings.HasPrefix(next, ":"):
+				b.WriteString(prefix + name)
+			default:
+				b.WriteString(prefix + name + ": " + name)
+			}
+			prev = 'a'
+			i = end
+			continue
+		}
+		if ch != ' ' && ch != '\t' && ch != '\r' && ch != '\n' && ch != ',' {
+			prev = ch
+		}
+		b.WriteByte(ch)
+		i++
+	}
+	return b.String(), nil
+}
+
+// renameVariables prefixes all variables of the query outside of strings.
+func renameVariables(query string, prefix string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		switch query[i] {
+		case '"', '#':
+			end := skipIgnored(query, i)
+			b.WriteString(query[i:end])
+			i = end
+		case '$':
+			b.WriteString("$" + prefix)
+			i++
+		default:
+			b.WriteByte(query[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+func indexOutsideStrings(s string, ch byte) int {
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '"', '#':
+			i = skipIgnored(s, i)
+		case ch:
+			return i
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+func matchingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); {
+		switch s[i] {
+		case '"', '#':
+			i = skipIgnored(s, i)
+			continue
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		i++
+	}
+	return -1
+}
+
+// skipIgnored returns the end of the string, block string or comment at i.
+func skipIgnored(s string, i int) int {
+	if s[i] == '#' {
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+			return i + end
+		}
+		return len(s)
+	}
+	if strings.HasPrefix(s[i:], `"""`) {
+		if end := strings.Index(s[i+3:], `"""`); end >= 0 {
+			return i + 3 + end + 3
+		}
+		return len(s)
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || ch >= '0' && ch <= '9'
+}
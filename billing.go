@@ -0,0 +1,438 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionPending   SubscriptionStatus = "PENDING"
+	SubscriptionAccepted  SubscriptionStatus = "ACCEPTED"
+	SubscriptionActive    SubscriptionStatus = "ACTIVE"
+	SubscriptionDeclined  SubscriptionStatus = "DECLINED"
+	SubscriptionExpired   SubscriptionStatus = "EXPIRED"
+	SubscriptionFrozen    SubscriptionStatus = "FROZEN"
+	SubscriptionCancelled SubscriptionStatus = "CANCELLED"
+)
+
+type BillingInterval string
+
+const (
+	BillingEvery30Days BillingInterval = "EVERY_30_DAYS"
+	BillingAnnual      BillingInterval = "ANNUAL"
+)
+
+// SubscriptionRequest describes a plan. The ReturnURL, which may be relative
+// to the app's HostURL, is where the merchant lands after approving or
+// declining the charge.
+type SubscriptionRequest struct {
+	Name      string
+	ReturnURL string
+	TrialDays int
+	Test      bool
+	LineItems []RecurringLineItem
+	Usage     []UsageLineItem
+}
+
+type RecurringLineItem struct {
+	Price    Money
+	Interval BillingInterval
+}
+
+// UsageLineItem caps the usage charges created during a billing period.
+type UsageLineItem struct {
+	CappedAmount Money
+	Terms        string
+}
+
+type AppSubscriptionLineItem struct {
+	ID   string `json:"id"`
+	Plan struct {
+		PricingDetails PricingDetails `json:"pricingDetails"`
+	} `json:"plan"`
+}
+
+// PricingDetails holds either the recurring or the usage pricing of a line
+// item, Typename tells which one.
+type PricingDetails struct {
+	Typename     string          `json:"__typename"`
+	Price        *Money          `json:"price,omitempty"`
+	Interval     BillingInterval `json:"interval,omitempty"`
+	CappedAmount *Money          `json:"cappedAmount,omitempty"`
+	BalanceUsed  *Money          `json:"balanceUsed,omitempty"`
+	Terms        string          `json:"terms,omitempty"`
+}
+
+type AppUsageRecord struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+}
+
+type AppSubscription struct {
+	ID               string                    `json:"id"`
+	Name             string                    `json:"name"`
+	Status           SubscriptionStatus        `json:"status"`
+	Test             bool                      `json:"test"`
+	TrialDays        int                       `json:"trialDays"`
+	CurrentPeriodEnd *time.Time                `json:"currentPeriodEnd"`
+	LineItems        []AppSubscriptionLineItem `json:"lineItems"`
+}
+
+// CappedAmountExceededError is returned by CreateUsageCharge if the charge
+// exceeds the remaining capped amount of the line item. The merchant has to
+// approve a higher cap before further charges can be created.
+type CappedAmountExceededError struct {
+	UserErrors UserErrors
+}
+
+func (e *CappedAmountExceededError) Error() string {
+	return "capped amount exceeded: " + e.UserErrors.Error()
+}
+
+func (e *CappedAmountExceededError) Unwrap() error {
+	return e.UserErrors
+}
+
+var ErrNoSubscriptionPlan = errors.New("billing plan not configured")
+
+type Billing struct {
+	app   *App
+	plans map[string]SubscriptionRequest
+	// pending holds the subscriptions RequireBilling created by shop and plan,
+	// so merchants are sent to the same approval until they decide.
+	mu      sync.Mutex
+	pending map[pendingKey]pendingSubscription
+}
+
+type pendingKey struct {
+	shop string
+	plan string
+}
+
+type pendingSubscription struct {
+	id              string
+	confirmationURL string
+}
+
+// NewBilling returns the billing component for the given plans, which are
+// referenced by name in RequireBilling.
+func (a *App) NewBilling(plans ...SubscriptionRequest) *Billing {
+	b := &Billing{app: a, plans: map[string]SubscriptionRequest{}, pending: map[pendingKey]pendingSubscription{}}
+	for _, p := range plans {
+		b.plans[p.Name] = p
+	}
+	return b
+}
+
+const appSubscriptionFields = `id name status test trialDays currentPeriodEnd lineItems { id plan { pricingDetails {
+	__typename
+	... on AppRecurringPricing { interval price { amount currencyCode } }
+	... on AppUsagePricing { terms cappedAmount { amount currencyCode } balanceUsed { amount currencyCode } }
+} } }`
+
+// CreateSubscription creates a pending subscription for the shop and returns
+// the URL the merchant has to visit to approve it.
+func (b *Billing) CreateSubscription(ctx context.Context, shop string, req SubscriptionRequest) (string, error) {
+	_, confirmationURL, err := b.createSubscription(ctx, shop, req)
+	return confirmationURL, err
+}
+
+func (b *Billing) createSubscription(ctx context.Context, shop string, req SubscriptionRequest) (*AppSubscription, string, error) {
+	sess, err := b.session(ctx, shop)
+	if err != nil {
+		return nil, "", err
+	}
+	returnURL := b.app.HostURL
+	if req.ReturnURL != "" {
+		if returnURL, err = b.returnURL(req.ReturnURL); err != nil {
+			return nil, "", err
+		}
+	}
+	lineItems := make([]map[string]any, 0, len(req.LineItems)+len(req.Usage))
+	for _, item := range req.LineItems {
+		lineItems = append(lineItems, item.input())
+	}
+	for _, item := range req.Usage {
+		lineItems = append(lineItems, item.input())
+	}
+	var out struct {
+		AppSubscriptionCreate struct {
+			AppSubscription AppSubscription `json:"appSubscription"`
+			ConfirmationURL string          `json:"confirmationUrl"`
+		} `json:"appSubscriptionCreate"`
+	}
+	err = b.app.GraphQL(ctx, sess, `mutation appSubscriptionCreate($name: String!, $returnUrl: URL!, $trialDays: Int,
+		$test: Boolean, $lineItems: [AppSubscriptionLineItemInput!]!) {
+		appSubscriptionCreate(name: $name, returnUrl: $returnUrl, trialDays: $trialDays, test: $test,
+			lineItems: $lineItems) {
+			appSubscription { `+appSubscriptionFields+` }
+			confirmationUrl
+			userErrors { field message }
+		}
+	}`, map[string]any{
+		"name":      req.Name,
+		"returnUrl": returnURL,
+		"trialDays": req.TrialDays,
+		"test":      req.Test,
+		"lineItems": lineItems,
+	}, &out)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create subscription: %w", err)
+	}
+	return &out.AppSubscriptionCreate.AppSubscription, out.AppSubscriptionCreate.ConfirmationURL, nil
+}
+
+// HasActiveSubscription reports whether the shop has an active subscription
+// to any of the plans, or to any plan at all if none are given.
+func (b *Billing) HasActiveSubscription(ctx context.Context, shop string, plans ...string) (bool, error) {
+	subs, err := b.ActiveSubscriptions(ctx, shop)
+	if err != nil {
+		return false, err
+	}
+	for _, sub := range subs {
+		if sub.Status != SubscriptionActive {
+			continue
+		}
+		if len(plans) == 0 {
+			return true, nil
+		}
+		for _, plan := range plans {
+			if sub.Name == plan {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (b *Billing) ActiveSubscriptions(ctx context.Context, shop string) ([]AppSubscription, error) {
+	sess, err := b.session(ctx, shop)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		CurrentAppInstallation struct {
+			ActiveSubscriptions []AppSubscription `json:"activeSubscriptions"`
+		} `json:"currentAppInstallation"`
+	}
+	err = b.app.GraphQL(ctx, sess, `{ currentAppInstallation { activeSubscriptions { `+
+		appSubscriptionFields+` } } }`, nil, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	return out.CurrentAppInstallation.ActiveSubscriptions, nil
+}
+
+// Subscription looks up a subscription by ID, e.g. the charge_id Shopify adds
+// to the return URL, to tell whether the merchant approved or declined it.
+func (b *Billing) Subscription(ctx context.Context, shop string, id string) (*AppSubscription, error) {
+	sess, err := b.session(ctx, shop)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(id, "gid://") {
+		id = "gid://shopify/AppSubscription/" + id
+	}
+	var out struct {
+		Node *AppSubscription `json:"node"`
+	}
+	err = b.app.GraphQL(ctx, sess, `query appSubscription($id: ID!) {
+		node(id: $id) { ... on AppSubscription { `+appSubscriptionFields+` } }
+	}`, map[string]any{"id": id}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription: %w", err)
+	}
+	if out.Node == nil {
+		return nil, fmt.Errorf("subscription %s not found", id)
+	}
+	return out.Node, nil
+}
+
+// CreateUsageCharge charges amount against the usage line item of an active
+// subscription, see RemainingCappedAmount.
+func (b *Billing) CreateUsageCharge(ctx context.Context, shop string, subscriptionLineItemID string, description string, amount Money) (*AppUsageRecord, error) {
+	sess, err := b.session(ctx, shop)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		AppUsageRecordCreate struct {
+			AppUsageRecord *AppUsageRecord `json:"appUsageRecord"`
+		} `json:"appUsageRecordCreate"`
+	}
+	err = b.app.GraphQL(ctx, sess, `mutation appUsageRecordCreate($subscriptionLineItemId: ID!, $description: String!,
+		$price: MoneyInput!) {
+		appUsageRecordCreate(subscriptionLineItemId: $subscriptionLineItemId, description: $description,
+			price: $price) {
+			appUsageRecord { id description price { amount currencyCode } }
+			userErrors { field message }
+		}
+	}`, map[string]any{
+		"subscriptionLineItemId": subscriptionLineItemID,
+		"description":            description,
+		"price":                  amount,
+	}, &out)
+	var userErrs UserErrors
+	if errors.As(err, &userErrs) && cappedAmountExceeded(userErrs) {
+		return nil, &CappedAmountExceededError{UserErrors: userErrs}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create usage charge: %w", err)
+	}
+	return out.AppUsageRecordCreate.AppUsageRecord, nil
+}
+
+// RemainingCappedAmount returns how much can still be charged against the
+// usage line item in the current billing period.
+func (b *Billing) RemainingCappedAmount(ctx context.Context, shop string, subscriptionLineItemID string) (Money, error) {
+	subs, err := b.ActiveSubscriptions(ctx, shop)
+	if err != nil {
+		return Money{}, err
+	}
+	for _, sub := range subs {
+		for _, item := range sub.LineItems {
+			details := item.Plan.PricingDetails
+			if item.ID != subscriptionLineItemID || details.CappedAmount == nil {
+				continue
+			}
+			if details.BalanceUsed == nil {
+				return *details.CappedAmount, nil
+			}
+			return details.CappedAmount.Sub(*details.BalanceUsed)
+		}
+	}
+	return Money{}, fmt.Errorf("usage line item %s not found in active subscriptions", subscriptionLineItemID)
+}
+
+func cappedAmountExceeded(errs UserErrors) bool {
+	for _, e := range errs {
+		msg := strings.ToLower(e.Message)
+		if strings.Contains(msg, "exceeds") && (strings.Contains(msg, "balance") || strings.Contains(msg, "capped")) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireBilling lets requests of shops subscribed to plan pass. Other shops
+// are redirected out of the app to approve a pending subscription, which is
+// created once and reused until the merchant approves or declines it. It must
+// run after the session has been validated.
+func (b *Billing) RequireBilling(plan string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := b.plans[plan]
+		if !ok {
+			_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("%w: %s", ErrNoSubscriptionPlan, plan))
+			return
+		}
+		shop := MustGetShop(c)
+		logger := b.app.logger(c).With(log.String("shop", shop), log.String("plan", plan))
+		active, err := b.HasActiveSubscription(c.Request.Context(), shop, plan)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		key := pendingKey{shop: shop, plan: plan}
+		if active {
+			b.forgetPending(key)
+			return
+		}
+		confirmationURL, ok := b.pendingURL(c.Request.Context(), key)
+		if !ok {
+			logger.Debug("no active subscription, requesting approval")
+			var sub *AppSubscription
+			if sub, confirmationURL, err = b.createSubscription(c.Request.Context(), shop, req); err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			b.mu.Lock()
+			b.pending[key] = pendingSubscription{id: sub.ID, confirmationURL: confirmationURL}
+			b.mu.Unlock()
+		}
+		setShop(c, shop)
+		setRedirectUri(c, confirmationURL)
+		b.app.redirectOutOfApp(c)
+	}
+}
+
+// pendingURL returns the confirmation URL of the subscription created for key
+// if the merchant hasn't approved or declined it yet.
+func (b *Billing) pendingURL(ctx context.Context, key pendingKey) (string, bool) {
+	b.mu.Lock()
+	pending, ok := b.pending[key]
+	b.mu.Unlock()
+	if !ok || pending.id == "" {
+		return "", false
+	}
+	sub, err := b.Subscription(ctx, key.shop, pending.id)
+	if err != nil || sub.Status != SubscriptionPending {
+		b.forgetPending(key)
+		return "", false
+	}
+	return pending.confirmationURL, true
+}
+
+func (b *Billing) forgetPending(key pendingKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, key)
+}
+
+func (b *Billing) session(ctx context.Context, shop string) (*Session, error) {
+	sess, err := b.app.SessionStore.Get(ctx, OfflineSessionID(shop))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offline session for %s: %w", shop, err)
+	}
+	return sess, nil
+}
+
+func (b *Billing) returnURL(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid return url: %w", err)
+	}
+	if u.IsAbs() {
+		return ref, nil
+	}
+	base, err := url.Parse(b.app.HostURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid host url: %w", err)
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+func (l UsageLineItem) input() map[string]any {
+	return map[string]any{
+		"plan": map[string]any{
+			"appUsagePricingDetails": map[string]any{
+				"terms":        l.Terms,
+				"cappedAmount": l.CappedAmount,
+			},
+		},
+	}
+}
+
+func (l RecurringLineItem) input() map[string]any {
+	interval := l.Interval
+	if interval == "" {
+		interval = BillingEvery30Days
+	}
+	return map[string]any{
+		"plan": map[string]any{
+			"appRecurringPricingDetails": map[string]any{
+				"price":    l.Price,
+				"interval": interval,
+			},
+		},
+	}
+}
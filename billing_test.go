@@ -0,0 +1,183 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type BillingTestSuite struct {
+	suite.Suite
+	server    *httptest.Server
+	app       *App
+	shop      string
+	responses map[string]string
+	requests  []graphQLRequest
+}
+
+func TestBillingTestSuite(t *testing.T) {
+	suite.Run(t, new(BillingTestSuite))
+}
+
+var testPlan = SubscriptionRequest{
+	Name:      "Pro",
+	ReturnURL: "/billing/return",
+	TrialDays: 7,
+	Test:      true,
+	LineItems: []RecurringLineItem{{Price: MustMoney("9.99", "USD")}},
+	Usage:     []UsageLineItem{{CappedAmount: MustMoney("100", "USD"), Terms: "$1 per order"}},
+}
+
+func (s *BillingTestSuite) SetupTest() {
+	s.requests = nil
+	s.responses = map[string]string{}
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		s.requests = append(s.requests, req)
+		for op, body := range s.responses {
+			if strings.Contains(req.Query, op) {
+				_, _ = w.Write([]byte(body))
+				return
+			}
+		}
+		s.Failf("unexpected query", req.Query)
+	}))
+	c := testAppConfig()
+	c.HostURL = "https://app.example.com"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}), WithIsEmbedded(false))
+	s.Require().NoError(err)
+	app.Client.http = s.server.Client()
+	s.app = app
+	s.shop = strings.TrimPrefix(s.server.URL, "https://")
+	s.Require().NoError(app.SessionStore.Store(context.Background(),
+		&Session{ID: GetOfflineSessionID(s.shop), Shop: s.shop, AccessToken: "token"}))
+}
+
+func (s *BillingTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *BillingTestSuite) TestCreateSubscription() {
+	s.responses["appSubscriptionCreate"] = `{"data":{"appSubscriptionCreate":{"appSubscription":{"id":"gid://shopify/AppSubscription/1",
+		"status":"PENDING"},"confirmationUrl":"https://confirm.example.com","userErrors":[]}}}`
+	confirmationURL, err := s.app.NewBilling().CreateSubscription(context.Background(), s.shop, testPlan)
+	s.Require().NoError(err)
+	s.Equal("https://confirm.example.com", confirmationURL)
+	s.Require().Len(s.requests, 1)
+	vars := s.requests[0].Variables
+	s.Equal("https://app.example.com/billing/return", vars["returnUrl"])
+	s.Equal(float64(7), vars["trialDays"])
+	s.Equal(true, vars["test"])
+	s.Equal([]any{map[string]any{"plan": map[string]any{"appRecurringPricingDetails": map[string]any{
+		"price":    map[string]any{"amount": "9.99", "currencyCode": "USD"},
+		"interval": "EVERY_30_DAYS",
+	}}}, map[string]any{"plan": map[string]any{"appUsagePricingDetails": map[string]any{
+		"cappedAmount": map[string]any{"amount": "100", "currencyCode": "USD"},
+		"terms":        "$1 per order",
+	}}}}, vars["lineItems"])
+}
+
+func (s *BillingTestSuite) TestHasActiveSubscription() {
+	s.responses["activeSubscriptions"] = `{"data":{"currentAppInstallation":{"activeSubscriptions":[
+		{"id":"gid://shopify/AppSubscription/1","name":"Basic","status":"FROZEN"},
+		{"id":"gid://shopify/AppSubscription/2","name":"Pro","status":"ACTIVE"}]}}}`
+	billing := s.app.NewBilling()
+	for plans, exp := range map[string]bool{"": true, "Pro": true, "Basic": false, "Basic,Enterprise": false} {
+		var names []string
+		if plans != "" {
+			names = strings.Split(plans, ",")
+		}
+		active, err := billing.HasActiveSubscription(context.Background(), s.shop, names...)
+		s.Require().NoError(err)
+		s.Equal(exp, active, plans)
+	}
+}
+
+func (s *BillingTestSuite) TestRequireBilling() {
+	s.responses["activeSubscriptions"] = `{"data":{"currentAppInstallation":{"activeSubscriptions":[]}}}`
+	s.responses["appSubscriptionCreate"] = `{"data":{"appSubscriptionCreate":{"confirmationUrl":"https://confirm.example.com",
+		"userErrors":[]}}}`
+	rec := httptest.NewRecorder()
+	_, e := gin.CreateTestContext(rec)
+	e.GET("/", func(c *gin.Context) {
+		c.Set(ShopSessionKey, &Session{Shop: s.shop})
+	}, s.app.NewBilling(testPlan).RequireBilling("Pro"), func(c *gin.Context) {
+		s.Fail("handler must not run without subscription")
+	})
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	s.Equal(http.StatusFound, rec.Code)
+	s.Equal("https://confirm.example.com", rec.Header().Get("Location"))
+}
+
+func (s *BillingTestSuite) TestRequireBillingReusesPending() {
+	s.responses["activeSubscriptions"] = `{"data":{"currentAppInstallation":{"activeSubscriptions":[]}}}`
+	s.responses["appSubscriptionCreate"] = `{"data":{"appSubscriptionCreate":{"appSubscription":{"id":"gid://shopify/AppSubscription/1",
+		"status":"PENDING"},"confirmationUrl":"https://confirm.example.com/1","userErrors":[]}}}`
+	s.responses["query appSubscription"] = `{"data":{"node":{"id":"gid://shopify/AppSubscription/1","status":"PENDING"}}}`
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/", func(c *gin.Context) {
+		c.Set(ShopSessionKey, &Session{Shop: s.shop})
+	}, s.app.NewBilling(testPlan).RequireBilling("Pro"))
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		s.Equal(http.StatusFound, rec.Code)
+		return rec.Header().Get("Location")
+	}
+	created := func() int {
+		n := 0
+		for _, req := range s.requests {
+			if strings.Contains(req.Query, "appSubscriptionCreate") {
+				n++
+			}
+		}
+		return n
+	}
+
+	s.Equal("https://confirm.example.com/1", serve())
+	s.Equal("https://confirm.example.com/1", serve())
+	s.Equal(1, created(), "the pending subscription must be reused")
+
+	s.responses["query appSubscription"] = `{"data":{"node":{"id":"gid://shopify/AppSubscription/1","status":"DECLINED"}}}`
+	s.responses["appSubscriptionCreate"] = `{"data":{"appSubscriptionCreate":{"appSubscription":{"id":"gid://shopify/AppSubscription/2",
+		"status":"PENDING"},"confirmationUrl":"https://confirm.example.com/2","userErrors":[]}}}`
+	s.Equal("https://confirm.example.com/2", serve())
+	s.Equal(2, created(), "declined subscriptions must be replaced")
+}
+
+func (s *BillingTestSuite) TestRemainingCappedAmount() {
+	s.responses["activeSubscriptions"] = `{"data":{"currentAppInstallation":{"activeSubscriptions":[
+		{"id":"gid://shopify/AppSubscription/1","name":"Pro","status":"ACTIVE","lineItems":[
+			{"id":"gid://shopify/AppSubscriptionLineItem/1","plan":{"pricingDetails":{"__typename":"AppRecurringPricing",
+				"interval":"EVERY_30_DAYS","price":{"amount":"9.99","currencyCode":"USD"}}}},
+			{"id":"gid://shopify/AppSubscriptionLineItem/2","plan":{"pricingDetails":{"__typename":"AppUsagePricing",
+				"terms":"$1 per order","cappedAmount":{"amount":"100.0","currencyCode":"USD"},
+				"balanceUsed":{"amount":"42.1","currencyCode":"USD"}}}}]}]}}}`
+	remaining, err := s.app.NewBilling().RemainingCappedAmount(context.Background(), s.shop,
+		"gid://shopify/AppSubscriptionLineItem/2")
+	s.Require().NoError(err)
+	s.Equal(MustMoney("57.9", "USD"), remaining)
+}
+
+func (s *BillingTestSuite) TestCreateUsageCharge() {
+	s.responses["appUsageRecordCreate"] = `{"data":{"appUsageRecordCreate":{"appUsageRecord":{"id":"gid://shopify/AppUsageRecord/1",
+		"description":"1 order","price":{"amount":"1.0","currencyCode":"USD"}},"userErrors":[]}}}`
+	billing := s.app.NewBilling()
+	record, err := billing.CreateUsageCharge(context.Background(), s.shop, "gid://shopify/AppSubscriptionLineItem/2",
+		"1 order", MustMoney("1", "USD"))
+	s.Require().NoError(err)
+	s.Equal("gid://shopify/AppUsageRecord/1", record.ID)
+
+	s.responses["appUsageRecordCreate"] = `{"data":{"appUsageRecordCreate":{"appUsageRecord":null,
+		"userErrors":[{"field":null,"message":"Total price exceeds balance remaining"}]}}}`
+	_, err = billing.CreateUsageCharge(context.Background(), s.shop, "gid://shopify/AppSubscriptionLineItem/2",
+		"1 order", MustMoney("1", "USD"))
+	var exceeded *CappedAmountExceededError
+	s.ErrorAs(err, &exceeded)
+}
@@ -0,0 +1,139 @@
+package shopigo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const defaultBulkPollInterval = 2 * time.Second
+
+type BulkOperationStatus string
+
+const (
+	BulkOperationCreated   BulkOperationStatus = "CREATED"
+	BulkOperationRunning   BulkOperationStatus = "RUNNING"
+	BulkOperationCompleted BulkOperationStatus = "COMPLETED"
+	BulkOperationCanceling BulkOperationStatus = "CANCELING"
+	BulkOperationCanceled  BulkOperationStatus = "CANCELED"
+	BulkOperationFailed    BulkOperationStatus = "FAILED"
+	BulkOperationExpired   BulkOperationStatus = "EXPIRED"
+)
+
+type BulkOperation struct {
+	ID             string              `json:"id"`
+	Status         BulkOperationStatus `json:"status"`
+	ErrorCode      string              `json:"errorCode"`
+	CreatedAt      time.Time           `json:"createdAt"`
+	CompletedAt    *time.Time          `json:"completedAt"`
+	ObjectCount    string              `json:"objectCount"`
+	FileSize       string              `json:"fileSize"`
+	URL            string              `json:"url"`
+	PartialDataURL string              `json:"partialDataUrl"`
+}
+
+type BulkOperationError struct {
+	ID        string
+	Status    BulkOperationStatus
+	ErrorCode string
+}
+
+func (e *BulkOperationError) Error() string {
+	if e.ErrorCode == "" {
+		return fmt.Sprintf("bulk operation %s %s", e.ID, e.Status)
+	}
+	return fmt.Sprintf("bulk operation %s %s, error code: %s", e.ID, e.Status, e.ErrorCode)
+}
+
+const bulkOperationFields = `id status errorCode createdAt completedAt objectCount fileSize url partialDataUrl`
+
+func (c *Client) BulkQuery(ctx context.Context, sess *Session, query string) (*BulkOperation, error) {
+	var out struct {
+		BulkOperationRunQuery struct {
+			BulkOperation *BulkOperation `json:"bulkOperation"`
+		} `json:"bulkOperationRunQuery"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation bulkOperationRunQuery($query: String!) {
+		bulkOperationRunQuery(query: $query) {
+			bulkOperation { `+bulkOperationFields+` }
+			userErrors { field message }
+		}
+	}`, map[string]any{"query": query}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run bulk query: %w", err)
+	}
+	if out.BulkOperationRunQuery.BulkOperation == nil {
+		return nil, errors.New("failed to run bulk query: no bulk operation returned")
+	}
+	return out.BulkOperationRunQuery.BulkOperation, nil
+}
+
+// WaitForBulk polls the bulk operation until it reached a terminal state. A
+// BulkOperationError is returned alongside the operation if it didn't complete.
+func (c *Client) WaitForBulk(ctx context.Context, sess *Session, id string) (*BulkOperation, error) {
+	for {
+		var out struct {
+			Node *BulkOperation `json:"node"`
+		}
+		err := c.GraphQL(ctx, sess, `query bulkOperation($id: ID!) {
+			node(id: $id) { ... on BulkOperation { `+bulkOperationFields+` } }
+		}`, map[string]any{"id": id}, &out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll bulk operation: %w", err)
+		}
+		if out.Node == nil {
+			return nil, fmt.Errorf("bulk operation %s not found", id)
+		}
+		switch op := out.Node; op.Status {
+		case BulkOperationCompleted:
+			return op, nil
+		case BulkOperationFailed, BulkOperationCanceled, BulkOperationExpired:
+			return op, &BulkOperationError{ID: op.ID, Status: op.Status, ErrorCode: op.ErrorCode}
+		}
+		c.sleep(ctx, c.bulkPollInterval)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// DownloadBulk streams the JSONL result of a bulk operation into out, one
+// object per line. out is closed when the download is done.
+func (c *Client) DownloadBulk(ctx context.Context, url string, out chan<- json.RawMessage) error {
+	defer close(out)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		bs, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed, status: %d, detail: %s", resp.StatusCode, string(bs))
+	}
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			select {
+			case out <- json.RawMessage(line):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read bulk result: %w", err)
+		}
+	}
+}
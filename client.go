@@ -2,16 +2,42 @@ package shopigo
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	XAPIVersionHeader       = "X-Shopify-API-Version"
+	XDeprecatedReasonHeader = "X-Shopify-API-Deprecated-Reason"
+)
+
+const (
+	defaultRetries          = 3
+	defaultBackoffBase      = time.Second
+	defaultBackoffMax       = 8 * time.Second
+	defaultHTTPTimeout      = 30 * time.Second
+	defaultMaxResponseBytes = 64 << 20
+)
+
+var (
+	// ErrShopFrozen is returned for requests to shops which are frozen due to
+	// an unpaid plan.
+	ErrShopFrozen = errors.New("shop is frozen")
+	// ErrShopLocked is returned for requests to shops locked by Shopify.
+	ErrShopLocked = errors.New("shop is locked")
+)
+
 type Version string
 
 func (v Version) String() string {
@@ -21,68 +47,416 @@ func (v Version) String() string {
 const (
 	V202304 Version = "2023-04"
 	V202307 Version = "2023-07"
-	VLatest Version = V202307
+	V202310 Version = "2023-10"
+	V202401 Version = "2024-01"
+	V202404 Version = "2024-04"
+	V202407 Version = "2024-07"
+	V202410 Version = "2024-10"
+	V202501 Version = "2025-01"
+	V202504 Version = "2025-04"
+	V202507 Version = "2025-07"
+	V202510 Version = "2025-10"
+	V202601 Version = "2026-01"
+	V202604 Version = "2026-04"
+	V202607 Version = "2026-07"
+	V202610 Version = "2026-10"
+	VLatest Version = V202610
 )
 
+// versions lists the API versions known to the library, oldest first.
+var versions = []Version{
+	V202304, V202307, V202310,
+	V202401, V202404, V202407, V202410,
+	V202501, V202504, V202507, V202510,
+	V202601, V202604, V202607, V202610,
+}
+
+// RegisterVersion makes a version released after this library accepted by
+// WithVersion and ParseVersion. It's not safe to call concurrently with them.
+func RegisterVersion(v Version) {
+	if _, err := ParseVersion(v.String()); err == nil {
+		return
+	}
+	versions = append(versions, v)
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+}
+
+func SupportedVersions() []Version {
+	return append([]Version(nil), versions...)
+}
+
+func ParseVersion(s string) (Version, error) {
+	for _, v := range versions {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported api version: %s", s)
+}
+
 type ClientConfig struct {
-	v           Version
-	clientID    string
-	hostURL     string
-	retries     int
-	defaultShop *Shop
+	v                  Version
+	clientID           string
+	hostURL            string
+	retries            int
+	backoffBase        time.Duration
+	backoffMax         time.Duration
+	retryNonIdempotent bool
+	retryableStatuses  []int
+	bulkPollInterval   time.Duration
+	defaultShop        *Shop
+	deprecationHandler func(reason, url string)
+	logger             Logger
+	metrics            MetricsRecorder
+	apiHostOverride    func(shop string) string
+	authStrategy       AuthStrategy
+	maxResponseBytes   int64
 }
 
 type Client struct {
 	*ClientConfig
-	http *http.Client
+	http     *http.Client
+	throttle *graphQLThrottle
+	rest     *restThrottle
+	sleep    func(ctx context.Context, d time.Duration)
+	session  *Session
+	// invalidToken is called if the token of session is rejected.
+	invalidToken func(ctx context.Context, sess *Session)
+	// invalidateShop drops the app's caches of a shop.
+	invalidateShop func(ctx context.Context, shop string) error
+	queries        *queryRegistry
 }
 
 func NewShopifyClient(c *ClientConfig) *Client {
-	return &Client{ClientConfig: c, http: &http.Client{}}
+	if c.backoffBase == 0 {
+		c.backoffBase = defaultBackoffBase
+	}
+	if c.backoffMax == 0 {
+		c.backoffMax = defaultBackoffMax
+	}
+	if c.retryableStatuses == nil {
+		c.retryableStatuses = defaultRetryableStatuses
+	}
+	if c.maxResponseBytes == 0 {
+		c.maxResponseBytes = defaultMaxResponseBytes
+	}
+	if c.bulkPollInterval == 0 {
+		c.bulkPollInterval = defaultBulkPollInterval
+	}
+	if c.logger == nil {
+		c.logger = noopLogger{}
+	}
+	if c.metrics == nil {
+		c.metrics = noopMetrics{}
+	}
+	client := &Client{ClientConfig: c, http: &http.Client{Timeout: defaultHTTPTimeout, Transport: newTransport(DefaultTransportTuning)}, throttle: newGraphQLThrottle(),
+		rest: newRESTThrottle(), sleep: SleepContext, queries: newQueryRegistry()}
+	client.throttle.shopKey = client.shopBucketKey
+	return client
 }
 
 func (c *Client) ShopURL(shop string, endpoint string) string {
-	return fmt.Sprintf("https://%s/%s", shop, path.Join("admin/api", c.v.String(), endpoint))
+	return c.adminURL(shop) + "/" + path.Join("api", c.v.String(), endpoint)
+}
+
+// adminURL returns the base admin URL of shop, https://<shop>/admin unless
+// overridden by WithAPIHostOverride.
+func (c *Client) adminURL(shop string) string {
+	if c.apiHostOverride != nil {
+		return strings.TrimSuffix(c.apiHostOverride(shop), "/")
+	}
+	return "https://" + shop + "/admin"
 }
 
 func (c *Client) For(session *Session) func(req *http.Request) (*http.Response, error) {
+	session = c.sessionOr(session)
 	return func(req *http.Request) (*http.Response, error) {
-		req.SetBasicAuth(c.clientID, session.AccessToken)
+		c.authenticate(req, session)
 		return c.Do(req)
 	}
 }
 
-func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	if req.Body != nil {
-		req.Header.Add("Content-Type", "application/json")
+func (c *Client) Do(req *http.Request) (resp *http.Response, err error) {
+	if req.Body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	backoff := time.Second
+	ctx := req.Context()
+	requestID := RequestIDFromContext(ctx)
+	if requestID != "" && req.Header.Get(XRequestIDHeader) == "" {
+		req.Header.Set(XRequestIDHeader, requestID)
+	}
+	start := time.Now()
 	attempt := 0
-retry:
-	attempt++
-	resp, err := c.http.Do(req)
-	if err != nil {
-		var e *url.Error
-		if errors.As(err, &e) && e.Timeout() {
-			if attempt > c.retries {
+	var throttled time.Duration
+	defer func() {
+		d := time.Since(start)
+		c.logRequest(req, resp, err, requestID, attempt, d)
+		m := RequestMetrics{Shop: req.URL.Host, Endpoint: metricsEndpoint(req.URL.Path), Method: req.Method,
+			Duration: d, Retries: attempt, ThrottleWait: throttled, ErrorClass: errorClass(resp, err)}
+		if resp != nil {
+			m.Status = resp.StatusCode
+		}
+		c.metrics.RecordRequest(m)
+	}()
+	for ; ; attempt++ {
+		if attempt > 0 {
+			if err := rewindBody(req); err != nil {
 				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
 			}
-			goto retry
 		}
-		return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
+		if c.throttle.applies(req) {
+			if d := c.throttle.wait(req, c.v); d > 0 {
+				throttled += d
+				c.metrics.RecordThrottleWait(req.URL.Host, d)
+			}
+		} else if c.rest.applies(req, c.v) {
+			if d := c.rest.wait(req, c.v); d > 0 {
+				throttled += d
+				c.metrics.RecordThrottleWait(req.URL.Host, d)
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("client.Do(%v): %w", req.URL, ctx.Err())
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, ctx.Err())
+			}
+			var e *url.Error
+			if !errors.As(err, &e) || !e.Timeout() || !c.retryableTimeout(req) || !c.canRetry(req, attempt) {
+				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
+			}
+			wait := c.backoff(attempt)
+			if !fitsDeadline(ctx, wait) {
+				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
+			}
+			c.metrics.RecordRetry(req.URL.Host, metricsEndpoint(req.URL.Path), 0)
+			c.sleep(ctx, wait)
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, ctx.Err())
+			}
+			continue
+		}
+		if c.rest.applies(req, c.v) {
+			c.rest.observe(req, c.v, resp)
+		}
+		if c.retryableStatus(req, resp.StatusCode) && c.canRetry(req, attempt) {
+			wait, ok := retryAfter(resp)
+			if !ok {
+				wait = c.backoff(attempt)
+			}
+			// otherwise the response is returned as is
+			if fitsDeadline(ctx, wait) {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				c.metrics.RecordRetry(req.URL.Host, metricsEndpoint(req.URL.Path), resp.StatusCode)
+				c.sleep(ctx, wait)
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("client.Do(%v): %w", req.URL, ctx.Err())
+				}
+				continue
+			}
+		}
+		if err = shopUnavailable(resp.StatusCode); err != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && c.invalidToken != nil && c.session != nil &&
+			requestToken(req) == c.session.AccessToken {
+			c.invalidToken(ctx, c.session)
+		}
+		if reason := resp.Header.Get(XDeprecatedReasonHeader); reason != "" {
+			c.deprecated(reason, req.URL.String())
+		}
+		resp.Body = limitBody(resp.Body, c.maxResponseBytes)
+		if resp.StatusCode == http.StatusOK && c.throttle.applies(req) {
+			if err = c.throttle.observe(req, c.v, resp); err != nil {
+				return nil, fmt.Errorf("client.Do(%v): %w", req.URL, err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// ResponseVersion returns the API version that served resp, which may differ
+// from the requested one once a version is no longer supported.
+func ResponseVersion(resp *http.Response) (Version, bool) {
+	v := resp.Header.Get(XAPIVersionHeader)
+	return Version(v), v != ""
+}
+
+func (c *Client) logRequest(req *http.Request, resp *http.Response, err error, requestID string, retries int, d time.Duration) {
+	args := []any{"method", req.Method, "path", req.URL.Path, "duration", d, "retries", retries}
+	if requestID != "" {
+		args = append(args, "request_id", requestID)
 	}
-	if resp.StatusCode == http.StatusTooManyRequests {
-		SleepContext(req.Context(), backoff)
-		if backoff < 8*time.Second {
-			backoff *= 2
+	if err != nil {
+		c.logger.Error("shopify request failed", append(args, "error", err)...)
+		return
+	}
+	if resp == nil {
+		return
+	}
+	args = append(args, "status", resp.StatusCode, "shopify_request_id", resp.Header.Get(XRequestIDHeader))
+	if resp.StatusCode >= 400 {
+		c.logger.Warn("shopify request", args...)
+		return
+	}
+	c.logger.Debug("shopify request", args...)
+}
+
+// deprecated calls the deprecation handler, by default it logs a warning.
+func (c *Client) deprecated(reason, url string) {
+	if c.deprecationHandler != nil {
+		c.deprecationHandler(reason, url)
+		return
+	}
+	c.logger.Warn("deprecated shopify api call", "reason", reason, "url", url)
+}
+
+// requestToken returns the access token req is authenticated with.
+func requestToken(req *http.Request) string {
+	if token := req.Header.Get(XAccessToken); token != "" {
+		return token
+	}
+	_, token, _ := req.BasicAuth()
+	return token
+}
+
+func shopUnavailable(status int) error {
+	switch status {
+	case http.StatusPaymentRequired:
+		return ErrShopFrozen
+	case http.StatusLocked:
+		return ErrShopLocked
+	}
+	return nil
+}
+
+func (c *Client) canRetry(req *http.Request, attempt int) bool {
+	return attempt < c.retries && (req.Body == nil || req.GetBody != nil)
+}
+
+// retryableTimeout reports whether a timed out request is worth retrying.
+// Shopify may have processed it, so only idempotent requests are retried
+// unless WithRetryNonIdempotent is set.
+func (c *Client) retryableTimeout(req *http.Request) bool {
+	return c.retryNonIdempotent || isIdempotent(req.Method)
+}
+
+var defaultRetryableStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryableStatus reports whether a response status is worth retrying, see
+// WithRetryableStatuses. A 429 means Shopify didn't process the request, so it
+// is safe to retry for any method. Other transient errors are only retried for
+// idempotent requests unless WithRetryNonIdempotent is set.
+func (c *Client) retryableStatus(req *http.Request, status int) bool {
+	if !slices.Contains(c.retryableStatuses, status) {
+		return false
+	}
+	return status == http.StatusTooManyRequests || c.retryNonIdempotent || isIdempotent(req.Method)
+}
+
+// backoff returns an exponential backoff with full jitter for the attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.backoffMax
+	if c.backoffBase <= c.backoffMax>>attempt {
+		d = c.backoffBase << attempt
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// fitsDeadline reports whether a retry after waiting d can start before the
+// deadline of ctx. Retries which would overrun it are skipped, returning the
+// last response or error right away.
+func fitsDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > d
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(h, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// limitedBody fails reads with ErrResponseTooLarge once more than remaining
+// bytes are read, instead of truncating the body like io.LimitReader.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func limitBody(body io.ReadCloser, n int64) io.ReadCloser {
+	if n <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, remaining: n}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
 		}
-		goto retry
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
 	}
-	return resp, nil
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func rewindBody(req *http.Request) error {
+	if req.Body == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return fmt.Errorf("failed to rewind body: %w", err)
+	}
+	req.Body = body
+	return nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
 }
 
 func (c *Client) Get(sess *Session, endpoint string, out any) error {
-	req, err := http.NewRequest(http.MethodGet, c.ShopURL(sess.Shop, endpoint), nil)
+	return c.GetContext(context.Background(), sess, endpoint, out)
+}
+
+func (c *Client) GetContext(ctx context.Context, sess *Session, endpoint string, out any) error {
+	sess = c.sessionOr(sess)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.ShopURL(sess.Shop, endpoint), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -92,11 +466,10 @@ func (c *Client) Get(sess *Session, endpoint string, out any) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		bs, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed, status: %d, detail: %s", resp.StatusCode, string(bs))
+		return newShopifyAPIError(resp)
 	}
 	if out != nil {
-		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if err = decodeJSON(resp.Body, out); err != nil {
 			return fmt.Errorf("failed to decode response: %w", err)
 		}
 	}
@@ -104,11 +477,16 @@ func (c *Client) Get(sess *Session, endpoint string, out any) error {
 }
 
 func (c *Client) Create(sess *Session, endpoint string, in any, out any) error {
-	var body bytes.Buffer
-	if err := json.NewEncoder(&body).Encode(in); err != nil {
+	return c.CreateContext(context.Background(), sess, endpoint, in, out)
+}
+
+func (c *Client) CreateContext(ctx context.Context, sess *Session, endpoint string, in any, out any) error {
+	sess = c.sessionOr(sess)
+	body, err := jsonMarshal(in)
+	if err != nil {
 		return fmt.Errorf("failed to encode request object: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, c.ShopURL(sess.Shop, endpoint), &body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ShopURL(sess.Shop, endpoint), bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -119,11 +497,10 @@ func (c *Client) Create(sess *Session, endpoint string, in any, out any) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		bs, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed, status: %d, detail: %s", resp.StatusCode, string(bs))
+		return newShopifyAPIError(resp)
 	}
 	if out != nil {
-		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if err = decodeJSON(resp.Body, out); err != nil {
 			return fmt.Errorf("failed to decode response: %w", err)
 		}
 	}
@@ -0,0 +1,934 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+	client *Client
+	sleeps []time.Duration
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func response(status int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// responses returns a transport serving the given responses in order and
+// recording the bodies of the requests it received.
+func responses(bodies *[]string, resps ...*http.Response) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if bodies != nil && req.Body != nil {
+			bs, _ := io.ReadAll(req.Body)
+			*bodies = append(*bodies, string(bs))
+		}
+		resp := resps[0]
+		resps = resps[1:]
+		return resp, nil
+	})
+}
+
+func (s *ClientTestSuite) SetupTest() {
+	s.sleeps = nil
+	s.client = NewShopifyClient(&ClientConfig{v: VLatest, retries: 3})
+	s.client.sleep = func(_ context.Context, d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+	}
+}
+
+func (s *ClientTestSuite) TestRetryAfter() {
+	s.client.http.Transport = responses(nil,
+		response(http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}}, ""),
+		response(http.StatusOK, nil, "{}"),
+	)
+	req, _ := http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "shop.json"), nil)
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal([]time.Duration{2 * time.Second}, s.sleeps)
+}
+
+func (s *ClientTestSuite) TestJitteredBackoff() {
+	s.client.http.Transport = responses(nil,
+		response(http.StatusServiceUnavailable, nil, ""),
+		response(http.StatusServiceUnavailable, nil, ""),
+		response(http.StatusServiceUnavailable, nil, ""),
+		response(http.StatusServiceUnavailable, nil, ""),
+	)
+	req, _ := http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "shop.json"), nil)
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	s.Require().Len(s.sleeps, 3)
+	for i, d := range s.sleeps {
+		s.LessOrEqual(d, defaultBackoffBase<<i)
+		s.Positive(d)
+	}
+}
+
+func (s *ClientTestSuite) TestRetryRewindsBody() {
+	var bodies []string
+	s.client.http.Transport = responses(&bodies,
+		response(http.StatusTooManyRequests, nil, ""),
+		response(http.StatusOK, nil, "{}"),
+	)
+	req, _ := http.NewRequest(http.MethodPost, s.client.ShopURL("test.myshopify.com", "products.json"),
+		strings.NewReader(`{"product":{}}`))
+	_, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal([]string{`{"product":{}}`, `{"product":{}}`}, bodies)
+}
+
+func (s *ClientTestSuite) TestNoRetryNonIdempotent() {
+	s.client.http.Transport = responses(nil,
+		response(http.StatusServiceUnavailable, nil, ""),
+		response(http.StatusOK, nil, "{}"),
+	)
+	req, _ := http.NewRequest(http.MethodPost, s.client.ShopURL("test.myshopify.com", "products.json"),
+		strings.NewReader("{}"))
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	s.Empty(s.sleeps)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func (s *ClientTestSuite) TestTimeoutRetries() {
+	calls := 0
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, timeoutError{}
+		}
+		return response(http.StatusOK, nil, "{}"), nil
+	})
+	post := func() error {
+		req, _ := http.NewRequest(http.MethodPost, s.client.ShopURL("test.myshopify.com", "orders.json"),
+			strings.NewReader(`{"order":{}}`))
+		_, err := s.client.Do(req)
+		return err
+	}
+	s.Error(post(), "timed out POSTs must not be retried")
+	s.Equal(1, calls)
+	s.Empty(s.sleeps)
+
+	calls = 0
+	req, _ := http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "orders.json"), nil)
+	_, err := s.client.Do(req)
+	s.NoError(err)
+	s.Equal(2, calls)
+
+	calls = 0
+	s.client.retryNonIdempotent = true
+	s.NoError(post())
+	s.Equal(2, calls)
+}
+
+func (s *ClientTestSuite) TestRetryableStatuses() {
+	var bodies []string
+	s.client.http.Transport = responses(&bodies,
+		response(http.StatusUnprocessableEntity, nil, `{"errors":{"title":["can't be blank"]}}`),
+		response(http.StatusOK, nil, "{}"),
+	)
+	s.client.retryNonIdempotent = true
+	req, _ := http.NewRequest(http.MethodPost, s.client.ShopURL("test.myshopify.com", "products.json"),
+		strings.NewReader(`{"product":{}}`))
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	s.Len(bodies, 1)
+	s.Empty(s.sleeps)
+
+	req, _ = http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "products.json"), nil)
+	s.client.http.Transport = responses(nil,
+		response(http.StatusBadGateway, nil, ""),
+		response(http.StatusOK, nil, "{}"),
+	)
+	resp, err = s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Len(s.sleeps, 1)
+
+	s.client.retryableStatuses = []int{http.StatusTooManyRequests}
+	s.client.http.Transport = responses(nil,
+		response(http.StatusBadGateway, nil, ""),
+		response(http.StatusOK, nil, "{}"),
+	)
+	resp, err = s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal(http.StatusBadGateway, resp.StatusCode)
+}
+
+func (s *ClientTestSuite) TestNextPageURL() {
+	const (
+		prev = "https://test.myshopify.com/admin/api/2023-07/products.json?page_info=abc"
+		next = "https://test.myshopify.com/admin/api/2023-07/products.json?page_info=def"
+	)
+	for _, tc := range []struct {
+		link string
+		exp  string
+	}{
+		{link: "", exp: ""},
+		{link: `<` + next + `>; rel="next"`, exp: next},
+		{link: `<` + prev + `>; rel="previous", <` + next + `>; rel="next"`, exp: next},
+		{link: `<` + next + `>; rel="next", <` + prev + `>; rel="previous"`, exp: next},
+		{link: `<` + prev + `>; rel="previous"`, exp: ""},
+	} {
+		s.Equal(tc.exp, nextPageURL(tc.link), tc.link)
+	}
+}
+
+func (s *ClientTestSuite) TestPaginate() {
+	next := `<https://test.myshopify.com/admin/api/2023-07/products.json?page_info=def>; rel="next"`
+	var urls []string
+	pages := []*http.Response{
+		response(http.StatusOK, http.Header{"Link": {next}}, `{"products":[{"id":1},{"id":2}]}`),
+		response(http.StatusOK, nil, `{"products":[{"id":3}]}`),
+	}
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		urls = append(urls, req.URL.String())
+		resp := pages[0]
+		pages = pages[1:]
+		return resp, nil
+	})
+	var ids []string
+	err := s.client.Paginate(context.Background(), &Session{Shop: "test.myshopify.com"}, "products.json",
+		url.Values{"limit": {"2"}}, func(page []json.RawMessage) error {
+			for _, p := range page {
+				ids = append(ids, string(p))
+			}
+			return nil
+		})
+	s.Require().NoError(err)
+	s.Equal([]string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, ids)
+	s.Equal([]string{
+		"https://test.myshopify.com/admin/api/" + VLatest.String() + "/products.json?limit=2",
+		"https://test.myshopify.com/admin/api/2023-07/products.json?limit=2&page_info=def",
+	}, urls)
+}
+
+func (s *ClientTestSuite) TestPaginateStop() {
+	next := `<https://test.myshopify.com/admin/api/2023-07/products.json?page_info=def>; rel="next"`
+	s.client.http.Transport = responses(nil,
+		response(http.StatusOK, http.Header{"Link": {next}}, `{"products":[{"id":1}]}`),
+	)
+	calls := 0
+	err := s.client.Paginate(context.Background(), &Session{Shop: "test.myshopify.com"}, "products.json", nil,
+		func(page []json.RawMessage) error {
+			calls++
+			return ErrStopPagination
+		})
+	s.NoError(err)
+	s.Equal(1, calls)
+}
+
+func (s *ClientTestSuite) TestVersions() {
+	v, err := ParseVersion("2024-07")
+	s.Require().NoError(err)
+	s.Equal(V202407, v)
+	_, err = ParseVersion("2024-08")
+	s.Error(err)
+	s.Equal(VLatest, SupportedVersions()[len(SupportedVersions())-1])
+
+	defer func(vs []Version) { versions = vs }(SupportedVersions())
+	RegisterVersion("2027-01")
+	app, err := NewApp(testAppConfig(), WithVersion("2027-01"))
+	s.Require().NoError(err)
+	s.Equal(Version("2027-01"), app.v)
+
+	app, err = NewApp(testAppConfig(), WithVersion("2023-01"))
+	s.Require().NoError(err)
+	s.Equal(VLatest, app.v, "unknown versions fall back to latest")
+}
+
+func (s *ClientTestSuite) TestDeprecationHandler() {
+	var reasons []string
+	s.client.deprecationHandler = func(reason, url string) {
+		reasons = append(reasons, reason+" "+url)
+	}
+	header := http.Header{}
+	header.Set(XDeprecatedReasonHeader, "deprecated field")
+	header.Set(XAPIVersionHeader, "2026-07")
+	s.client.http.Transport = responses(nil, response(http.StatusOK, header, `{}`))
+	req, _ := http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "shop.json"), nil)
+	resp, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal([]string{"deprecated field https://test.myshopify.com/admin/api/" + VLatest.String() + "/shop.json"},
+		reasons)
+	v, ok := ResponseVersion(resp)
+	s.True(ok)
+	s.Equal(V202607, v)
+
+	logger := &recordingLogger{}
+	s.client.logger = logger
+	s.client.deprecationHandler = nil
+	s.client.http.Transport = responses(nil, response(http.StatusOK, header, `{}`))
+	_, err = s.client.Do(req)
+	s.Require().NoError(err)
+	s.Contains(logger.entries, map[string]any{"msg": "deprecated shopify api call", "reason": "deprecated field",
+		"url": "https://test.myshopify.com/admin/api/" + VLatest.String() + "/shop.json"})
+}
+
+type recordingLogger struct {
+	noopLogger
+	entries []map[string]any
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) {
+	entry := map[string]any{"msg": msg}
+	for i := 0; i+1 < len(args); i += 2 {
+		entry[args[i].(string)] = args[i+1]
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func (l *recordingLogger) Warn(msg string, args ...any) {
+	l.Debug(msg, args...)
+}
+
+func (s *ClientTestSuite) TestLogRequest() {
+	logger := &recordingLogger{}
+	s.client.logger = logger
+	var requestIDs []string
+	resps := []*http.Response{
+		response(http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}, ""),
+		response(http.StatusOK, nil, `{}`),
+	}
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requestIDs = append(requestIDs, req.Header.Get(XRequestIDHeader))
+		resp := resps[0]
+		resps = resps[1:]
+		return resp, nil
+	})
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.client.ShopURL("test.myshopify.com", "shop.json"), nil)
+	_, err := s.client.Do(req)
+	s.Require().NoError(err)
+	s.Equal([]string{"req-1", "req-1"}, requestIDs)
+	s.Require().Len(logger.entries, 1)
+	entry := logger.entries[0]
+	s.Equal(http.MethodGet, entry["method"])
+	s.Equal("/admin/api/"+VLatest.String()+"/shop.json", entry["path"])
+	s.Equal(http.StatusOK, entry["status"])
+	s.Equal(1, entry["retries"])
+	s.Equal("req-1", entry["request_id"])
+}
+
+func (s *ClientTestSuite) TestWithHTTPClient() {
+	var calls int
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return response(http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}}, ""), nil
+		}
+		return response(http.StatusOK, nil, `{"shop":{"a":1}}`), nil
+	})}
+	app, err := NewApp(testAppConfig(), WithHTTPClient(client))
+	s.Require().NoError(err)
+	s.Same(client, app.http)
+	s.Equal(defaultHTTPTimeout, NewShopifyClient(&ClientConfig{}).http.Timeout)
+
+	s.Require().NoError(app.Client.Get(&Session{Shop: "test.myshopify.com"}, "shop.json", nil))
+	s.Equal(2, calls, "retries must use the provided client")
+}
+
+func (s *ClientTestSuite) TestTransportTuning() {
+	tr := NewShopifyClient(&ClientConfig{}).http.Transport.(*http.Transport)
+	s.Equal(100, tr.MaxIdleConnsPerHost)
+	s.Equal(90*time.Second, tr.IdleConnTimeout)
+
+	custom := &http.Client{Timeout: time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: 1}}
+	app, err := NewApp(testAppConfig(), WithHTTPClient(custom),
+		WithTransportTuning(TransportTuning{MaxIdleConnsPerHost: 10, MaxConnsPerHost: 20}))
+	s.Require().NoError(err)
+	tuned := app.http.Transport.(*http.Transport)
+	s.Equal(10, tuned.MaxIdleConnsPerHost)
+	s.Equal(20, tuned.MaxConnsPerHost)
+	s.Equal(time.Second, app.http.Timeout)
+	s.Equal(1, custom.Transport.(*http.Transport).MaxIdleConnsPerHost, "the given client must not be modified")
+
+	_, err = NewApp(testAppConfig(), WithHTTPClient(&http.Client{Transport: roundTripFunc(nil)}),
+		WithTransportTuning(DefaultTransportTuning))
+	s.Error(err)
+}
+
+// BenchmarkTransportDials compares the dials of concurrent requests to a
+// host with Go's default pool and DefaultTransportTuning.
+func BenchmarkTransportDials(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	for name, tuning := range map[string]TransportTuning{
+		"go-default": {MaxIdleConns: 100, MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost, IdleConnTimeout: 90 * time.Second},
+		"tuned":      DefaultTransportTuning,
+	} {
+		b.Run(name, func(b *testing.B) {
+			var dials atomic.Int64
+			tr := newTransport(tuning)
+			dial := tr.DialContext
+			tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dials.Add(1)
+				return dial(ctx, network, addr)
+			}
+			defer tr.CloseIdleConnections()
+			client := &http.Client{Transport: tr}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < 32; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := client.Get(srv.URL)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						_, _ = io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(dials.Load())/float64(b.N), "dials/op")
+		})
+	}
+}
+
+func (s *ClientTestSuite) TestShopUnavailable() {
+	for status, exp := range map[int]error{http.StatusPaymentRequired: ErrShopFrozen, http.StatusLocked: ErrShopLocked} {
+		calls := 0
+		s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return response(status, nil, `{"errors":"Unavailable Shop"}`), nil
+		})
+		req, _ := http.NewRequest(http.MethodGet, s.client.ShopURL("test.myshopify.com", "shop.json"), nil)
+		_, err := s.client.Do(req)
+		s.ErrorIs(err, exp)
+		s.Equal(1, calls, "unavailable shops must not be retried")
+	}
+}
+
+func (s *ClientTestSuite) TestClientFor() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	app, err := NewApp(testAppConfig(), WithSessionStore(store))
+	s.Require().NoError(err)
+	_, err = app.ClientFor(ctx, "test.myshopify.com")
+	s.True(IsNotFound(err))
+
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("test.myshopify.com"),
+		Shop: "test.myshopify.com", AccessToken: "token"}))
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("other.myshopify.com"),
+		Shop: "other.myshopify.com", AccessToken: "other"}))
+	client, err := app.ClientFor(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal("token", client.Session().AccessToken)
+	s.Same(app.http, client.http)
+	s.Same(app.throttle, client.throttle)
+	other, err := app.ClientFor(ctx, "other.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal("other", other.Session().AccessToken)
+	s.Nil(app.Session(), "the app's client must stay unbound")
+
+	cached, err := app.ClientFor(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Same(client, cached)
+
+	var reqs []*http.Request
+	app.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reqs = append(reqs, req)
+		return response(http.StatusOK, nil, `{"data":{}}`), nil
+	})
+	s.Require().NoError(client.GetContext(ctx, nil, "shop.json", nil))
+	s.Require().NoError(client.GraphQL(ctx, nil, "{ shop { name } }", nil, nil))
+	s.Require().Len(reqs, 2)
+	for _, req := range reqs {
+		s.Equal("test.myshopify.com", req.URL.Host)
+		s.Equal("token", req.Header.Get(XAccessToken))
+	}
+
+	s.Require().NoError(app.HandleUninstalled(ctx, &WebhookContext{Shop: "test.myshopify.com"}))
+	_, err = app.ClientFor(ctx, "test.myshopify.com")
+	s.True(IsNotFound(err), "uninstall must invalidate the cached client")
+}
+
+func (s *ClientTestSuite) TestClientForInvalidToken() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	app, err := NewApp(testAppConfig(), WithSessionStore(store))
+	s.Require().NoError(err)
+	app.http.Transport = responses(nil, response(http.StatusUnauthorized, nil,
+		`{"errors":"[API] Invalid API key or access token (unrecognized login or wrong password)"}`))
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("test.myshopify.com"),
+		Shop: "test.myshopify.com", AccessToken: "revoked"}))
+	client, err := app.ClientFor(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	err = client.GraphQL(ctx, client.Session(), "{ shop { name } }", nil, nil)
+	s.ErrorIs(err, ErrInvalidToken)
+	_, err = store.Get(ctx, OfflineSessionID("test.myshopify.com"))
+	s.ErrorIs(err, ErrNotFound, "the stale session must be deleted")
+	_, err = app.ClientFor(ctx, "test.myshopify.com")
+	s.True(IsNotFound(err), "the cached client must be invalidated")
+}
+
+func (s *ClientTestSuite) TestAuthStrategy() {
+	ctx := context.Background()
+	sess := &Session{Shop: "test.myshopify.com", AccessToken: "token"}
+	var reqs []*http.Request
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reqs = append(reqs, req)
+		return response(http.StatusOK, nil, `{"data":{}}`), nil
+	})
+	s.client.http.Transport = transport
+	s.Require().NoError(s.client.GetContext(ctx, sess, "shop.json", nil))
+	s.Require().NoError(s.client.GraphQL(ctx, sess, "{ shop { name } }", nil, nil))
+	s.Require().Len(reqs, 2)
+	for _, req := range reqs {
+		s.Equal("token", req.Header.Get(XAccessToken))
+		_, _, ok := req.BasicAuth()
+		s.False(ok)
+	}
+
+	reqs = nil
+	app, err := NewApp(testAppConfig(), WithSessionStore(&inMemSessionStore{}),
+		WithAuthStrategy(BasicAuth("key", "password")), WithHTTPClient(&http.Client{Transport: transport}))
+	s.Require().NoError(err)
+	s.Require().NoError(app.GetContext(ctx, sess, "shop.json", nil))
+	s.Require().NoError(app.GraphQL(ctx, sess, "{ shop { name } }", nil, nil))
+	s.Require().Len(reqs, 2)
+	for _, req := range reqs {
+		s.Empty(req.Header.Get(XAccessToken))
+		user, password, _ := req.BasicAuth()
+		s.Equal("key", user)
+		s.Equal("password", password)
+	}
+
+	_, err = NewApp(testAppConfig(), WithAuthStrategy(nil))
+	s.Error(err)
+}
+
+func (s *ClientTestSuite) TestGetOrder() {
+	s.client.http.Transport = responses(nil, response(http.StatusOK, nil, `{"order":{"id":450789469,"name":"#1001",
+		"email":null,"currency":"EUR","total_price":"199.65","total_price_set":{
+		"shop_money":{"amount":"199.65","currency_code":"EUR"},"presentment_money":{"amount":"217.39","currency_code":"USD"}},
+		"line_items":[{"id":466157049,"variant_id":39072856,"title":"IPod Nano","quantity":1,"price":"199.00"}],
+		"cancelled_at":null,"created_at":"2008-01-10T11:00:00-05:00"}}`))
+	order, err := s.client.GetOrder(context.Background(), &Session{Shop: "test.myshopify.com"}, 450789469)
+	s.Require().NoError(err)
+	s.Equal("#1001", order.Name)
+	s.Nil(order.Email)
+	s.Equal(MustMoney("199.65", ""), order.TotalPrice)
+	s.Equal(MoneyBag{ShopMoney: MustMoney("199.65", "EUR"),
+		PresentmentMoney: MustMoney("217.39", "USD")}, *order.TotalPriceSet)
+	s.Require().Len(order.LineItems, 1)
+	s.Equal(39072856, *order.LineItems[0].VariantID)
+	s.Equal(MustMoney("199", ""), order.LineItems[0].Price)
+	s.Nil(order.CancelledAt)
+	s.True(time.Date(2008, 1, 10, 16, 0, 0, 0, time.UTC).Equal(*order.CreatedAt))
+}
+
+func (s *ClientTestSuite) TestCancelDuringBackoff() {
+	s.client.sleep = SleepContext
+	s.client.backoffBase, s.client.backoffMax = time.Minute, time.Minute
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusServiceUnavailable, nil, ""), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := s.client.GetContext(ctx, &Session{Shop: "test.myshopify.com"}, "shop.json", nil)
+	s.ErrorIs(err, context.Canceled)
+	s.Less(time.Since(start), 5*time.Second)
+}
+
+func (s *ClientTestSuite) TestBackoff() {
+	s.client.backoffBase, s.client.backoffMax = 100*time.Hour, 1000*time.Hour
+	for _, attempt := range []int{0, 3, 4, 10, 15, 21, 31, 40, 100} {
+		d := s.client.backoff(attempt)
+		s.Positive(d, "backoffs must not overflow to 0, attempt %d", attempt)
+		s.LessOrEqual(d, 1000*time.Hour, attempt)
+	}
+	s.client.backoffBase, s.client.backoffMax = time.Millisecond, time.Second
+	s.LessOrEqual(s.client.backoff(2), 4*time.Millisecond, "backoffs below max must grow exponentially")
+
+	for _, backoff := range [][2]time.Duration{{0, time.Second}, {-time.Second, time.Second}, {time.Minute, time.Second}} {
+		_, err := NewApp(testAppConfig(), WithBackoff(backoff[0], backoff[1]))
+		s.Error(err, backoff)
+	}
+	app, err := NewApp(testAppConfig(), WithBackoff(time.Second, time.Minute))
+	s.Require().NoError(err)
+	s.Equal(time.Second, app.backoffBase)
+	s.Equal(time.Minute, app.backoffMax)
+}
+
+func (s *ClientTestSuite) TestBackoffDeadline() {
+	s.client.sleep = SleepContext
+	s.client.backoffBase, s.client.backoffMax = time.Hour, time.Hour
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusServiceUnavailable, nil, ""), nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.client.GetContext(ctx, &Session{Shop: "test.myshopify.com"}, "shop.json", nil)
+	s.Less(time.Since(start), 50*time.Millisecond, "backoffs past the deadline must be skipped")
+	var apiErr *ShopifyAPIError
+	s.Require().ErrorAs(err, &apiErr, "the last response must be returned")
+	s.Equal(http.StatusServiceUnavailable, apiErr.StatusCode)
+
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusTooManyRequests, http.Header{"Retry-After": {"10"}}, ""), nil
+	})
+	start = time.Now()
+	err = s.client.GetContext(ctx, &Session{Shop: "test.myshopify.com"}, "shop.json", nil)
+	s.Less(time.Since(start), 50*time.Millisecond)
+	s.Require().ErrorAs(err, &apiErr)
+	s.Equal(http.StatusTooManyRequests, apiErr.StatusCode)
+}
+
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (s *ClientTestSuite) TestMaxResponseBytes() {
+	s.Equal(int64(defaultMaxResponseBytes), s.client.maxResponseBytes)
+	s.client.maxResponseBytes = 16
+	s.client.http.Transport = responses(nil,
+		response(http.StatusOK, nil, `{"shop":{"name":"a much too long name"}}`),
+		response(http.StatusOK, nil, `{"shop":{"a":1}}`),
+	)
+	var out map[string]any
+	err := s.client.GetContext(context.Background(), &Session{Shop: "test.myshopify.com"}, "shop.json", &out)
+	s.ErrorIs(err, ErrResponseTooLarge)
+	s.NoError(s.client.GetContext(context.Background(), &Session{Shop: "test.myshopify.com"}, "shop.json", &out),
+		"bodies of exactly the limit must be read")
+
+	s.client.throttle.enabled = true
+	body := &countingReader{Reader: strings.NewReader(`{"data":{"shop":{"name":"` + strings.Repeat("a", 1<<20) + `"}}}`)}
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(body)}, nil
+	})
+	err = s.client.GraphQL(context.Background(), &Session{Shop: "test.myshopify.com"}, "{ shop { name } }", nil, &out)
+	s.ErrorIs(err, ErrResponseTooLarge, "GraphQL responses must be limited as well")
+	s.LessOrEqual(body.n, 1024, "GraphQL responses must not be read past the limit")
+
+	_, err = NewApp(testAppConfig(), WithMaxResponseBytes(0))
+	s.Error(err)
+}
+
+type recordingMetrics struct {
+	noopMetrics
+	requests []RequestMetrics
+	retries  []int
+}
+
+func (m *recordingMetrics) RecordRequest(r RequestMetrics) {
+	m.requests = append(m.requests, r)
+}
+
+func (m *recordingMetrics) RecordRetry(_ string, _ string, status int) {
+	m.retries = append(m.retries, status)
+}
+
+func (s *ClientTestSuite) TestMetrics() {
+	metrics := &recordingMetrics{}
+	s.client.metrics = metrics
+	s.client.http.Transport = responses(nil,
+		response(http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}, ""),
+		response(http.StatusNotFound, nil, `{"errors":"Not Found"}`),
+	)
+	err := s.client.GetContext(context.Background(), &Session{Shop: "test.myshopify.com"}, "products/632910392.json", nil)
+	s.True(IsNotFound(err))
+	s.Equal([]int{http.StatusTooManyRequests}, metrics.retries)
+	s.Require().Len(metrics.requests, 1)
+	m := metrics.requests[0]
+	s.Equal("test.myshopify.com", m.Shop)
+	s.Equal("products/:id.json", m.Endpoint)
+	s.Equal(http.StatusNotFound, m.Status)
+	s.Equal(1, m.Retries)
+	s.Equal(ErrorClassClient, m.ErrorClass)
+}
+
+func (s *ClientTestSuite) TestMetricsEndpoint() {
+	for path, exp := range map[string]string{
+		"/admin/api/2024-07/graphql.json":                 "graphql.json",
+		"/admin/api/2024-07/orders/1/fulfillments/2.json": "orders/:id/fulfillments/:id.json",
+		"/admin/api/2024-07/products/1/2/images.json":     "products/:id/:id/images.json",
+		"/admin/oauth/access_token":                       "/admin/oauth/access_token",
+	} {
+		s.Equal(exp, metricsEndpoint(path), path)
+	}
+}
+
+func (s *ClientTestSuite) TestAPIHostOverride() {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/access_token") {
+			_, _ = w.Write([]byte(`{"access_token":"token","scope":"read_products"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"shop":{"name":"Test"}}}`))
+	}))
+	defer server.Close()
+	app, err := NewApp(testAppConfig(), WithAPIHostOverride(func(shop string) string {
+		return server.URL + "/" + shop + "/admin/"
+	}))
+	s.Require().NoError(err)
+	token, err := app.AccessTokenContext(context.Background(), "test.myshopify.com", "code")
+	s.Require().NoError(err)
+	s.Equal("token", token.Token)
+	err = app.Client.GraphQL(context.Background(), &Session{Shop: "test.myshopify.com", AccessToken: "token"},
+		"{ shop { name } }", nil, nil)
+	s.Require().NoError(err)
+	s.Equal([]string{"/test.myshopify.com/admin/oauth/access_token",
+		"/test.myshopify.com/admin/api/" + VLatest.String() + "/graphql.json"}, paths)
+	s.Equal("https://test.myshopify.com/admin/api/"+VLatest.String()+"/shop.json",
+		s.client.ShopURL("test.myshopify.com", "shop.json"))
+}
+
+func (s *ClientTestSuite) TestShopInfo() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	calls := 0
+	app, err := NewApp(testAppConfig(), WithSessionStore(store),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return response(http.StatusOK, nil, `{"data":{"shop":{"id":"gid://shopify/Shop/1","name":"Test",
+				"myshopifyDomain":"test.myshopify.com","primaryDomain":{"url":"https://shop.example.com","host":"shop.example.com"},
+				"currencyCode":"EUR","ianaTimezone":"Europe/Berlin",
+				"plan":{"displayName":"Developer Preview","partnerDevelopment":true,"shopifyPlus":false},
+				"features":{"storefront":true,"giftCards":false}}}}`), nil
+		})}))
+	s.Require().NoError(err)
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("test.myshopify.com"),
+		Shop: "test.myshopify.com", AccessToken: "token"}))
+	info, err := app.ShopInfo(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal("EUR", info.CurrencyCode)
+	s.Equal("shop.example.com", info.PrimaryDomain.Host)
+	s.Equal("Europe/Berlin", info.Location().String())
+	s.True(info.IsDevelopmentStore())
+	s.True(info.Features.Storefront)
+
+	cached, err := app.ShopInfo(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Same(info, cached)
+	s.Equal(1, calls)
+	client, err := app.ClientFor(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Require().NoError(client.InvalidateShopCache(ctx, "test.myshopify.com"))
+	_, err = app.ShopInfo(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal(2, calls, "invalidating on a shop client must reach the app's cache")
+	other, err := app.ClientFor(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.NotSame(client, other)
+
+	s.Require().NoError(app.NewWebhookRouter().Dispatch(ctx, &WebhookContext{Shop: "test.myshopify.com",
+		Topic: TopicAppScopesUpdate}))
+	_, err = app.ShopInfo(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal(3, calls, "scopes updates must invalidate the cache")
+}
+
+func (s *ClientTestSuite) TestGrantedScopes() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	calls := 0
+	app, err := NewApp(testAppConfig(), WithSessionStore(store), WithScopes([]string{"read_products", "write_orders"}),
+		WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return response(http.StatusOK, nil, `{"data":{"currentAppInstallation":{"accessScopes":[
+				{"handle":"write_orders"},{"handle":"read_products"}]}}}`), nil
+		})}))
+	s.Require().NoError(err)
+	sess := &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com",
+		AccessToken: "token", Scopes: "read_products"}
+	s.Require().NoError(store.Store(ctx, sess))
+	scopes, err := app.GrantedScopes(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal("read_products,write_orders", scopes.String())
+	_, err = app.GrantedScopes(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.Equal(1, calls)
+
+	s.Require().NoError(app.NewWebhookRouter().Dispatch(ctx, &WebhookContext{Shop: "test.myshopify.com",
+		Topic: TopicAppScopesUpdate}))
+	s.Equal(scopes, app.syncGrantedScopes(ctx, sess))
+	s.Equal(2, calls, "scopes updates must invalidate the cache")
+	stored, err := store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.Equal("read_products,write_orders", stored.Scopes)
+
+	online := &Session{Shop: "test.myshopify.com", IsOnline: true, Scopes: "read_products"}
+	s.Equal("read_products", app.syncGrantedScopes(ctx, online).String())
+}
+
+func (s *ClientTestSuite) TestRunConcurrent() {
+	var mu sync.Mutex
+	inFlight, maxInFlight, calls := 0, 0, 0
+	s.client.rest.size = 1000
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		calls++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		used := calls
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return response(http.StatusCreated, http.Header{XCallLimitHeader: {fmt.Sprintf("%d/1000", used)}}, "{}"), nil
+	})
+	sess := &Session{Shop: "test.myshopify.com", AccessToken: "token"}
+	tasks := make([]func(*Client) error, 50)
+	for i := range tasks {
+		tasks[i] = func(c *Client) error {
+			return c.CreateContext(context.Background(), sess, "products.json", map[string]any{"product": map[string]any{}}, nil)
+		}
+	}
+	tasks = append(tasks, func(c *Client) error { return errors.New("failed") })
+	err := s.client.RunConcurrent(context.Background(), tasks, 8)
+	s.EqualError(err, "failed")
+	s.Equal(50, calls)
+	s.LessOrEqual(maxInFlight, 8)
+	s.Equal(1000.0, s.client.rest.buckets["test.myshopify.com/admin"].size)
+}
+
+func (s *ClientTestSuite) TestRESTThrottleHostOverride() {
+	app, err := NewApp(testAppConfig(), WithAPIHostOverride(func(shop string) string {
+		return "http://proxy.example.com/shops/" + shop
+	}))
+	s.Require().NoError(err)
+	app.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, http.Header{XCallLimitHeader: {"10/80"}}, "{}"), nil
+	})
+	for _, shop := range []string{"test.myshopify.com", "other.myshopify.com"} {
+		sess := &Session{Shop: shop, AccessToken: "token"}
+		s.Require().NoError(app.GetContext(context.Background(), sess, "shop.json", nil))
+	}
+	s.Require().NoError(app.GraphQL(context.Background(), &Session{Shop: "test.myshopify.com", AccessToken: "token"},
+		"{ shop { name } }", nil, nil))
+	s.Len(app.rest.buckets, 2, "shops on the override host must be throttled separately")
+	s.Equal(80.0, app.rest.buckets["proxy.example.com/shops/test.myshopify.com"].size)
+	s.Equal(80.0, app.rest.buckets["proxy.example.com/shops/other.myshopify.com"].size)
+}
+
+func (s *ClientTestSuite) TestShopGraphQLBucket() {
+	for _, override := range []bool{false, true} {
+		opts := []Opt{WithGraphQLThrottle(true), WithShopGraphQLBucket("plus.myshopify.com", 2000, 100)}
+		prefix := ""
+		if override {
+			opts = append(opts, WithAPIHostOverride(func(shop string) string {
+				return "http://proxy.example.com/shops/" + shop
+			}))
+			prefix = "proxy.example.com/shops/"
+		}
+		app, err := NewApp(testAppConfig(), opts...)
+		s.Require().NoError(err)
+		app.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return response(http.StatusOK, nil, `{"data":{}}`), nil
+		})
+		for _, shop := range []string{"plus.myshopify.com", "test.myshopify.com"} {
+			s.Require().NoError(app.GraphQL(context.Background(), &Session{Shop: shop, AccessToken: "token"},
+				"{ shop { name } }", nil, nil))
+		}
+		plus, standard := prefix+"plus.myshopify.com", prefix+"test.myshopify.com"
+		if !override {
+			plus, standard = plus+"/admin", standard+"/admin"
+		}
+		s.Require().Len(app.throttle.buckets, 2, "shops must be throttled separately")
+		s.Equal(2000.0, app.throttle.buckets[plus].maxAvailable)
+		s.Equal(100.0, app.throttle.buckets[plus].restoreRate)
+		s.Equal(float64(defaultGraphQLMaxAvailable), app.throttle.buckets[standard].maxAvailable)
+	}
+}
+
+func (s *ClientTestSuite) TestWithVersion() {
+	var paths []string
+	s.client.http.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		return response(http.StatusOK, nil, `{"data":{}}`), nil
+	})
+	sess := &Session{Shop: "test.myshopify.com", AccessToken: "token"}
+	legacy := s.client.WithVersion(V202401)
+	s.Require().NoError(legacy.GraphQL(context.Background(), sess, "{ shop { name } }", nil, nil))
+	s.Require().NoError(s.client.GraphQL(context.Background(), sess, "{ shop { name } }", nil, nil))
+	s.Equal([]string{"/admin/api/2024-01/graphql.json", "/admin/api/" + VLatest.String() + "/graphql.json"}, paths)
+	s.Equal(VLatest, s.client.v)
+}
+
+func (s *ClientTestSuite) TestJSONCodec() {
+	defer func(m JSONMarshaler, u JSONUnmarshaler) { jsonMarshal, jsonUnmarshal = m, u }(jsonMarshal, jsonUnmarshal)
+	var marshaled, unmarshaled int
+	s.Require().NoError(SetJSONCodec(func(v any) ([]byte, error) {
+		marshaled++
+		return json.Marshal(v)
+	}, func(data []byte, v any) error {
+		unmarshaled++
+		return json.Unmarshal(data, v)
+	}))
+	app, err := NewApp(testAppConfig())
+	s.Require().NoError(err)
+	app.http.Transport = responses(nil, response(http.StatusOK, nil, `{"data":{"shop":{"name":"Test"}}}`))
+	var out struct {
+		Shop struct {
+			Name string `json:"name"`
+		} `json:"shop"`
+	}
+	err = app.Client.GraphQL(context.Background(), &Session{Shop: "test.myshopify.com"}, "{ shop { name } }", nil, &out)
+	s.Require().NoError(err)
+	s.Equal("Test", out.Shop.Name)
+	s.Equal(1, marshaled)
+	s.Positive(unmarshaled)
+
+	var price Money
+	s.Require().NoError(json.Unmarshal([]byte(`{"amount":"19.99","currencyCode":"USD"}`), &price))
+	_, err = json.Marshal(price)
+	s.Require().NoError(err)
+	s.Equal(2, marshaled, "money must be encoded with the codec")
+
+	s.Error(SetJSONCodec(nil, json.Unmarshal))
+}
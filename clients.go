@@ -0,0 +1,114 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sync"
+)
+
+// shopClients caches the clients returned by ClientFor by shop.
+type shopClients struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+func newShopClients() *shopClients {
+	return &shopClients{clients: map[string]*Client{}}
+}
+
+func (s *shopClients) get(shop string) (*Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[shop]
+	return c, ok
+}
+
+func (s *shopClients) put(shop string, c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[shop] = c
+}
+
+func (s *shopClients) invalidate(shop string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, shop)
+}
+
+// ClientFor returns a client bound to the offline session of shop, see
+// Client.Session. Its methods use that session when passed a nil session.
+// Clients share the HTTP client and GraphQL throttle of the app and are cached
+// until the shop reinstalls or uninstalls the app.
+func (a *App) ClientFor(ctx context.Context, shop string) (*Client, error) {
+	if c, ok := a.clients.get(shop); ok {
+		return c, nil
+	}
+	sess, err := a.SessionStore.Get(ctx, OfflineSessionID(shop))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offline session for %s: %w", shop, err)
+	}
+	c := a.Client.bind(sess)
+	c.invalidToken = a.discardSession
+	a.clients.put(shop, c)
+	return c, nil
+}
+
+// discardSession deletes sess after Shopify rejected its access token, unless
+// the shop reinstalled meanwhile, so the shop is asked to reauthorize.
+func (a *App) discardSession(ctx context.Context, sess *Session) {
+	if err := a.invalidateShop(ctx, sess.Shop); err != nil {
+		a.Client.logger.Warn("failed to invalidate shop cache", "shop", sess.Shop, "error", err)
+	}
+	stored, err := a.SessionStore.Get(ctx, sess.SessionID())
+	if err != nil || stored.AccessToken != sess.AccessToken {
+		return
+	}
+	if err = a.SessionStore.Delete(ctx, sess.SessionID()); err != nil {
+		a.Client.logger.Warn("failed to delete session with invalid token", "shop", sess.Shop, "error", err)
+	}
+}
+
+func (c *Client) bind(sess *Session) *Client {
+	bound := *c
+	bound.session = sess
+	return &bound
+}
+
+// WithVersion returns a copy of the client making its requests with API
+// version v, e.g. to call an endpoint removed from the app's version. The copy
+// shares the HTTP client, throttles and session of c.
+func (c *Client) WithVersion(v Version) *Client {
+	config := *c.ClientConfig
+	config.v = v
+	versioned := *c
+	versioned.ClientConfig = &config
+	return &versioned
+}
+
+// Session returns the session a client returned by App.ClientFor is bound
+// to, nil for the app's client.
+func (c *Client) Session() *Session {
+	return c.session
+}
+
+// sessionOr returns sess, or the session c is bound to if sess is nil.
+func (c *Client) sessionOr(sess *Session) *Session {
+	if sess == nil {
+		return c.session
+	}
+	return sess
+}
+
+// MustGetShopClient returns the client set by App.AuthenticatedSession.
+func MustGetShopClient(c *gin.Context) *Client {
+	client, ok := c.Get(ShopClientKey)
+	if !ok {
+		panic("context doesn't hold client")
+	}
+	cl, ok := client.(*Client)
+	if !ok {
+		panic("context doesn't hold client")
+	}
+	return cl
+}
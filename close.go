@@ -0,0 +1,56 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Closer is implemented by session, nonce and dedup stores holding resources
+// such as connection pools or pending writes. Close flushes pending writes and
+// releases the resources.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+type closeOnce struct {
+	once sync.Once
+	err  error
+}
+
+func (o *closeOnce) close(fn func() error) error {
+	o.once.Do(func() {
+		o.err = fn()
+	})
+	return o.err
+}
+
+// Close closes the app's stores implementing Closer and idle connections of
+// the HTTP client. Stores shared between roles, such as a Redis store used
+// for sessions and webhook dedup, are closed once. Calling Close again
+// returns the result of the first call.
+func (a *App) Close(ctx context.Context) error {
+	return a.closer.close(func() error {
+		var errs []error
+		closed := map[Closer]bool{}
+		for _, store := range []any{a.SessionStore, a.nonceStore, a.webhookDedup} {
+			c, ok := store.(Closer)
+			if !ok {
+				continue
+			}
+			if reflect.TypeOf(c).Comparable() {
+				if closed[c] {
+					continue
+				}
+				closed[c] = true
+			}
+			if err := c.Close(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close %T: %w", c, err))
+			}
+		}
+		a.http.CloseIdleConnections()
+		return errors.Join(errs...)
+	})
+}
@@ -0,0 +1,41 @@
+package shopigo
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// JSONMarshaler and JSONUnmarshaler have the signatures of json.Marshal and
+// json.Unmarshal, so drop-in libraries like sonic or jsoniter can be used.
+type (
+	JSONMarshaler   func(v any) ([]byte, error)
+	JSONUnmarshaler func(data []byte, v any) error
+)
+
+var (
+	jsonMarshal   JSONMarshaler   = json.Marshal
+	jsonUnmarshal JSONUnmarshaler = json.Unmarshal
+)
+
+// SetJSONCodec encodes and decodes API requests and responses, webhooks,
+// stored sessions and money amounts with the given functions instead of
+// encoding/json. The codec is process-wide, used by all apps and stores; like
+// RegisterVersion it must be set before apps are created and not be changed
+// while requests are handled.
+func SetJSONCodec(marshal JSONMarshaler, unmarshal JSONUnmarshaler) error {
+	if marshal == nil || unmarshal == nil {
+		return errors.New("json codec requires a marshaler and an unmarshaler")
+	}
+	jsonMarshal, jsonUnmarshal = marshal, unmarshal
+	return nil
+}
+
+// decodeJSON decodes the JSON document of r into v with the codec.
+func decodeJSON(r io.Reader, v any) error {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return jsonUnmarshal(bs, v)
+}
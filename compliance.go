@@ -0,0 +1,63 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	TopicCustomersDataRequest = "customers/data_request"
+	TopicCustomersRedact      = "customers/redact"
+	TopicShopRedact           = "shop/redact"
+)
+
+type CustomersDataRequestPayload struct {
+	ShopID          int      `json:"shop_id"`
+	ShopDomain      string   `json:"shop_domain"`
+	OrdersRequested []int    `json:"orders_requested"`
+	Customer        Customer `json:"customer"`
+	DataRequest     struct {
+		ID int `json:"id"`
+	} `json:"data_request"`
+}
+
+type CustomersRedactPayload struct {
+	ShopID         int      `json:"shop_id"`
+	ShopDomain     string   `json:"shop_domain"`
+	Customer       Customer `json:"customer"`
+	OrdersToRedact []int    `json:"orders_to_redact"`
+}
+
+type ShopRedactPayload struct {
+	ShopID     int    `json:"shop_id"`
+	ShopDomain string `json:"shop_domain"`
+}
+
+// ComplianceHandlers handles the mandatory compliance webhooks every public
+// app has to subscribe to. Topics without a callback are acknowledged with a
+// warning, so the app still passes the App Store review.
+type ComplianceHandlers struct {
+	CustomersDataRequest func(ctx context.Context, p *CustomersDataRequestPayload) error
+	CustomersRedact      func(ctx context.Context, p *CustomersRedactPayload) error
+	ShopRedact           func(ctx context.Context, p *ShopRedactPayload) error
+}
+
+func (h ComplianceHandlers) Register(r *WebhookRouter) {
+	r.On(TopicCustomersDataRequest, complianceHandler(r.app.Client.logger, h.CustomersDataRequest))
+	r.On(TopicCustomersRedact, complianceHandler(r.app.Client.logger, h.CustomersRedact))
+	r.On(TopicShopRedact, complianceHandler(r.app.Client.logger, h.ShopRedact))
+}
+
+func complianceHandler[T any](logger Logger, fn func(ctx context.Context, p *T) error) WebhookHandler {
+	return func(ctx context.Context, wh *WebhookContext) error {
+		if fn == nil {
+			logger.Warn("no handler for compliance webhook", "topic", wh.Topic, "shop", wh.Shop)
+			return nil
+		}
+		var p T
+		if err := jsonUnmarshal(wh.Body, &p); err != nil {
+			return fmt.Errorf("failed to decode %s payload: %w", wh.Topic, err)
+		}
+		return fn(ctx, &p)
+	}
+}
@@ -0,0 +1,42 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// RunConcurrent runs the tasks with at most concurrency at a time and returns
+// their joined errors. The requests of the tasks share the client's REST and
+// GraphQL throttles, so a higher concurrency doesn't exceed the shop's rate
+// limit but waits for the bucket to leak. Tasks not started yet are skipped
+// once ctx is done.
+func (c *Client) RunConcurrent(ctx context.Context, tasks []func(*Client) error, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, task := range tasks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		wg.Add(1)
+		go func(task func(*Client) error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := task(c); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(task)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -26,6 +26,15 @@ func setRedirectUri(c *gin.Context, redirectUri string) {
 	c.Set(metadataKey, d)
 }
 
+func setHost(c *gin.Context, host string) {
+	if _, ok := c.Get(metadataKey); !ok {
+		c.Set(metadataKey, authMetadata{})
+	}
+	d := mustGetMetaData(c)
+	d.host = host
+	c.Set(metadataKey, d)
+}
+
 func mustGetMetaData(c *gin.Context) authMetadata {
 	d, ok := c.MustGet(metadataKey).(authMetadata)
 	if !ok {
@@ -53,3 +62,15 @@ func getShop(c *gin.Context) string {
 func mustGetRedirectUri(c *gin.Context) string {
 	return mustGetMetaData(c).redirectUri
 }
+
+// GetAuthRedirectURI returns the URL requests of shops without session are
+// redirected to, see WithUnauthenticatedHandler.
+func GetAuthRedirectURI(c *gin.Context) string {
+	return getMetaData(c).redirectUri
+}
+
+// GetHost returns the base64 encoded host of the shop admin, available to
+// handlers chained after Install. It's empty if Shopify didn't pass it.
+func GetHost(c *gin.Context) string {
+	return getMetaData(c).host
+}
@@ -12,8 +12,49 @@ import (
 	"time"
 )
 
-func SetSignedCookie(c *gin.Context, key string, name string, val string, path string, exp *time.Time) {
-	sigName := name + ".sig"
+// CookieOptions controls the attributes of the cookies set by the app. Prefix
+// is prepended to the cookie names. Partitioned opts into CHIPS, which lets
+// embedded apps keep cookies once third-party cookies are blocked.
+type CookieOptions struct {
+	SameSite    http.SameSite
+	Secure      bool
+	Domain      string
+	Prefix      string
+	Partitioned bool
+}
+
+// defaultCookieOptions returns the options for embedded apps, which run in
+// the admin iframe and need SameSite=None, or standalone apps.
+func defaultCookieOptions(embedded bool) CookieOptions {
+	if embedded {
+		return CookieOptions{SameSite: http.SameSiteNoneMode, Secure: true, Partitioned: true}
+	}
+	return CookieOptions{SameSite: http.SameSiteLaxMode, Secure: true}
+}
+
+func (o CookieOptions) name(name string) string {
+	return o.Prefix + name
+}
+
+// set writes the cookie with the options applied. The Partitioned attribute
+// is appended manually as http.Cookie doesn't support it before Go 1.23.
+func (o CookieOptions) set(c *gin.Context, cookie *http.Cookie) {
+	cookie.Name = o.name(cookie.Name)
+	cookie.Domain = o.Domain
+	cookie.Secure = o.Secure
+	cookie.SameSite = o.SameSite
+	cookie.HttpOnly = true
+	v := cookie.String()
+	if v == "" {
+		return
+	}
+	if o.Partitioned {
+		v += "; Partitioned"
+	}
+	c.Writer.Header().Add("Set-Cookie", v)
+}
+
+func (o CookieOptions) setSigned(c *gin.Context, key string, name string, val string, path string, exp *time.Time) {
 	hash := hmac.New(sha256.New, []byte(key))
 	hash.Write([]byte(val))
 	sig := hex.EncodeToString(hash.Sum(nil))
@@ -24,22 +65,18 @@ func SetSignedCookie(c *gin.Context, key string, name string, val string, path s
 	} else {
 		expires = *exp
 	}
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     name,
-		Value:    val,
-		Path:     path,
-		Expires:  expires,
-		Secure:   true,
-		HttpOnly: true,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     sigName,
-		Value:    sig,
-		Path:     path,
-		Expires:  expires,
-		Secure:   true,
-		HttpOnly: true,
-	})
+	o.set(c, &http.Cookie{Name: name, Value: val, Path: path, Expires: expires})
+	o.set(c, &http.Cookie{Name: name + ".sig", Value: sig, Path: path, Expires: expires})
+}
+
+func (o CookieOptions) delete(c *gin.Context, path string, names ...string) {
+	for _, name := range names {
+		o.set(c, &http.Cookie{Name: name, Value: "", Path: path, Expires: time.Unix(0, 0)})
+	}
+}
+
+func SetSignedCookie(c *gin.Context, key string, name string, val string, path string, exp *time.Time) {
+	CookieOptions{Secure: true}.setSigned(c, key, name, val, path, exp)
 }
 
 func CompareSignedCookie(c *gin.Context, key string, name string, val string) (bool, error) {
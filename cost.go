@@ -0,0 +1,441 @@
+package shopigo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	costObject   = 1
+	costMutation = 10
+	costConnBase = 2
+	// maxPageSize is assumed for page sizes passed as variables without
+	// default, the most Shopify returns per connection.
+	maxPageSize = 250
+)
+
+// EstimateCost statically estimates the requested cost of query following
+// Shopify's cost rules: scalars are free, objects cost 1, connections 2 plus
+// their page size times the cost of a node and mutations 10. Page sizes given
+// as variables are taken from their default, 250 otherwise. Shopify computes
+// the cost from the schema, so this only approximates it.
+func EstimateCost(query string) (int, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	p := &costParser{tokens: tokens, fragments: map[string][]costField{}, defaults: map[string]string{}}
+	op, err := p.document()
+	if err != nil {
+		return 0, err
+	}
+	if op.mutation {
+		return costMutation * len(op.fields), nil
+	}
+	return p.selectionCost(op.fields, 0)
+}
+
+type costToken struct {
+	punct byte // 0 for names, numbers and strings
+	text  string
+}
+
+type costField struct {
+	name     string
+	args     map[string]string
+	children []costField
+	spread   string
+}
+
+type costOperation struct {
+	mutation bool
+	fields   []costField
+}
+
+type costParser struct {
+	tokens    []costToken
+	pos       int
+	fragments map[string][]costField
+	defaults  map[string]string
+}
+
+func tokenizeQuery(query string) ([]costToken, error) {
+	var tokens []costToken
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == ',' || ch == '#':
+			if ch == '#' {
+				i = skipIgnored(query, i)
+			} else {
+				i++
+			}
+		case ch == '"':
+			end := skipIgnored(query, i)
+			tokens = append(tokens, costToken{text: query[i:end]})
+			i = end
+		case ch == '.':
+			if len(query) < i+3 || query[i:i+3] != "..." {
+				return nil, fmt.Errorf("unexpected character %q", ch)
+			}
+			tokens = append(tokens, costToken{punct: '.', text: "..."})
+			i += 3
+		case isNameChar(ch) || ch == '-':
+			end := i + 1
+			for end < len(query) && (isNameChar(query[end]) || query[end] == '.' || query[end] == '+' || query[end] == '-') {
+				end++
+			}
+			tokens = append(tokens, costToken{text: query[i:end]})
+			i = end
+		default:
+			tokens = append(tokens, costToken{punct: ch, text: string(ch)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func (p *costParser) peek() costToken {
+	if p.pos >= len(p.tokens) {
+		return costToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *costParser) next() costToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *costParser) expect(punct byte) error {
+	if t := p.next(); t.punct != punct {
+		return fmt.Errorf("expected %q, got %q", punct, t.text)
+	}
+	return nil
+}
+
+// document parses the fragments and returns the first operation.
+func (p *costParser) document() (*costOperation, error) {
+	var op *costOperation
+	for p.pos < len(p.tokens) {
+		t := p.peek()
+		switch {
+		case t.punct == '{':
+			fields, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			if op == nil {
+				op = &costOperation{fields: fields}
+			}
+		case t.text == "fragment":
+			p.next()
+			name := p.next().text
+			if p.next().text != "on" {
+				return nil, fmt.Errorf("fragment %s without type condition", name)
+			}
+			p.next()
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			fields, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			p.fragments[name] = fields
+		case t.text == "query" || t.text == "mutation" || t.text == "subscription":
+			p.next()
+			if p.peek().punct == 0 {
+				p.next()
+			}
+			if p.peek().punct == '(' {
+				if err := p.variableDefinitions(); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			fields, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			if op == nil {
+				op = &costOperation{mutation: t.text == "mutation", fields: fields}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected token %q", t.text)
+		}
+	}
+	if op == nil {
+		return nil, errors.New("query has no operation")
+	}
+	return op, nil
+}
+
+// variableDefinitions records the default values of the variables.
+func (p *costParser) variableDefinitions() error {
+	p.next()
+	for p.peek().punct != ')' {
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name := p.next().text
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		for t := p.peek(); t.punct != '=' && t.punct != '$' && t.punct != ')' && t.punct != '@'; t = p.peek() {
+			if p.pos >= len(p.tokens) {
+				return errors.New("unterminated variable definitions")
+			}
+			p.next()
+		}
+		if p.peek().punct == '=' {
+			p.next()
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			p.defaults[name] = value
+		}
+		if err := p.skipDirectives(); err != nil {
+			return err
+		}
+	}
+	p.next()
+	return nil
+}
+
+func (p *costParser) selectionSet() ([]costField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []costField
+	for p.peek().punct != '}' {
+		if p.pos >= len(p.tokens) {
+			return nil, errors.New("unterminated selection set")
+		}
+		if p.peek().punct == '.' {
+			p.next()
+			if p.peek().text == "on" || p.peek().punct == '{' || p.peek().punct == '@' {
+				if p.peek().text == "on" {
+					p.next()
+					p.next()
+				}
+				if err := p.skipDirectives(); err != nil {
+					return nil, err
+				}
+				inline, err := p.selectionSet()
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, inline...)
+				continue
+			}
+			fields = append(fields, costField{spread: p.next().text})
+			if err := p.skipDirectives(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	p.next()
+	return fields, nil
+}
+
+func (p *costParser) field() (costField, error) {
+	t := p.next()
+	if t.punct != 0 {
+		return costField{}, fmt.Errorf("unexpected token %q", t.text)
+	}
+	f := costField{name: t.text, args: map[string]string{}}
+	if p.peek().punct == ':' {
+		p.next()
+		f.name = p.next().text
+	}
+	if p.peek().punct == '(' {
+		p.next()
+		for p.peek().punct != ')' {
+			name := p.next().text
+			if err := p.expect(':'); err != nil {
+				return costField{}, err
+			}
+			value, err := p.value()
+			if err != nil {
+				return costField{}, err
+			}
+			f.args[name] = value
+		}
+		p.next()
+	}
+	if err := p.skipDirectives(); err != nil {
+		return costField{}, err
+	}
+	if p.peek().punct == '{' {
+		children, err := p.selectionSet()
+		if err != nil {
+			return costField{}, err
+		}
+		f.children = children
+		if f.children == nil {
+			f.children = []costField{}
+		}
+	}
+	return f, nil
+}
+
+// value parses an argument value. Lists and objects are skipped, variables
+// are returned with their $ prefix.
+func (p *costParser) value() (string, error) {
+	t := p.next()
+	switch t.punct {
+	case 0:
+		return t.text, nil
+	case '$':
+		return "$" + p.next().text, nil
+	case '[', '{':
+		closing := map[byte]byte{'[': ']', '{': '}'}[t.punct]
+		depth := 1
+		for depth > 0 {
+			if p.pos >= len(p.tokens) {
+				return "", errors.New("unterminated argument value")
+			}
+			switch p.next().punct {
+			case t.punct:
+				depth++
+			case closing:
+				depth--
+			}
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected token %q", t.text)
+}
+
+func (p *costParser) skipDirectives() error {
+	for p.peek().punct == '@' {
+		p.next()
+		p.next()
+		if p.peek().punct == '(' {
+			depth := 0
+			for {
+				if p.pos >= len(p.tokens) {
+					return errors.New("unterminated directive")
+				}
+				switch p.next().punct {
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *costParser) selectionCost(fields []costField, depth int) (int, error) {
+	if depth > 32 {
+		return 0, errors.New("selection nested too deeply, recursive fragment?")
+	}
+	cost := 0
+	for _, f := range fields {
+		if f.spread != "" {
+			fragment, ok := p.fragments[f.spread]
+			if !ok {
+				return 0, fmt.Errorf("unknown fragment %s", f.spread)
+			}
+			c, err := p.selectionCost(fragment, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			cost += c
+			continue
+		}
+		c, err := p.fieldCost(f, depth)
+		if err != nil {
+			return 0, err
+		}
+		cost += c
+	}
+	return cost, nil
+}
+
+func (p *costParser) fieldCost(f costField, depth int) (int, error) {
+	if f.children == nil {
+		return 0, nil
+	}
+	size, ok := f.args["first"]
+	if !ok {
+		size, ok = f.args["last"]
+	}
+	if !ok {
+		c, err := p.selectionCost(f.children, depth+1)
+		return costObject + c, err
+	}
+	n, err := p.pageSize(size)
+	if err != nil {
+		return 0, err
+	}
+	node := 0
+	for _, child := range p.expand(f.children) {
+		switch child.name {
+		case "nodes":
+			c, err := p.selectionCost(child.children, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			node = max(node, costObject+c)
+		case "edges":
+			for _, edge := range p.expand(child.children) {
+				if edge.name != "node" {
+					continue
+				}
+				c, err := p.selectionCost(edge.children, depth+1)
+				if err != nil {
+					return 0, err
+				}
+				node = max(node, costObject+c)
+			}
+		}
+	}
+	return costConnBase + n*node, nil
+}
+
+// expand inlines fragment spreads of the connection's wrapper fields.
+func (p *costParser) expand(fields []costField) []costField {
+	var expanded []costField
+	for _, f := range fields {
+		if f.spread != "" {
+			expanded = append(expanded, p.fragments[f.spread]...)
+			continue
+		}
+		expanded = append(expanded, f)
+	}
+	return expanded
+}
+
+func (p *costParser) pageSize(value string) (int, error) {
+	if len(value) > 0 && value[0] == '$' {
+		def, ok := p.defaults[value[1:]]
+		if !ok {
+			return maxPageSize, nil
+		}
+		value = def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid page size %q", value)
+	}
+	return n, nil
+}
@@ -6,6 +6,9 @@ import (
 )
 
 func SleepContext(ctx context.Context, t time.Duration) {
+	if t <= 0 {
+		return
+	}
 	ticker := time.NewTicker(t)
 	defer ticker.Stop()
 	select {
@@ -0,0 +1,66 @@
+package shopigo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DedupStore records webhook IDs. SeenBefore reports whether id was recorded
+// within the last ttl and records it otherwise.
+type DedupStore interface {
+	SeenBefore(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+// DedupForgetter is implemented by dedup stores which can forget an ID, so
+// webhooks whose handler returned a RetryLaterError aren't skipped when
+// they're delivered again.
+type DedupForgetter interface {
+	Forget(ctx context.Context, id string) error
+}
+
+// DedupChecker is implemented by dedup stores which can check an ID without
+// recording it, used by VerifyWebhookStreaming to skip duplicates before the
+// webhook is verified.
+type DedupChecker interface {
+	Seen(ctx context.Context, id string) (bool, error)
+}
+
+type InMemDedupStore struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewInMemDedupStore() *InMemDedupStore {
+	return &InMemDedupStore{seen: map[string]time.Time{}}
+}
+
+func (s *InMemDedupStore) SeenBefore(_ context.Context, id string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, exp := range s.seen {
+		if !now.Before(exp) {
+			delete(s.seen, k)
+		}
+	}
+	if _, ok := s.seen[id]; ok {
+		return true, nil
+	}
+	s.seen[id] = now.Add(ttl)
+	return false, nil
+}
+
+func (s *InMemDedupStore) Seen(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.seen[id]
+	return ok && time.Now().Before(exp), nil
+}
+
+func (s *InMemDedupStore) Forget(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, id)
+	return nil
+}
@@ -0,0 +1,127 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+)
+
+// DeliveryMethod is how Shopify delivered a webhook. Webhooks delivered by
+// EventBridge or Pub/Sub aren't signed, they are authenticated by the IAM
+// permissions of the queue.
+type DeliveryMethod string
+
+const (
+	DeliveryHTTP        DeliveryMethod = "http"
+	DeliveryEventBridge DeliveryMethod = "eventbridge"
+	DeliveryPubSub      DeliveryMethod = "pubsub"
+)
+
+// PubSubWebhook decodes a webhook delivered by Google Pub/Sub. message is
+// either the push request body, {"message": {...}, "subscription": "..."}, or
+// the message itself as passed to Cloud Functions.
+func PubSubWebhook(message []byte) (*WebhookContext, error) {
+	var msg struct {
+		Data       string            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		Message    *struct {
+			Data       string            `json:"data"`
+			Attributes map[string]string `json:"attributes"`
+		} `json:"message"`
+	}
+	if err := jsonUnmarshal(message, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode pubsub message: %w", err)
+	}
+	if msg.Message != nil {
+		msg.Data, msg.Attributes = msg.Message.Data, msg.Message.Attributes
+	}
+	body, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pubsub message data: %w", err)
+	}
+	return queuedWebhook(DeliveryPubSub, msg.Attributes, body)
+}
+
+// EventBridgeWebhook decodes a webhook delivered by Amazon EventBridge, i.e.
+// an event with the payload and the webhook headers in its detail.
+func EventBridgeWebhook(event []byte) (*WebhookContext, error) {
+	var ev struct {
+		Detail struct {
+			Payload  json.RawMessage   `json:"payload"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"detail"`
+	}
+	if err := jsonUnmarshal(event, &ev); err != nil {
+		return nil, fmt.Errorf("failed to decode eventbridge event: %w", err)
+	}
+	return queuedWebhook(DeliveryEventBridge, ev.Detail.Metadata, ev.Detail.Payload)
+}
+
+func queuedWebhook(method DeliveryMethod, headers map[string]string, body []byte) (*WebhookContext, error) {
+	header := http.Header{}
+	for k, v := range headers {
+		header.Set(k, v)
+	}
+	wh := &WebhookContext{
+		Shop:           header.Get(XDomainHeader),
+		Topic:          header.Get(XTopicHeader),
+		WebhookID:      header.Get(XWebhookIDHeader),
+		DeliveryMethod: method,
+		APIVersion:     Version(header.Get(XAPIVersionHeader)),
+		Body:           body,
+		// queued payloads are always JSON
+		ContentType: "application/json",
+	}
+	if wh.Topic == "" || wh.Shop == "" {
+		return nil, errors.New("webhook has no topic or shop")
+	}
+	return wh, nil
+}
+
+// HandleDelivery returns a handler for webhooks delivered by method. For
+// EventBridge and Pub/Sub the request body is the event or push message, the
+// endpoint must be protected by the queue's authentication, e.g. Pub/Sub push
+// authentication, as there is no HMAC to verify.
+func (r *WebhookRouter) HandleDelivery(method DeliveryMethod) gin.HandlerFunc {
+	if method == DeliveryHTTP {
+		return r.Handle
+	}
+	return func(c *gin.Context) {
+		bs, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var wh *WebhookContext
+		if method == DeliveryPubSub {
+			wh, err = PubSubWebhook(bs)
+		} else {
+			wh, err = EventBridgeWebhook(bs)
+		}
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		r.respond(c, wh, r.DispatchQueued(c.Request.Context(), wh))
+	}
+}
+
+// DispatchQueued dispatches a webhook decoded by PubSubWebhook or
+// EventBridgeWebhook, skipping duplicates if WithWebhookDedup is configured.
+// Queues deliver at least once, so duplicates are more common than over HTTP.
+func (r *WebhookRouter) DispatchQueued(ctx context.Context, wh *WebhookContext) error {
+	seen, err := r.app.seenWebhook(ctx, wh.WebhookID)
+	if err != nil {
+		return err
+	}
+	if seen {
+		r.app.Client.logger.Info("skipping duplicate webhook", "webhook", wh.WebhookID)
+		return nil
+	}
+	return r.Dispatch(ctx, wh)
+}
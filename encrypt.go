@@ -0,0 +1,149 @@
+package shopigo
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EncryptedSessionStore wraps a SessionStore and encrypts the access token and
+// associated user scope with AES-GCM before they are handed to the store.
+// Sessions are decrypted with the primary key first, then with the fallback
+// keys, which allows rotating keys without invalidating stored sessions.
+type EncryptedSessionStore struct {
+	store     SessionStore
+	primary   cipher.AEAD
+	fallbacks []cipher.AEAD
+}
+
+func NewEncryptedSessionStore(store SessionStore, key []byte, fallbackKeys ...[]byte) (*EncryptedSessionStore, error) {
+	primary, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	e := &EncryptedSessionStore{store: store, primary: primary}
+	for i, k := range fallbackKeys {
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback encryption key %d: %w", i, err)
+		}
+		e.fallbacks = append(e.fallbacks, aead)
+	}
+	return e, nil
+}
+
+func (e *EncryptedSessionStore) Get(ctx context.Context, id SessionID) (*Session, error) {
+	sess, err := e.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sess = copySession(sess)
+	if sess.AccessToken, err = e.decrypt(sess.AccessToken); err != nil {
+		return nil, fmt.Errorf("failed to decrypt access token: %w", err)
+	}
+	if sess.OnlineAccessInfo != nil {
+		if sess.OnlineAccessInfo.UserScope, err = e.decrypt(sess.OnlineAccessInfo.UserScope); err != nil {
+			return nil, fmt.Errorf("failed to decrypt user scope: %w", err)
+		}
+	}
+	return sess, nil
+}
+
+func (e *EncryptedSessionStore) Store(ctx context.Context, session *Session) error {
+	sess := copySession(session)
+	var err error
+	if sess.AccessToken, err = e.encrypt(sess.AccessToken); err != nil {
+		return fmt.Errorf("failed to encrypt access token: %w", err)
+	}
+	if sess.OnlineAccessInfo != nil {
+		if sess.OnlineAccessInfo.UserScope, err = e.encrypt(sess.OnlineAccessInfo.UserScope); err != nil {
+			return fmt.Errorf("failed to encrypt user scope: %w", err)
+		}
+	}
+	return e.store.Store(ctx, sess)
+}
+
+func (e *EncryptedSessionStore) Delete(ctx context.Context, id SessionID) error {
+	return e.store.Delete(ctx, id)
+}
+
+func (e *EncryptedSessionStore) DeleteShop(ctx context.Context, shop string) error {
+	return DeleteShopSessions(ctx, e.store, shop)
+}
+
+func (e *EncryptedSessionStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return DeleteExpiredSessions(ctx, e.store, now)
+}
+
+// RecordInstall records the install in the wrapped store. Without support
+// every install counts as first, leaving the decision to the sessions.
+func (e *EncryptedSessionStore) RecordInstall(ctx context.Context, shop string) (bool, error) {
+	if r, ok := e.store.(InstallRecorder); ok {
+		return r.RecordInstall(ctx, shop)
+	}
+	return true, nil
+}
+
+func (e *EncryptedSessionStore) Close(ctx context.Context) error {
+	if c, ok := e.store.(Closer); ok {
+		return c.Close(ctx)
+	}
+	return nil
+}
+
+func (e *EncryptedSessionStore) Ping(ctx context.Context) error {
+	return PingSessionStore(ctx, e.store)
+}
+
+func (e *EncryptedSessionStore) encrypt(plain string) (string, error) {
+	nonce := make([]byte, e.primary.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(e.primary.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+func (e *EncryptedSessionStore) decrypt(encrypted string) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	for _, aead := range append([]cipher.AEAD{e.primary}, e.fallbacks...) {
+		if len(bs) < aead.NonceSize() {
+			continue
+		}
+		plain, err := aead.Open(nil, bs[:aead.NonceSize()], bs[aead.NonceSize():], nil)
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errors.New("no key matches")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func copySession(s *Session) *Session {
+	sess := *s
+	if s.OnlineAccessInfo != nil {
+		info := *s.OnlineAccessInfo
+		sess.OnlineAccessInfo = &info
+	}
+	if s.Metadata != nil {
+		sess.Metadata = make(map[string]string, len(s.Metadata))
+		for k, v := range s.Metadata {
+			sess.Metadata[k] = v
+		}
+	}
+	return &sess
+}
@@ -0,0 +1,73 @@
+package shopigo
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type EncryptTestSuite struct {
+	suite.Suite
+}
+
+func TestEncryptTestSuite(t *testing.T) {
+	suite.Run(t, new(EncryptTestSuite))
+}
+
+func (s *EncryptTestSuite) session() *Session {
+	return &Session{
+		ID:               GetOnlineSessionID("test.myshopify.com", "42"),
+		Shop:             "test.myshopify.com",
+		AccessToken:      "shpat_secret",
+		OnlineAccessInfo: &OnlineAccessInfo{UserScope: "read_products"},
+	}
+}
+
+func (s *EncryptTestSuite) TestStoresCiphertext() {
+	ctx := context.Background()
+	inner := &inMemSessionStore{}
+	store, err := NewEncryptedSessionStore(inner, bytes.Repeat([]byte("k"), 32))
+	s.Require().NoError(err)
+
+	sess := s.session()
+	s.Require().NoError(store.Store(ctx, sess))
+	s.Equal("shpat_secret", sess.AccessToken, "stored session must not be modified")
+
+	raw, err := inner.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.NotEqual("shpat_secret", raw.AccessToken)
+	s.NotContains(raw.AccessToken, "shpat_secret")
+	s.NotEqual("read_products", raw.OnlineAccessInfo.UserScope)
+
+	got, err := store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.Equal(sess, got)
+}
+
+func (s *EncryptTestSuite) TestKeyRotation() {
+	ctx := context.Background()
+	inner := &inMemSessionStore{}
+	oldKey, newKey := bytes.Repeat([]byte("o"), 32), bytes.Repeat([]byte("n"), 32)
+	old, err := NewEncryptedSessionStore(inner, oldKey)
+	s.Require().NoError(err)
+	s.Require().NoError(old.Store(ctx, s.session()))
+
+	rotated, err := NewEncryptedSessionStore(inner, newKey, oldKey)
+	s.Require().NoError(err)
+	got, err := rotated.Get(ctx, s.session().SessionID())
+	s.Require().NoError(err)
+	s.Equal("shpat_secret", got.AccessToken)
+
+	withoutOld, err := NewEncryptedSessionStore(inner, newKey)
+	s.Require().NoError(err)
+	_, err = withoutOld.Get(ctx, s.session().SessionID())
+	s.Error(err)
+}
+
+func (s *EncryptTestSuite) TestInvalidKey() {
+	_, err := NewEncryptedSessionStore(&inMemSessionStore{}, []byte("short"))
+	s.Error(err)
+	_, err = NewApp(testAppConfig(), WithSessionEncryptionKey([]byte("short")))
+	s.Error(err)
+}
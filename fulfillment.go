@@ -0,0 +1,212 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrAlreadyFulfilled is returned if the line items of a fulfillment were
+	// fulfilled before, so the fulfillment doesn't need to be retried.
+	ErrAlreadyFulfilled = errors.New("line items already fulfilled")
+	// ErrFulfillmentOrderClosed is returned if a fulfillment order can't be
+	// fulfilled anymore, e.g. because it's closed or cancelled.
+	ErrFulfillmentOrderClosed = errors.New("fulfillment order not fulfillable")
+)
+
+type FulfillmentOrder struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	RequestStatus    string `json:"requestStatus"`
+	AssignedLocation struct {
+		Name     string    `json:"name"`
+		Location *Location `json:"location"`
+	} `json:"assignedLocation"`
+	LineItems struct {
+		Nodes []FulfillmentOrderLineItem `json:"nodes"`
+	} `json:"lineItems"`
+}
+
+type FulfillmentOrderLineItem struct {
+	ID                string `json:"id"`
+	TotalQuantity     int    `json:"totalQuantity"`
+	RemainingQuantity int    `json:"remainingQuantity"`
+	LineItem          struct {
+		ID  string `json:"id"`
+		SKU string `json:"sku"`
+	} `json:"lineItem"`
+}
+
+type TrackingInfo struct {
+	Number  string `json:"number,omitempty"`
+	Company string `json:"company,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// FulfillmentInput creates a fulfillment for the line items of one or more
+// fulfillment orders. Fulfillment orders without line items are fulfilled
+// completely.
+type FulfillmentInput struct {
+	FulfillmentOrders []FulfillmentOrderItems
+	TrackingNumber    string
+	TrackingCompany   string
+	TrackingURL       string
+	NotifyCustomer    bool
+}
+
+type FulfillmentOrderItems struct {
+	FulfillmentOrderID string
+	LineItems          []FulfillmentLineItem
+}
+
+type FulfillmentLineItem struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+}
+
+type FulfillmentResult struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"`
+	TrackingInfo []TrackingInfo `json:"trackingInfo"`
+}
+
+// FulfillmentOrders returns the fulfillment orders of the order.
+func (c *Client) FulfillmentOrders(ctx context.Context, sess *Session, orderGID string) ([]FulfillmentOrder, error) {
+	var orders []FulfillmentOrder
+	vars := map[string]any{"id": orderGID}
+	for {
+		var out struct {
+			Order *struct {
+				FulfillmentOrders struct {
+					Nodes    []FulfillmentOrder `json:"nodes"`
+					PageInfo PageInfo           `json:"pageInfo"`
+				} `json:"fulfillmentOrders"`
+			} `json:"order"`
+		}
+		err := c.GraphQL(ctx, sess, `query fulfillmentOrders($id: ID!, $after: String) {
+			order(id: $id) {
+				fulfillmentOrders(first: 50, after: $after) {
+					nodes {
+						id status requestStatus
+						assignedLocation { name location { id name isActive } }
+						lineItems(first: 250) {
+							nodes { id totalQuantity remainingQuantity lineItem { id sku } }
+						}
+					}
+					pageInfo { hasNextPage endCursor }
+				}
+			}
+		}`, vars, &out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list fulfillment orders: %w", err)
+		}
+		if out.Order == nil {
+			return nil, fmt.Errorf("order %s not found", orderGID)
+		}
+		orders = append(orders, out.Order.FulfillmentOrders.Nodes...)
+		if !out.Order.FulfillmentOrders.PageInfo.HasNextPage {
+			return orders, nil
+		}
+		vars["after"] = out.Order.FulfillmentOrders.PageInfo.EndCursor
+	}
+}
+
+// AcceptFulfillmentRequest accepts the fulfillment request sent to the app
+// as fulfillment service.
+func (c *Client) AcceptFulfillmentRequest(ctx context.Context, sess *Session, fulfillmentOrderGID string, message string) error {
+	err := c.GraphQL(ctx, sess, `mutation fulfillmentOrderAcceptFulfillmentRequest($id: ID!, $message: String) {
+		fulfillmentOrderAcceptFulfillmentRequest(id: $id, message: $message) {
+			userErrors { field message }
+		}
+	}`, map[string]any{"id": fulfillmentOrderGID, "message": message}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to accept fulfillment request: %w", fulfillmentError(err))
+	}
+	return nil
+}
+
+// RejectFulfillmentRequest rejects the fulfillment request sent to the app
+// as fulfillment service.
+func (c *Client) RejectFulfillmentRequest(ctx context.Context, sess *Session, fulfillmentOrderGID string, message string) error {
+	err := c.GraphQL(ctx, sess, `mutation fulfillmentOrderRejectFulfillmentRequest($id: ID!, $message: String) {
+		fulfillmentOrderRejectFulfillmentRequest(id: $id, message: $message) {
+			userErrors { field message }
+		}
+	}`, map[string]any{"id": fulfillmentOrderGID, "message": message}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reject fulfillment request: %w", fulfillmentError(err))
+	}
+	return nil
+}
+
+// CreateFulfillment fulfills the line items, optionally with tracking info.
+func (c *Client) CreateFulfillment(ctx context.Context, sess *Session, in FulfillmentInput) (*FulfillmentResult, error) {
+	lineItems := make([]map[string]any, len(in.FulfillmentOrders))
+	for i, fo := range in.FulfillmentOrders {
+		lineItems[i] = map[string]any{"fulfillmentOrderId": fo.FulfillmentOrderID}
+		if len(fo.LineItems) > 0 {
+			lineItems[i]["fulfillmentOrderLineItems"] = fo.LineItems
+		}
+	}
+	fulfillment := map[string]any{
+		"lineItemsByFulfillmentOrder": lineItems,
+		"notifyCustomer":              in.NotifyCustomer,
+	}
+	if tracking := (TrackingInfo{Number: in.TrackingNumber, Company: in.TrackingCompany, URL: in.TrackingURL}); tracking != (TrackingInfo{}) {
+		fulfillment["trackingInfo"] = tracking
+	}
+	var out struct {
+		FulfillmentCreateV2 struct {
+			Fulfillment *FulfillmentResult `json:"fulfillment"`
+		} `json:"fulfillmentCreateV2"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation fulfillmentCreateV2($fulfillment: FulfillmentV2Input!) {
+		fulfillmentCreateV2(fulfillment: $fulfillment) {
+			fulfillment { id status trackingInfo { number company url } }
+			userErrors { field message }
+		}
+	}`, map[string]any{"fulfillment": fulfillment}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fulfillment: %w", fulfillmentError(err))
+	}
+	return out.FulfillmentCreateV2.Fulfillment, nil
+}
+
+// UpdateFulfillmentTracking replaces the tracking info of the fulfillment.
+func (c *Client) UpdateFulfillmentTracking(ctx context.Context, sess *Session, fulfillmentGID string, tracking TrackingInfo, notifyCustomer bool) error {
+	err := c.GraphQL(ctx, sess, `mutation fulfillmentTrackingInfoUpdateV2($fulfillmentId: ID!, $trackingInfoInput: FulfillmentTrackingInput!, $notifyCustomer: Boolean) {
+		fulfillmentTrackingInfoUpdateV2(fulfillmentId: $fulfillmentId, trackingInfoInput: $trackingInfoInput, notifyCustomer: $notifyCustomer) {
+			userErrors { field message }
+		}
+	}`, map[string]any{
+		"fulfillmentId":     fulfillmentGID,
+		"trackingInfoInput": tracking,
+		"notifyCustomer":    notifyCustomer,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update fulfillment tracking: %w", fulfillmentError(err))
+	}
+	return nil
+}
+
+// fulfillmentError wraps user errors in the named fulfillment errors. The
+// fulfillment mutations don't return error codes, so the messages are
+// matched.
+func fulfillmentError(err error) error {
+	var userErrs UserErrors
+	if !errors.As(err, &userErrs) {
+		return err
+	}
+	for _, e := range userErrs {
+		msg := strings.ToLower(e.Message)
+		switch {
+		case strings.Contains(msg, "already fulfilled") || strings.Contains(msg, "already been fulfilled"):
+			return fmt.Errorf("%w: %w", ErrAlreadyFulfilled, err)
+		case strings.Contains(msg, "unfulfillable") || strings.Contains(msg, "closed") || strings.Contains(msg, "cancelled"):
+			return fmt.Errorf("%w: %w", ErrFulfillmentOrderClosed, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package shopigo
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiredSessionDeleter is implemented by session stores which can delete
+// the sessions expired before now. Stores expiring sessions natively, such as
+// the RedisSessionStore, don't need to implement it.
+type ExpiredSessionDeleter interface {
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// DeleteExpiredSessions deletes the expired sessions if the store supports it
+// and returns their number.
+func DeleteExpiredSessions(ctx context.Context, store SessionStore, now time.Time) (int, error) {
+	if d, ok := store.(ExpiredSessionDeleter); ok {
+		return d.DeleteExpired(ctx, now)
+	}
+	return 0, nil
+}
+
+// defaultSessionGCInterval is used by StartSessionGC if no positive interval
+// is given.
+const defaultSessionGCInterval = time.Hour
+
+// StartSessionGC deletes the expired online sessions of the app's store every
+// interval until ctx is done. An interval <= 0 selects an hourly run.
+func (a *App) StartSessionGC(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSessionGCInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				n, err := DeleteExpiredSessions(ctx, a.SessionStore, now)
+				if err != nil {
+					a.Client.logger.Warn("failed to delete expired sessions", "error", err)
+				} else if n > 0 {
+					a.Client.logger.Debug("deleted expired sessions", "count", n)
+				}
+			}
+		}
+	}()
+}
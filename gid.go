@@ -0,0 +1,100 @@
+package shopigo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const gidPrefix = "gid://shopify/"
+
+// Resource types of global IDs of common resources.
+const (
+	ResourceCollection       = "Collection"
+	ResourceCustomer         = "Customer"
+	ResourceFulfillment      = "Fulfillment"
+	ResourceFulfillmentOrder = "FulfillmentOrder"
+	ResourceInventoryItem    = "InventoryItem"
+	ResourceLineItem         = "LineItem"
+	ResourceLocation         = "Location"
+	ResourceOrder            = "Order"
+	ResourceProduct          = "Product"
+	ResourceProductVariant   = "ProductVariant"
+)
+
+// GID returns the GraphQL global ID of the resource with the REST ID, e.g.
+// gid://shopify/Product/123.
+func GID(resource string, id int64) string {
+	return gidPrefix + resource + "/" + strconv.FormatInt(id, 10)
+}
+
+// ParseGID returns the resource type and REST ID of a global ID. Parameters
+// such as in gid://shopify/InventoryLevel/1?inventory_item_id=2 are ignored.
+func ParseGID(gid string) (string, int64, error) {
+	rest, ok := strings.CutPrefix(gid, gidPrefix)
+	if !ok {
+		return "", 0, fmt.Errorf("invalid gid %q: missing %s prefix", gid, gidPrefix)
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	resource, idStr, ok := strings.Cut(rest, "/")
+	if !ok || !validGIDResource(resource) {
+		return "", 0, fmt.Errorf("invalid gid %q: invalid resource type", gid)
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 || idStr[0] == '+' {
+		return "", 0, fmt.Errorf("invalid gid %q: invalid id", gid)
+	}
+	return resource, id, nil
+}
+
+// LegacyID returns the REST ID of a global ID of the resource type.
+func LegacyID(resource string, gid string) (int64, error) {
+	got, id, err := ParseGID(gid)
+	if err != nil {
+		return 0, err
+	}
+	if got != resource {
+		return 0, fmt.Errorf("gid %q is not a %s", gid, resource)
+	}
+	return id, nil
+}
+
+func validGIDResource(resource string) bool {
+	if resource == "" || resource[0] < 'A' || resource[0] > 'Z' {
+		return false
+	}
+	for i := 0; i < len(resource); i++ {
+		if ch := resource[i]; !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func ProductGID(id int64) string {
+	return GID(ResourceProduct, id)
+}
+
+func ProductVariantGID(id int64) string {
+	return GID(ResourceProductVariant, id)
+}
+
+func OrderGID(id int64) string {
+	return GID(ResourceOrder, id)
+}
+
+func CustomerGID(id int64) string {
+	return GID(ResourceCustomer, id)
+}
+
+func CollectionGID(id int64) string {
+	return GID(ResourceCollection, id)
+}
+
+func LocationGID(id int64) string {
+	return GID(ResourceLocation, id)
+}
+
+func InventoryItemGID(id int64) string {
+	return GID(ResourceInventoryItem, id)
+}
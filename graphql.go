@@ -0,0 +1,206 @@
+package shopigo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []any                  `json:"path,omitempty"`
+	Extensions GraphQLErrorExtensions `json:"extensions"`
+}
+
+type GraphQLErrorExtensions struct {
+	Code string `json:"code"`
+}
+
+func (e GraphQLError) Error() string {
+	if e.Extensions.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Extensions.Code)
+}
+
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) throttled() bool {
+	for i := range e {
+		if e[i].Extensions.Code == "THROTTLED" {
+			return true
+		}
+	}
+	return false
+}
+
+func (e GraphQLErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+type UserError struct {
+	Field   []string `json:"field"`
+	Message string   `json:"message"`
+	Code    string   `json:"code,omitempty"`
+}
+
+func (e UserError) Error() string {
+	if len(e.Field) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(e.Field, "."), e.Message)
+}
+
+type UserErrors []UserError
+
+func (e UserErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return "user errors: " + strings.Join(msgs, "; ")
+}
+
+// ForField returns the errors of the input field at path or nested in it,
+// e.g. ForField("input", "variants") for input.variants.0.price.
+func (e UserErrors) ForField(path ...string) UserErrors {
+	var errs UserErrors
+	for _, userErr := range e {
+		if len(userErr.Field) >= len(path) && slices.Equal(userErr.Field[:len(path)], path) {
+			errs = append(errs, userErr)
+		}
+	}
+	return errs
+}
+
+// Codes returns the distinct codes of the errors in order, ignoring errors
+// without code.
+func (e UserErrors) Codes() []string {
+	var codes []string
+	for _, userErr := range e {
+		if userErr.Code != "" && !slices.Contains(codes, userErr.Code) {
+			codes = append(codes, userErr.Code)
+		}
+	}
+	return codes
+}
+
+type graphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data       json.RawMessage `json:"data"`
+	Errors     GraphQLErrors   `json:"errors"`
+	Extensions struct {
+		Cost *GraphQLCost `json:"cost"`
+	} `json:"extensions"`
+}
+
+// GraphQL runs the query against the Admin API and decodes the response data
+// into out. Top level errors are returned as GraphQLErrors, mutation
+// userErrors as UserErrors. In the latter case out is populated nonetheless.
+func (c *Client) GraphQL(ctx context.Context, sess *Session, query string, vars map[string]any, out any) error {
+	_, err := c.GraphQLWithCost(ctx, sess, query, vars, out)
+	return err
+}
+
+// GraphQLWithCost is GraphQL returning the query cost. Queries rejected as
+// THROTTLED are retried once the bucket restored enough points to afford
+// them, up to the configured number of retries.
+func (c *Client) GraphQLWithCost(ctx context.Context, sess *Session, query string, vars map[string]any, out any) (*GraphQLCost, error) {
+	body, err := jsonMarshal(graphQLRequest{Query: query, Variables: vars})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request object: %w", err)
+	}
+	gqlResp, err := c.graphQLThrottled(ctx, sess, body)
+	if err != nil {
+		return nil, err
+	}
+	cost := gqlResp.Extensions.Cost
+	if len(gqlResp.Errors) > 0 {
+		return cost, gqlResp.Errors
+	}
+	if out != nil && len(gqlResp.Data) > 0 {
+		if err = jsonUnmarshal(gqlResp.Data, out); err != nil {
+			return cost, fmt.Errorf("failed to decode response data: %w", err)
+		}
+	}
+	if userErrs := findUserErrors(gqlResp.Data); len(userErrs) > 0 {
+		return cost, userErrs
+	}
+	return cost, nil
+}
+
+// graphQLThrottled sends the request, retrying it while it's rejected as
+// THROTTLED. The response of the last attempt is returned with its errors.
+func (c *Client) graphQLThrottled(ctx context.Context, sess *Session, body []byte) (*graphQLResponse, error) {
+	for attempt := 0; ; attempt++ {
+		gqlResp, err := c.graphQL(ctx, sess, body)
+		if err != nil {
+			return nil, err
+		}
+		cost := gqlResp.Extensions.Cost
+		if gqlResp.Errors.throttled() && cost != nil && attempt < c.retries && fitsDeadline(ctx, cost.restoreTime()) {
+			c.sleep(ctx, cost.restoreTime())
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		return gqlResp, nil
+	}
+}
+
+func (c *Client) graphQL(ctx context.Context, sess *Session, body []byte) (*graphQLResponse, error) {
+	sess = c.sessionOr(sess)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ShopURL(sess.Shop, "graphql.json"), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.authenticate(req, sess)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, newShopifyAPIError(resp)
+	}
+	var gqlResp graphQLResponse
+	if err = decodeJSON(resp.Body, &gqlResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &gqlResp, nil
+}
+
+// findUserErrors collects the userErrors of all mutations in the response
+// data, e.g. {"productCreate": {"userErrors": [...]}}, including the
+// specific user errors of some mutations such as mediaUserErrors.
+func findUserErrors(data json.RawMessage) UserErrors {
+	var fields map[string]json.RawMessage
+	if err := jsonUnmarshal(data, &fields); err != nil {
+		return nil
+	}
+	var errs UserErrors
+	for key, raw := range fields {
+		if key == "userErrors" || strings.HasSuffix(key, "UserErrors") {
+			var userErrs UserErrors
+			if err := jsonUnmarshal(raw, &userErrs); err == nil {
+				errs = append(errs, userErrs...)
+			}
+			continue
+		}
+		errs = append(errs, findUserErrors(raw)...)
+	}
+	return errs
+}
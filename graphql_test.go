@@ -0,0 +1,570 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type GraphQLTestSuite struct {
+	suite.Suite
+	server  *httptest.Server
+	client  *Client
+	sess    *Session
+	handler http.HandlerFunc
+}
+
+func TestGraphQLTestSuite(t *testing.T) {
+	suite.Run(t, new(GraphQLTestSuite))
+}
+
+func (s *GraphQLTestSuite) SetupTest() {
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handler(w, r)
+	}))
+	s.client = NewShopifyClient(&ClientConfig{v: VLatest})
+	s.client.http = s.server.Client()
+	s.sess = &Session{Shop: strings.TrimPrefix(s.server.URL, "https://"), AccessToken: "token"}
+}
+
+func (s *GraphQLTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *GraphQLTestSuite) respond(body string) {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("/admin/api/"+VLatest.String()+"/graphql.json", r.URL.Path)
+		s.Equal("token", r.Header.Get(XAccessToken))
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		s.NotEmpty(req.Query)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func (s *GraphQLTestSuite) TestQuery() {
+	s.respond(`{"data":{"shop":{"name":"Test"}},"extensions":{"cost":{"requestedQueryCost":1,"actualQueryCost":1,
+		"throttleStatus":{"maximumAvailable":1000,"currentlyAvailable":999,"restoreRate":50}}}}`)
+	var out struct {
+		Shop struct {
+			Name string `json:"name"`
+		} `json:"shop"`
+	}
+	cost, err := s.client.GraphQLWithCost(context.Background(), s.sess, "{ shop { name } }", nil, &out)
+	s.Require().NoError(err)
+	s.Equal("Test", out.Shop.Name)
+	s.Equal(float64(999), cost.ThrottleStatus.CurrentlyAvailable)
+}
+
+func (s *GraphQLTestSuite) TestUserErrors() {
+	s.respond(`{"data":{"productCreate":{"product":null,"userErrors":[
+		{"field":["input","title"],"message":"Title can't be blank","code":"BLANK"}]}}}`)
+	err := s.client.GraphQL(context.Background(), s.sess, "mutation { productCreate(input: {}) { product { id } } }", nil, nil)
+	var userErrs UserErrors
+	s.Require().ErrorAs(err, &userErrs)
+	s.Equal(UserErrors{{Field: []string{"input", "title"}, Message: "Title can't be blank", Code: "BLANK"}}, userErrs)
+}
+
+func (s *GraphQLTestSuite) TestUserErrorFields() {
+	s.respond(`{"data":{"productCreateMedia":{"media":[],"mediaUserErrors":[
+		{"field":["media","0","originalSource"],"message":"URL is invalid","code":"INVALID"}]},
+		"productUpdate":{"userErrors":[{"field":["input","variants","0","price"],"message":"Price is invalid","code":"INVALID"},
+		{"field":["input","title"],"message":"Title is too long","code":"TOO_LONG"},{"field":null,"message":"Failed"}]}}}`)
+	err := s.client.GraphQL(context.Background(), s.sess, "mutation { productCreateMedia { media { id } } }", nil, nil)
+	var userErrs UserErrors
+	s.Require().ErrorAs(err, &userErrs)
+	s.Len(userErrs, 4, "mediaUserErrors must be found as well")
+	s.Equal(UserErrors{{Field: []string{"media", "0", "originalSource"}, Message: "URL is invalid", Code: "INVALID"}},
+		userErrs.ForField("media"))
+	s.Equal([]string{"input", "variants", "0", "price"}, userErrs.ForField("input", "variants")[0].Field)
+	s.Len(userErrs.ForField("input"), 2)
+	s.Empty(userErrs.ForField("input", "handle"))
+	s.Len(userErrs.ForField(), 4)
+	s.ElementsMatch([]string{"INVALID", "TOO_LONG"}, userErrs.Codes())
+}
+
+func (s *GraphQLTestSuite) TestErrors() {
+	s.respond(`{"errors":[{"message":"Throttled","extensions":{"code":"THROTTLED"}}]}`)
+	err := s.client.GraphQL(context.Background(), s.sess, "{ shop { name } }", nil, nil)
+	var gqlErrs GraphQLErrors
+	s.Require().ErrorAs(err, &gqlErrs)
+	s.Equal("THROTTLED", gqlErrs[0].Extensions.Code)
+}
+
+func (s *GraphQLTestSuite) TestEnsureWebhooks() {
+	s.client.hostURL = "https://app.example.com"
+	var mutations []string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		if strings.HasPrefix(req.Query, "query webhookSubscriptions") {
+			_, _ = w.Write([]byte(`{"data":{"webhookSubscriptions":{"nodes":[
+				{"id":"1","topic":"APP_UNINSTALLED","endpoint":{"__typename":"WebhookHttpEndpoint","callbackUrl":"https://app.example.com/webhooks"}},
+				{"id":"2","topic":"ORDERS_CREATE","endpoint":{"__typename":"WebhookHttpEndpoint","callbackUrl":"https://old.example.com/webhooks"}},
+				{"id":"3","topic":"PRODUCTS_UPDATE","endpoint":{"__typename":"WebhookHttpEndpoint","callbackUrl":"https://app.example.com/webhooks"}}
+			],"pageInfo":{"hasNextPage":false}}}}`))
+			return
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(req.Query, "mutation "), "(")
+		mutations = append(mutations, name)
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}
+	err := s.client.EnsureWebhooks(context.Background(), s.sess, []WebhookSubscription{
+		{Topic: "app/uninstalled", CallbackURL: "/webhooks"},
+		{Topic: "orders/create", CallbackURL: "/webhooks"},
+		{Topic: "customers/create", PubSubProject: "project", PubSubTopic: "topic"},
+	})
+	s.Require().NoError(err)
+	s.Equal([]string{"update", "create", "delete"}, mutations)
+}
+
+func (s *GraphQLTestSuite) TestSetMetafieldsBatches() {
+	var batches []int
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables struct {
+				Metafields []MetafieldInput `json:"metafields"`
+			} `json:"variables"`
+		}
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		batches = append(batches, len(req.Variables.Metafields))
+		if len(batches) == 2 {
+			_, _ = w.Write([]byte(`{"data":{"metafieldsSet":{"metafields":[],"userErrors":[
+				{"field":["metafields","2","value"],"message":"Value is invalid","code":"INVALID_VALUE"}]}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"metafieldsSet":{"metafields":[{"id":"gid://shopify/Metafield/1"}],"userErrors":[]}}}`))
+	}
+	inputs := make([]MetafieldInput, 30)
+	for i := range inputs {
+		inputs[i] = MetafieldInput{OwnerID: "gid://shopify/Product/1", Namespace: "custom", Key: strconv.Itoa(i),
+			Type: "single_line_text_field", Value: "value"}
+	}
+	set, err := s.client.SetMetafields(context.Background(), s.sess, inputs)
+	s.Equal([]int{25, 5}, batches)
+	s.Len(set, 1)
+	var errs MetafieldErrors
+	s.Require().ErrorAs(err, &errs)
+	s.Require().Len(errs, 1)
+	s.Equal(27, errs[0].Index)
+	s.Equal("INVALID_VALUE", errs[0].Code)
+}
+
+func (s *GraphQLTestSuite) TestRetryThrottled() {
+	var sleeps []time.Duration
+	s.client.retries = 1
+	s.client.sleep = func(_ context.Context, d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+	bodies := []string{
+		`{"errors":[{"message":"Throttled","extensions":{"code":"THROTTLED"}}],"extensions":{"cost":{
+			"requestedQueryCost":110,"actualQueryCost":null,
+			"throttleStatus":{"maximumAvailable":1000,"currentlyAvailable":10,"restoreRate":50}}}}`,
+		`{"data":{"shop":{"name":"Test"}}}`,
+	}
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(bodies[0]))
+		bodies = bodies[1:]
+	}
+	var out struct {
+		Shop struct {
+			Name string `json:"name"`
+		} `json:"shop"`
+	}
+	s.Require().NoError(s.client.GraphQL(context.Background(), s.sess, "{ shop { name } }", nil, &out))
+	s.Equal("Test", out.Shop.Name)
+	s.Equal([]time.Duration{2 * time.Second}, sleeps)
+}
+
+func (s *GraphQLTestSuite) TestStagedUpload() {
+	var fields []string
+	var file string
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			reader, err := r.MultipartReader()
+			s.Require().NoError(err)
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					break
+				}
+				bs, _ := io.ReadAll(part)
+				if part.FormName() == "file" {
+					file = string(bs)
+					continue
+				}
+				fields = append(fields, part.FormName()+"="+string(bs))
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"stagedUploadsCreate":{"stagedTargets":[{"url":"` + s.server.URL + `/upload",
+			"resourceUrl":"https://shopify-staged-uploads.storage.googleapis.com/tmp/image.png","parameters":[
+			{"name":"Content-Type","value":"image/png"},{"name":"success_action_status","value":"201"},
+			{"name":"acl","value":"private"},{"name":"key","value":"tmp/image.png"},
+			{"name":"policy","value":"policy"}]}],"userErrors":[]}}}`))
+	}
+	url, err := s.client.StagedUpload(context.Background(), s.sess, StagedUploadInput{Filename: "image.png",
+		MimeType: "image/png", Resource: StagedUploadImage, FileSize: 5}, strings.NewReader("image"))
+	s.Require().NoError(err)
+	s.Equal("https://shopify-staged-uploads.storage.googleapis.com/tmp/image.png", url)
+	s.Equal([]string{"Content-Type=image/png", "success_action_status=201", "acl=private", "key=tmp/image.png",
+		"policy=policy"}, fields)
+	s.Equal("image", file)
+}
+
+func (s *GraphQLTestSuite) TestAdjustInventory() {
+	var input map[string]any
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		input = req.Variables["input"].(map[string]any)
+		_, _ = w.Write([]byte(`{"data":{"inventoryAdjustQuantities":{"userErrors":[{"field":["input","changes","0"],
+			"message":"The specified inventory item is not stocked at the location.","code":"ITEM_NOT_STOCKED_AT_LOCATION"}]}}}`))
+	}
+	err := s.client.AdjustInventory(context.Background(), s.sess, "gid://shopify/InventoryItem/1",
+		"gid://shopify/Location/1", -2, WithInventoryReason("damaged"))
+	s.ErrorIs(err, ErrInventoryNotTracked)
+	s.Equal("available", input["name"])
+	s.Equal("damaged", input["reason"])
+	s.NotEmpty(input["referenceDocumentUri"])
+	s.Equal([]any{map[string]any{"inventoryItemId": "gid://shopify/InventoryItem/1",
+		"locationId": "gid://shopify/Location/1", "delta": float64(-2)}}, input["changes"])
+}
+
+func (s *GraphQLTestSuite) TestGraphQLBatch() {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		s.Equal("query batch($q1_id: ID!, $q2_id: ID!) {\n q0_shop: shop { name } \n"+
+			" q1_product: product(id: $q1_id) { title } \n q2_p: product(id: $q2_id) { title } \n}", req.Query)
+		s.Equal(map[string]any{"q1_id": "gid://shopify/Product/1", "q2_id": "gid://shopify/Product/2"}, req.Variables)
+		_, _ = w.Write([]byte(`{"data":{"q0_shop":{"name":"Test"},"q1_product":{"title":"Hat"},"q2_p":null},
+			"errors":[{"message":"Access denied","path":["q2_p"],"extensions":{"code":"ACCESS_DENIED"}}]}`))
+	}
+	var shop struct {
+		Shop struct {
+			Name string `json:"name"`
+		} `json:"shop"`
+	}
+	var product struct {
+		Product struct {
+			Title string `json:"title"`
+		} `json:"product"`
+	}
+	results, err := s.client.GraphQLBatch(context.Background(), s.sess, []GraphQLRequest{
+		{Query: "{ shop { name } }", Out: &shop},
+		{Query: "query product($id: ID!) { product(id: $id) { title } }",
+			Variables: map[string]any{"id": "gid://shopify/Product/1"}, Out: &product},
+		{Query: "query($id: ID!) { p: product(id: $id) { title } }",
+			Variables: map[string]any{"id": "gid://shopify/Product/2"}},
+	})
+	s.Require().NoError(err)
+	s.Require().Len(results, 3)
+	s.NoError(results[0].Err)
+	s.Equal("Test", shop.Shop.Name)
+	s.NoError(results[1].Err)
+	s.Equal("Hat", product.Product.Title)
+	var gqlErrs GraphQLErrors
+	s.Require().ErrorAs(results[2].Err, &gqlErrs)
+	s.Equal([]any{"p"}, gqlErrs[0].Path)
+
+	_, err = s.client.GraphQLBatch(context.Background(), s.sess, []GraphQLRequest{{Query: "mutation { shop { name } }"}})
+	s.Error(err)
+}
+
+func (s *GraphQLTestSuite) TestCreateFulfillment() {
+	var fulfillment map[string]any
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		fulfillment = req.Variables["fulfillment"].(map[string]any)
+		_, _ = w.Write([]byte(`{"data":{"fulfillmentCreateV2":{"fulfillment":{"id":"gid://shopify/Fulfillment/1",
+			"status":"SUCCESS","trackingInfo":[{"number":"1Z","company":"UPS","url":null}]},"userErrors":[]}}}`))
+	}
+	res, err := s.client.CreateFulfillment(context.Background(), s.sess, FulfillmentInput{
+		FulfillmentOrders: []FulfillmentOrderItems{{FulfillmentOrderID: "gid://shopify/FulfillmentOrder/1",
+			LineItems: []FulfillmentLineItem{{ID: "gid://shopify/FulfillmentOrderLineItem/1", Quantity: 2}}}},
+		TrackingNumber:  "1Z",
+		TrackingCompany: "UPS",
+		NotifyCustomer:  true,
+	})
+	s.Require().NoError(err)
+	s.Equal("gid://shopify/Fulfillment/1", res.ID)
+	s.Equal([]TrackingInfo{{Number: "1Z", Company: "UPS"}}, res.TrackingInfo)
+	s.Equal(map[string]any{"number": "1Z", "company": "UPS"}, fulfillment["trackingInfo"])
+	s.Equal(true, fulfillment["notifyCustomer"])
+	s.Equal([]any{map[string]any{"fulfillmentOrderId": "gid://shopify/FulfillmentOrder/1",
+		"fulfillmentOrderLineItems": []any{map[string]any{"id": "gid://shopify/FulfillmentOrderLineItem/1",
+			"quantity": float64(2)}}}}, fulfillment["lineItemsByFulfillmentOrder"])
+
+	s.respond(`{"data":{"fulfillmentCreateV2":{"fulfillment":null,"userErrors":[{"field":["fulfillment"],
+		"message":"Line item has already been fulfilled."}]}}}`)
+	_, err = s.client.CreateFulfillment(context.Background(), s.sess, FulfillmentInput{
+		FulfillmentOrders: []FulfillmentOrderItems{{FulfillmentOrderID: "gid://shopify/FulfillmentOrder/1"}}})
+	s.ErrorIs(err, ErrAlreadyFulfilled)
+}
+
+func (s *GraphQLTestSuite) TestEstimateCost() {
+	for query, exp := range map[string]int{
+		"{ shop { name } }":                                           1,
+		"{ shop { name primaryDomain { url } } }":                     2,
+		"{ products(first: 10) { edges { cursor node { title } } } }": 12,
+		`query products($first: Int = 5) {
+			products(first: $first) {
+				nodes { title variants(first: 3) { nodes { sku } } }
+				pageInfo { hasNextPage }
+			}
+		}`: 2 + 5*(1+2+3),
+		"query($after: String) { orders(first: 2, after: $after, query: \"status:open\") { ...order } } fragment order on OrderConnection { nodes { id customer { email } } }": 2 + 2*2,
+		"query($n: Int!) { locations(last: $n) { nodes { id } } }":                                                               2 + 250,
+		"mutation { a: tagsAdd(id: \"1\", tags: [\"x\"]) { node { id } } b: tagsAdd(id: \"2\", tags: [\"y\"]) { node { id } } }": 20,
+	} {
+		cost, err := EstimateCost(query)
+		s.NoError(err, query)
+		s.Equal(exp, cost, query)
+	}
+	for _, query := range []string{"", "{ shop { name }", "{ products(first: many) { nodes { id } } }", "{ ...missing }"} {
+		_, err := EstimateCost(query)
+		s.Error(err, query)
+	}
+}
+
+func (s *GraphQLTestSuite) TestPaginateGraphQL() {
+	pages := []string{
+		`{"data":{"products":{"nodes":[{"id":"1"},{"id":"2"}],"pageInfo":{"hasNextPage":true,"endCursor":"c2"}}}}`,
+		`{"data":{"products":{"nodes":[{"id":"3"}],"pageInfo":{"hasNextPage":false,"endCursor":"c3"}}}}`,
+	}
+	var cursors []any
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		cursors = append(cursors, req.Variables["cursor"])
+		_, _ = w.Write([]byte(pages[0]))
+		pages = pages[1:]
+	}
+	type product struct {
+		ID string `json:"id"`
+	}
+	extract := func(data json.RawMessage) ([]product, PageInfo, error) {
+		var out struct {
+			Products struct {
+				Nodes    []product `json:"nodes"`
+				PageInfo PageInfo  `json:"pageInfo"`
+			} `json:"products"`
+		}
+		err := json.Unmarshal(data, &out)
+		return out.Products.Nodes, out.Products.PageInfo, err
+	}
+	vars := map[string]any{"first": 2}
+	var ids []string
+	err := PaginateGraphQL(context.Background(), s.client, s.sess,
+		"query($first: Int!, $cursor: String) { products(first: $first, after: $cursor) { nodes { id } pageInfo { hasNextPage endCursor } } }",
+		vars, CursorVariable("cursor"), extract, func(p product) error {
+			ids = append(ids, p.ID)
+			return nil
+		})
+	s.Require().NoError(err)
+	s.Equal([]string{"1", "2", "3"}, ids)
+	s.Equal([]any{nil, "c2"}, cursors)
+	s.Equal(map[string]any{"first": 2}, vars)
+
+	s.respond(`{"data":{"products":{"nodes":[],"pageInfo":{"hasNextPage":false,"endCursor":null}}}}`)
+	calls := 0
+	err = PaginateGraphQL(context.Background(), s.client, s.sess, "{ products(first: 2) { nodes { id } } }",
+		nil, nil, extract, func(product) error {
+			calls++
+			return nil
+		})
+	s.NoError(err)
+	s.Zero(calls)
+
+	s.respond(`{"data":{"products":{"nodes":[{"id":"1"},{"id":"2"}],"pageInfo":{"hasNextPage":true,"endCursor":"c2"}}}}`)
+	err = PaginateGraphQL(context.Background(), s.client, s.sess, "{ products(first: 2) { nodes { id } } }",
+		nil, nil, extract, func(product) error {
+			calls++
+			return ErrStopPagination
+		})
+	s.NoError(err)
+	s.Equal(1, calls)
+}
+
+func (s *GraphQLTestSuite) TestRunNamed() {
+	query := "query getOrder($id: ID!) { order(id: $id) { name lineItems(first: 5) { nodes { title } } } }"
+	s.Require().NoError(s.client.RegisterQuery("getOrder", query))
+	s.Error(s.client.RegisterQuery("broken", "{ shop { name }"))
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		s.Equal(query, req.Query)
+		s.Equal(map[string]any{"id": "gid://shopify/Order/1"}, req.Variables)
+		_, _ = w.Write([]byte(`{"data":{"order":{"name":"#1001"}}}`))
+	}
+
+	var out struct {
+		Order struct {
+			Name string `json:"name"`
+		} `json:"order"`
+	}
+	bound := s.client.bind(s.sess)
+	s.Require().NoError(bound.RunNamed(context.Background(), s.sess, "getOrder",
+		map[string]any{"id": "gid://shopify/Order/1"}, &out), "bound clients share the queries")
+	s.Equal("#1001", out.Order.Name)
+	s.Error(s.client.RunNamed(context.Background(), s.sess, "unknown", nil, nil))
+
+	cost, ok := s.client.NamedQueryCost("getOrder")
+	s.True(ok)
+	s.Equal(8, cost)
+}
+
+func (s *GraphQLTestSuite) TestListAll() {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		if req.Variables["after"] == nil {
+			_, _ = w.Write([]byte(`{"data":{"products":{"nodes":[{"id":"1"},{"id":"2"}],"pageInfo":{"hasNextPage":true,"endCursor":"c2"}}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"products":{"nodes":[{"id":"3"}],"pageInfo":{"hasNextPage":false}}}}`))
+	}
+	extract := func(data json.RawMessage) ([]string, PageInfo, error) {
+		var out struct {
+			Products struct {
+				Nodes []struct {
+					ID string `json:"id"`
+				} `json:"nodes"`
+				PageInfo PageInfo `json:"pageInfo"`
+			} `json:"products"`
+		}
+		err := json.Unmarshal(data, &out)
+		var ids []string
+		for _, n := range out.Products.Nodes {
+			ids = append(ids, n.ID)
+		}
+		return ids, out.Products.PageInfo, err
+	}
+	query := "query($after: String) { products(first: 2, after: $after) { nodes { id } pageInfo { hasNextPage endCursor } } }"
+	ids, err := ListAll(context.Background(), s.client, s.sess, query, nil, extract, 0)
+	s.Require().NoError(err)
+	s.Equal([]string{"1", "2", "3"}, ids)
+
+	ids, err = ListAll(context.Background(), s.client, s.sess, query, nil, extract, 3)
+	s.Require().NoError(err)
+	s.Len(ids, 3)
+
+	_, err = ListAll(context.Background(), s.client, s.sess, query, nil, extract, 2)
+	s.ErrorIs(err, ErrTooManyResults)
+	s.ErrorContains(err, "bulk operation")
+}
+
+func (s *GraphQLTestSuite) TestMutationInputs() {
+	bs, err := json.Marshal(ProductInput{Title: Set("Hat"), Vendor: Null[string](), Tags: Set([]string{}),
+		SEO: &ProductSEOInput{Description: Set("A hat")}})
+	s.Require().NoError(err)
+	s.JSONEq(`{"title":"Hat","vendor":null,"tags":[],"seo":{"description":"A hat"}}`, string(bs),
+		"unset fields must be omitted, null ones sent")
+	title, ok := Set("Hat").Value()
+	s.True(ok)
+	s.Equal("Hat", title)
+	_, ok = Null[string]().Value()
+	s.False(ok)
+
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string                     `json:"query"`
+			Variables map[string]json.RawMessage `json:"variables"`
+		}
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		s.Contains(req.Query, "customerUpdate(input: $input)")
+		s.JSONEq(`{"id":"gid://shopify/Customer/1","note":null,"tags":["vip"]}`, string(req.Variables["input"]))
+		_, _ = w.Write([]byte(`{"data":{"customerUpdate":{"customer":{"id":"gid://shopify/Customer/1",
+			"note":null,"tags":["vip"]},"userErrors":[]}}}`))
+	}
+	customer, err := s.client.CustomerUpdate(context.Background(), s.sess, CustomerInput{ID: "gid://shopify/Customer/1",
+		Note: Null[string](), Tags: Set([]string{"vip"})})
+	s.Require().NoError(err)
+	s.Nil(customer.Note)
+	s.Equal([]string{"vip"}, customer.Tags)
+
+	_, err = s.client.ProductUpdate(context.Background(), s.sess, ProductInput{Title: Set("Hat")})
+	s.Error(err)
+}
+
+func (s *GraphQLTestSuite) TestBulkQuery() {
+	s.client.sleep = func(context.Context, time.Duration) {}
+	polls := 0
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		s.NoError(json.NewDecoder(r.Body).Decode(&req))
+		if strings.Contains(req.Query, "bulkOperationRunQuery") {
+			s.Equal("{ products { edges { node { id } } } }", req.Variables["query"])
+			_, _ = w.Write([]byte(`{"data":{"bulkOperationRunQuery":{"bulkOperation":{"id":"gid://shopify/BulkOperation/1",
+				"status":"CREATED"},"userErrors":[]}}}`))
+			return
+		}
+		s.Equal("gid://shopify/BulkOperation/1", req.Variables["id"])
+		polls++
+		status := "RUNNING"
+		if polls == 3 {
+			status = "COMPLETED"
+		}
+		_, _ = w.Write([]byte(`{"data":{"node":{"id":"gid://shopify/BulkOperation/1","status":"` + status + `",
+			"objectCount":"2","url":"https://storage.example.com/bulk.jsonl"}}}`))
+	}
+	op, err := s.client.BulkQuery(context.Background(), s.sess, "{ products { edges { node { id } } } }")
+	s.Require().NoError(err)
+	s.Equal(BulkOperationCreated, op.Status)
+	op, err = s.client.WaitForBulk(context.Background(), s.sess, op.ID)
+	s.Require().NoError(err)
+	s.Equal(3, polls)
+	s.Equal(BulkOperationCompleted, op.Status)
+	s.Equal("https://storage.example.com/bulk.jsonl", op.URL)
+}
+
+func (s *GraphQLTestSuite) TestWaitForBulkFailure() {
+	for status, code := range map[BulkOperationStatus]string{
+		BulkOperationFailed:   "ACCESS_DENIED",
+		BulkOperationCanceled: "",
+	} {
+		s.respond(`{"data":{"node":{"id":"gid://shopify/BulkOperation/1","status":"` + string(status) + `",
+			"errorCode":` + strconv.Quote(code) + `}}}`)
+		op, err := s.client.WaitForBulk(context.Background(), s.sess, "gid://shopify/BulkOperation/1")
+		var bulkErr *BulkOperationError
+		s.Require().ErrorAs(err, &bulkErr, status)
+		s.Equal(&BulkOperationError{ID: "gid://shopify/BulkOperation/1", Status: status, ErrorCode: code}, bulkErr)
+		s.Equal(status, op.Status)
+	}
+}
+
+func (s *GraphQLTestSuite) TestDownloadBulk() {
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("/bulk.jsonl", r.URL.Path)
+		_, _ = w.Write([]byte("{\"id\":\"gid://shopify/Product/1\"}\n\n{\"id\":\"gid://shopify/Product/2\"}\n" +
+			"{\"id\":\"gid://shopify/Product/3\"}"))
+	}
+	out := make(chan json.RawMessage)
+	errs := make(chan error, 1)
+	go func() { errs <- s.client.DownloadBulk(context.Background(), s.server.URL+"/bulk.jsonl", out) }()
+	var lines []string
+	for line := range out {
+		lines = append(lines, string(line))
+	}
+	s.Require().NoError(<-errs)
+	s.Equal([]string{`{"id":"gid://shopify/Product/1"}`, `{"id":"gid://shopify/Product/2"}`,
+		`{"id":"gid://shopify/Product/3"}`}, lines, "the last line must be sent without trailing newline")
+
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	out = make(chan json.RawMessage)
+	s.Error(s.client.DownloadBulk(context.Background(), s.server.URL+"/bulk.jsonl", out))
+	_, open := <-out
+	s.False(open, "out must be closed on errors")
+}
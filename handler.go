@@ -0,0 +1,49 @@
+package shopigo
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// AuthBeginHandler returns Begin as http.Handler, for mounting on routers
+// other than gin. Mount it on the path passed to WithAuthBeginEndpoint, which
+// is only used to build redirect URLs.
+func (a *App) AuthBeginHandler() http.Handler {
+	return httpHandler(a.Begin)
+}
+
+// AuthCallbackHandler returns Install as http.Handler, mount it on the path
+// passed to WithAuthCallbackEndpoint.
+func (a *App) AuthCallbackHandler() http.Handler {
+	return httpHandler(a.Install)
+}
+
+// UninstallWebhookHandler returns UninstallHandler as http.Handler, mount it
+// on the path passed to WithUninstallWebhookEndpoint.
+func (a *App) UninstallWebhookHandler() http.Handler {
+	return httpHandler(a.UninstallHandler)
+}
+
+// RequireScopeMiddleware returns RequireScope as net/http middleware. The
+// session is taken from the request context, see ContextWithSession.
+func (a *App) RequireScopeMiddleware(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return httpHandler(func(c *gin.Context) {
+			if sess := SessionFromContext(c.Request.Context()); sess != nil {
+				c.Set(ShopSessionKey, sess)
+			}
+		}, a.RequireScope(scopes...), func(c *gin.Context) {
+			next.ServeHTTP(c.Writer, c.Request)
+		})
+	}
+}
+
+// httpHandler serves handlers on every path and method, so the handler works
+// wherever it's mounted. An engine without routes passes all requests to
+// NoRoute.
+func httpHandler(handlers ...gin.HandlerFunc) http.Handler {
+	e := gin.New()
+	e.ContextWithFallback = true
+	e.NoRoute(handlers...)
+	return e
+}
@@ -0,0 +1,40 @@
+package shopigo
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// Pinger is implemented by session stores which can check the connection to
+// their backend.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PingSessionStore pings the store if it supports it. Stores without Pinger
+// are considered healthy.
+func PingSessionStore(ctx context.Context, store SessionStore) error {
+	if p, ok := store.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// HealthHandler responds with 200 if the session store is reachable and 503
+// otherwise, e.g. for readiness probes.
+func (a *App) HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := PingSessionStore(ctx, a.SessionStore); err != nil {
+			a.logger(c).With("error", err).Warn("session store ping failed")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
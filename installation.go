@@ -0,0 +1,115 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// grantedScopesTTL is how long GrantedScopes caches the scopes of a shop.
+const grantedScopesTTL = time.Minute
+
+type grantedScopesEntry struct {
+	scopes  Scopes
+	expires time.Time
+}
+
+type grantedScopesCache struct {
+	mu      sync.Mutex
+	entries map[string]grantedScopesEntry
+}
+
+func newGrantedScopesCache() *grantedScopesCache {
+	return &grantedScopesCache{entries: map[string]grantedScopesEntry{}}
+}
+
+func (g *grantedScopesCache) get(shop string) (Scopes, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.entries[shop]
+	if !ok || time.Now().After(e.expires) {
+		delete(g.entries, shop)
+		return nil, false
+	}
+	return e.scopes, true
+}
+
+func (g *grantedScopesCache) set(shop string, scopes Scopes) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.entries[shop] = grantedScopesEntry{scopes: scopes, expires: time.Now().Add(grantedScopesTTL)}
+}
+
+func (g *grantedScopesCache) invalidate(shop string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, shop)
+}
+
+// AccessScopes returns the scopes granted to the app installation of the
+// session's shop.
+func (c *Client) AccessScopes(ctx context.Context, sess *Session) (Scopes, error) {
+	var out struct {
+		CurrentAppInstallation struct {
+			AccessScopes []struct {
+				Handle string `json:"handle"`
+			} `json:"accessScopes"`
+		} `json:"currentAppInstallation"`
+	}
+	if err := c.GraphQL(ctx, sess, "{ currentAppInstallation { accessScopes { handle } } }", nil, &out); err != nil {
+		return nil, fmt.Errorf("failed to query access scopes: %w", err)
+	}
+	handles := make([]string, len(out.CurrentAppInstallation.AccessScopes))
+	for i, scope := range out.CurrentAppInstallation.AccessScopes {
+		handles[i] = scope.Handle
+	}
+	return ParseScopes(Scopes(handles).String()), nil
+}
+
+// GrantedScopes returns the scopes granted to the app by shop according to
+// Shopify, which can differ from the scopes of the stored session if they were
+// changed out of band, e.g. by declarative scopes. Results are cached for a
+// minute and dropped on app/scopes_update webhooks.
+func (a *App) GrantedScopes(ctx context.Context, shop string) (Scopes, error) {
+	if scopes, ok := a.grantedScopes.get(shop); ok {
+		return scopes, nil
+	}
+	client, err := a.ClientFor(ctx, shop)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := client.AccessScopes(ctx, client.Session())
+	if err != nil {
+		return nil, err
+	}
+	a.grantedScopes.set(shop, scopes)
+	return scopes, nil
+}
+
+// syncGrantedScopes records the scopes granted according to Shopify in the
+// offline session sess, for sessions whose stored scopes don't match the
+// configured ones. It returns the scopes to check, the stored ones if the
+// granted scopes can't be queried. Online sessions are returned as is, their
+// scopes depend on the user.
+func (a *App) syncGrantedScopes(ctx context.Context, sess *Session) Scopes {
+	stored := ParseScopes(sess.Scopes)
+	if sess.IsOnline {
+		return stored
+	}
+	granted, err := a.GrantedScopes(ctx, sess.Shop)
+	if err != nil {
+		a.Client.logger.Debug("failed to query granted scopes", "shop", sess.Shop, "error", err)
+		return stored
+	}
+	if granted.Equal(stored) {
+		return stored
+	}
+	updated := copySession(sess)
+	updated.Scopes = granted.String()
+	if err = a.SessionStore.Store(ctx, updated); err != nil {
+		a.Client.logger.Warn("failed to store granted scopes", "shop", sess.Shop, "error", err)
+	}
+	a.clients.invalidate(sess.Shop)
+	return granted
+}
@@ -0,0 +1,161 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+const (
+	InventoryAvailable = "available"
+	InventoryOnHand    = "on_hand"
+
+	defaultInventoryReason = "correction"
+)
+
+// ErrInventoryNotTracked is returned if the inventory item isn't stocked at
+// the location. The item has to be activated there first.
+var ErrInventoryNotTracked = errors.New("inventory item not stocked at location")
+
+type inventoryOptions struct {
+	name                 string
+	reason               string
+	referenceDocumentURI string
+}
+
+type InventoryOpt = func(o *inventoryOptions)
+
+// WithInventoryName sets the quantity to change, InventoryAvailable by
+// default.
+func WithInventoryName(name string) InventoryOpt {
+	return func(o *inventoryOptions) {
+		o.name = name
+	}
+}
+
+// WithInventoryReason sets the reason of the change, e.g. received or
+// damaged. Defaults to correction.
+func WithInventoryReason(reason string) InventoryOpt {
+	return func(o *inventoryOptions) {
+		o.reason = reason
+	}
+}
+
+// WithReferenceDocumentURI links the change to the document causing it, e.g.
+// an order or a purchase order. By default a unique URI is generated.
+func WithReferenceDocumentURI(uri string) InventoryOpt {
+	return func(o *inventoryOptions) {
+		o.referenceDocumentURI = uri
+	}
+}
+
+func newInventoryOptions(opts []InventoryOpt) *inventoryOptions {
+	o := &inventoryOptions{
+		name:                 InventoryAvailable,
+		reason:               defaultInventoryReason,
+		referenceDocumentURI: "gid://shopigo/InventoryChange/" + uuid.NewString(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+type Location struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	IsActive bool   `json:"isActive"`
+}
+
+// AdjustInventory changes the quantity of the inventory item at the location
+// by delta.
+func (c *Client) AdjustInventory(ctx context.Context, sess *Session, inventoryItemGID string, locationGID string, delta int, opts ...InventoryOpt) error {
+	o := newInventoryOptions(opts)
+	err := c.GraphQL(ctx, sess, `mutation inventoryAdjustQuantities($input: InventoryAdjustQuantitiesInput!) {
+		inventoryAdjustQuantities(input: $input) {
+			userErrors { field message code }
+		}
+	}`, map[string]any{"input": map[string]any{
+		"name":                 o.name,
+		"reason":               o.reason,
+		"referenceDocumentUri": o.referenceDocumentURI,
+		"changes": []map[string]any{{
+			"inventoryItemId": inventoryItemGID,
+			"locationId":      locationGID,
+			"delta":           delta,
+		}},
+	}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to adjust inventory: %w", inventoryError(err))
+	}
+	return nil
+}
+
+// SetInventory sets the quantity of the inventory item at the location,
+// regardless of the current quantity.
+func (c *Client) SetInventory(ctx context.Context, sess *Session, inventoryItemGID string, locationGID string, quantity int, opts ...InventoryOpt) error {
+	o := newInventoryOptions(opts)
+	err := c.GraphQL(ctx, sess, `mutation inventorySetQuantities($input: InventorySetQuantitiesInput!) {
+		inventorySetQuantities(input: $input) {
+			userErrors { field message code }
+		}
+	}`, map[string]any{"input": map[string]any{
+		"name":                  o.name,
+		"reason":                o.reason,
+		"referenceDocumentUri":  o.referenceDocumentURI,
+		"ignoreCompareQuantity": true,
+		"quantities": []map[string]any{{
+			"inventoryItemId": inventoryItemGID,
+			"locationId":      locationGID,
+			"quantity":        quantity,
+		}},
+	}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set inventory: %w", inventoryError(err))
+	}
+	return nil
+}
+
+// Locations returns all locations of the shop.
+func (c *Client) Locations(ctx context.Context, sess *Session) ([]Location, error) {
+	var locations []Location
+	vars := map[string]any{}
+	for {
+		var out struct {
+			Locations struct {
+				Nodes    []Location `json:"nodes"`
+				PageInfo PageInfo   `json:"pageInfo"`
+			} `json:"locations"`
+		}
+		err := c.GraphQL(ctx, sess, `query locations($after: String) {
+			locations(first: 100, after: $after) {
+				nodes { id name isActive }
+				pageInfo { hasNextPage endCursor }
+			}
+		}`, vars, &out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list locations: %w", err)
+		}
+		locations = append(locations, out.Locations.Nodes...)
+		if !out.Locations.PageInfo.HasNextPage {
+			return locations, nil
+		}
+		vars["after"] = out.Locations.PageInfo.EndCursor
+	}
+}
+
+// inventoryError wraps user errors for items not stocked at the location in
+// ErrInventoryNotTracked.
+func inventoryError(err error) error {
+	var userErrs UserErrors
+	if !errors.As(err, &userErrs) {
+		return err
+	}
+	for _, e := range userErrs {
+		if e.Code == "ITEM_NOT_STOCKED_AT_LOCATION" {
+			return fmt.Errorf("%w: %w", ErrInventoryNotTracked, err)
+		}
+	}
+	return err
+}
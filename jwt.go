@@ -5,67 +5,69 @@ import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"net/url"
-	"strings"
-	"time"
 )
 
-func (a *App) parseJWTSessionID(token string, isOnline bool) (string, string, error) {
-	tok, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(a.Credentials.ClientSecret), nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
-	if err != nil {
-		return "", "", fmt.Errorf("failed to parse jwt: %w", err)
-	}
-	exp, err := tok.Claims.GetExpirationTime()
-	if err != nil || time.Now().After(exp.Time) {
-		return "", "", errors.New("token expired")
-	}
-	nbf, err := tok.Claims.GetNotBefore()
-	if err != nil || time.Now().Before(nbf.Time) {
-		return "", "", errors.New("token not yet valid")
-	}
-	iss, err := tok.Claims.GetIssuer()
+type SessionClaims struct {
+	jwt.RegisteredClaims
+	Dest string `json:"dest"`
+	Sid  string `json:"sid"`
+}
+
+func (c *SessionClaims) Shop() string {
+	u, err := url.Parse(c.Dest)
 	if err != nil {
-		return "", "", errors.New("invalid issuer")
+		return ""
 	}
-	issURL, err := url.Parse(iss)
+	return u.Hostname()
+}
+
+func (c *SessionClaims) UserID() string {
+	return c.Subject
+}
+
+func (a *App) DecodeSessionToken(token string) (*SessionClaims, error) {
+	var claims SessionClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(a.Credentials.ClientSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		jwt.WithAudience(a.Credentials.ClientID),
+		jwt.WithLeeway(a.sessionTokenLeeway),
+	)
 	if err != nil {
-		return "", "", errors.New("failed to parse issue ShopURL")
+		return nil, fmt.Errorf("failed to parse jwt: %w", err)
 	}
-	claimsMap, ok := tok.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", "", errors.New("failed to parse claim map")
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiration")
 	}
-	dest, ok := claimsMap["dest"].(string)
-	if !ok {
-		return "", "", errors.New("failed to read claim's dest")
+	if claims.NotBefore == nil {
+		return nil, errors.New("token has no not before")
 	}
-	destURL, err := url.Parse(dest)
+	issURL, err := url.Parse(claims.Issuer)
 	if err != nil {
-		return "", "", errors.New("failed to parse dest ShopURL")
+		return nil, errors.New("failed to parse issuer url")
 	}
-	if issURL.Hostname() != destURL.Hostname() {
-		return "", "", errors.New("iss and dest host not matching")
+	destURL, err := url.Parse(claims.Dest)
+	if err != nil || destURL.Hostname() == "" {
+		return nil, errors.New("failed to parse dest url")
 	}
-	aud, err := tok.Claims.GetAudience()
-	in := func(sl []string, s string) bool {
-		for i := range sl {
-			if sl[i] == s {
-				return true
-			}
-		}
-		return false
+	if issURL.Hostname() != destURL.Hostname() {
+		return nil, errors.New("iss and dest host not matching")
 	}
-	if err != nil || !in(aud, a.Credentials.ClientID) {
-		return "", "", errors.New("invalid client id")
+	return &claims, nil
+}
+
+func (a *App) parseJWTSessionID(token string, isOnline bool) (SessionID, string, error) {
+	claims, err := a.DecodeSessionToken(token)
+	if err != nil {
+		return SessionID{}, "", err
 	}
-	shop := strings.ReplaceAll(destURL.Hostname(), "https://", "")
+	shop := claims.Shop()
 	if isOnline {
-		user, err := tok.Claims.GetSubject()
-		if err != nil {
-			return "", "", err
+		if claims.UserID() == "" {
+			return SessionID{}, "", errors.New("token has no subject")
 		}
-		return GetOnlineSessionID(shop, user), shop, nil
+		return OnlineSessionID(shop, claims.UserID()), shop, nil
 	}
-	return GetOfflineSessionID(shop), shop, nil
+	return OfflineSessionID(shop), shop, nil
 }
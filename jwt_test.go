@@ -0,0 +1,156 @@
+package shopigo
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jonashex/shopigo/shopigotest"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type JWTTestSuite struct {
+	suite.Suite
+	app *App
+}
+
+func TestJWTTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTTestSuite))
+}
+
+func (s *JWTTestSuite) SetupTest() {
+	c := testAppConfig()
+	c.ClientID = "client-id"
+	c.ClientSecret = "client-secret"
+	app, err := NewApp(c)
+	s.Require().NoError(err)
+	s.app = app
+}
+
+func (s *JWTTestSuite) sessionToken(secret string, modify func(c *SessionClaims)) string {
+	now := time.Now()
+	claims := SessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://test.myshopify.com/admin",
+			Subject:   "42",
+			Audience:  jwt.ClaimStrings{"client-id"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		},
+		Dest: "https://test.myshopify.com",
+		Sid:  "session-id",
+	}
+	if modify != nil {
+		modify(&claims)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	s.Require().NoError(err)
+	return token
+}
+
+func (s *JWTTestSuite) TestTestSessionToken() {
+	claims, err := s.app.DecodeSessionToken(shopigotest.NewTestSessionToken("client-secret", "client-id",
+		"test.myshopify.com", shopigotest.WithUserID("42"), shopigotest.WithSessionID("sid")))
+	s.Require().NoError(err)
+	s.Equal("test.myshopify.com", claims.Shop())
+	s.Equal("42", claims.UserID())
+	s.Equal("sid", claims.Sid)
+
+	for _, token := range []string{
+		shopigotest.NewTestSessionToken("other-secret", "client-id", "test.myshopify.com"),
+		shopigotest.NewTestSessionToken("client-secret", "other-app", "test.myshopify.com"),
+		shopigotest.NewTestSessionToken("client-secret", "client-id", "test.myshopify.com",
+			shopigotest.WithExpiry(time.Now().Add(-time.Hour))),
+		shopigotest.NewTestSessionToken("client-secret", "client-id", "test.myshopify.com",
+			shopigotest.WithClaim("nbf", nil)),
+	} {
+		_, err = s.app.DecodeSessionToken(token)
+		s.Error(err)
+	}
+}
+
+func (s *JWTTestSuite) TestDecodeSessionToken() {
+	claims, err := s.app.DecodeSessionToken(s.sessionToken("client-secret", nil))
+	s.Require().NoError(err)
+	s.Equal("test.myshopify.com", claims.Shop())
+	s.Equal("42", claims.UserID())
+	s.Equal("session-id", claims.Sid)
+}
+
+func (s *JWTTestSuite) TestDecodeSessionTokenWithinLeeway() {
+	token := s.sessionToken("client-secret", func(c *SessionClaims) {
+		c.NotBefore = jwt.NewNumericDate(time.Now().Add(3 * time.Second))
+	})
+	_, err := s.app.DecodeSessionToken(token)
+	s.NoError(err)
+
+	WithSessionTokenLeeway(0)(s.app)
+	_, err = s.app.DecodeSessionToken(token)
+	s.Error(err)
+}
+
+func (s *JWTTestSuite) TestFailDecodeSessionToken() {
+	for name, token := range map[string]string{
+		"expired": s.sessionToken("client-secret", func(c *SessionClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}),
+		"not yet valid": s.sessionToken("client-secret", func(c *SessionClaims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		}),
+		"wrong audience": s.sessionToken("client-secret", func(c *SessionClaims) {
+			c.Audience = jwt.ClaimStrings{"other-client-id"}
+		}),
+		"bad signature": s.sessionToken("other-secret", nil),
+		"dest mismatch": s.sessionToken("client-secret", func(c *SessionClaims) {
+			c.Dest = "https://other.myshopify.com"
+		}),
+		"no expiration": s.sessionToken("client-secret", func(c *SessionClaims) {
+			c.ExpiresAt = nil
+		}),
+	} {
+		_, err := s.app.DecodeSessionToken(token)
+		s.Error(err, name)
+	}
+}
+
+func (s *JWTTestSuite) TestAuthenticatedSession() {
+	store := &inMemSessionStore{}
+	c := testAppConfig()
+	c.ClientID = "client-id"
+	c.ClientSecret = "client-secret"
+	c.HostURL = "https://app.example.com"
+	app, err := NewApp(c, WithSessionStore(store))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/api", app.AuthenticatedSession, func(c *gin.Context) {
+		c.String(http.StatusOK, MustGetShopClient(c).Session().AccessToken)
+	})
+	serve := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	s.Equal(http.StatusUnauthorized, serve("").Code)
+	s.Equal(http.StatusUnauthorized, serve(s.sessionToken("other-secret", nil)).Code)
+
+	rec := serve(s.sessionToken("client-secret", nil))
+	s.Equal(http.StatusForbidden, rec.Code)
+	s.Equal("1", rec.Header().Get("X-Shopify-API-Request-Failure-Reauthorize"))
+	s.Equal("https://app.example.com/auth/begin?shop=test.myshopify.com",
+		rec.Header().Get("X-Shopify-API-Request-Failure-Reauthorize-Url"))
+
+	s.Require().NoError(store.Store(context.Background(),
+		&Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com", AccessToken: "token"}))
+	rec = serve(s.sessionToken("client-secret", nil))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("token", rec.Body.String())
+}
@@ -0,0 +1,48 @@
+package shopigo
+
+import (
+	"context"
+)
+
+// LifecycleObserver is notified of the install lifecycle of shops, e.g. for
+// analytics or provisioning. Observers are called synchronously on the
+// request, long running work should be moved to a queue. Embed
+// NoopLifecycleObserver to implement only some of the methods.
+type LifecycleObserver interface {
+	// OnAuthBegin is called when a shop is redirected to the OAuth grant.
+	OnAuthBegin(ctx context.Context, shop string)
+	// OnInstalled is called after the offline session of shop has been
+	// stored, firstTime is false for reinstalls and scope updates, see
+	// HookInstall.
+	OnInstalled(ctx context.Context, shop string, firstTime bool)
+	// OnUninstalled is called after the sessions of shop have been deleted.
+	OnUninstalled(ctx context.Context, shop string)
+	// OnReauth is called when a shop is asked to reauthorize, e.g. because
+	// its session expired or its scopes changed.
+	OnReauth(ctx context.Context, shop string, reason string)
+	// OnTokenRefreshed is called after a session token was exchanged for a
+	// new access token, see App.TokenExchange.
+	OnTokenRefreshed(ctx context.Context, shop string)
+}
+
+type NoopLifecycleObserver struct{}
+
+func (NoopLifecycleObserver) OnAuthBegin(context.Context, string)       {}
+func (NoopLifecycleObserver) OnInstalled(context.Context, string, bool) {}
+func (NoopLifecycleObserver) OnUninstalled(context.Context, string)     {}
+func (NoopLifecycleObserver) OnReauth(context.Context, string, string)  {}
+func (NoopLifecycleObserver) OnTokenRefreshed(context.Context, string)  {}
+
+// WithLifecycleObserver notifies obs of the install lifecycle. Observers are
+// called in the order they are added, after the hooks set with WithHooks.
+func WithLifecycleObserver(obs LifecycleObserver) Opt {
+	return func(a *App) {
+		a.observers = append(a.observers, obs)
+	}
+}
+
+func (a *App) notify(fn func(obs LifecycleObserver)) {
+	for _, obs := range a.observers {
+		fn(obs)
+	}
+}
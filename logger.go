@@ -0,0 +1,38 @@
+package shopigo
+
+import (
+	"context"
+)
+
+const XRequestIDHeader = "X-Request-Id"
+
+// Logger is the structured logger used by the Client and by App work outside
+// of requests, e.g. the session GC. Args are key/value pairs as in log/slog,
+// so a *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string, ...any) {}
+func (noopLogger) Info(string, ...any)  {}
+func (noopLogger) Warn(string, ...any)  {}
+func (noopLogger) Error(string, ...any) {}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID attaches a request ID to ctx. Requests made with the
+// context send it in the X-Request-Id header and log it, so library and app
+// logs can be correlated.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,144 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// metafieldsSetLimit is the maximum number of metafields per metafieldsSet.
+const metafieldsSetLimit = 25
+
+type Metafield struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+}
+
+// MetafieldInput sets the metafield identified by the owner's GID, e.g.
+// gid://shopify/Product/1, namespace and key.
+type MetafieldInput struct {
+	OwnerID   string `json:"ownerId"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+}
+
+// MetafieldError is a user error of the input at Index.
+type MetafieldError struct {
+	Index int
+	UserError
+}
+
+type MetafieldErrors []MetafieldError
+
+func (e MetafieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = fmt.Sprintf("metafield %d: %s", e[i].Index, e[i].UserError.Error())
+	}
+	return "metafield errors: " + strings.Join(msgs, "; ")
+}
+
+const metafieldFields = `id namespace key type value`
+
+// GetMetafield returns the metafield of the owner, nil if it isn't set.
+func (c *Client) GetMetafield(ctx context.Context, sess *Session, ownerGID string, namespace string, key string) (*Metafield, error) {
+	var out struct {
+		Node *struct {
+			Metafield *Metafield `json:"metafield"`
+		} `json:"node"`
+	}
+	err := c.GraphQL(ctx, sess, `query metafield($owner: ID!, $namespace: String!, $key: String!) {
+		node(id: $owner) { ... on HasMetafields { metafield(namespace: $namespace, key: $key) { `+
+		metafieldFields+` } } }
+	}`, map[string]any{"owner": ownerGID, "namespace": namespace, "key": key}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metafield: %w", err)
+	}
+	if out.Node == nil {
+		return nil, fmt.Errorf("metafield owner %s not found", ownerGID)
+	}
+	return out.Node.Metafield, nil
+}
+
+func (c *Client) SetMetafield(ctx context.Context, sess *Session, in MetafieldInput) (*Metafield, error) {
+	mfs, err := c.SetMetafields(ctx, sess, []MetafieldInput{in})
+	if err != nil {
+		return nil, err
+	}
+	if len(mfs) == 0 {
+		return nil, errors.New("metafield not set")
+	}
+	return &mfs[0], nil
+}
+
+// SetMetafields sets the metafields in batches of 25. Each batch is applied
+// atomically by Shopify. User errors of failed batches are returned as
+// MetafieldErrors, indexed by position in inputs, along with the metafields
+// of the successful batches.
+func (c *Client) SetMetafields(ctx context.Context, sess *Session, inputs []MetafieldInput) ([]Metafield, error) {
+	var set []Metafield
+	var errs MetafieldErrors
+	for start := 0; start < len(inputs); start += metafieldsSetLimit {
+		batch := inputs[start:min(start+metafieldsSetLimit, len(inputs))]
+		var out struct {
+			MetafieldsSet struct {
+				Metafields []Metafield `json:"metafields"`
+			} `json:"metafieldsSet"`
+		}
+		err := c.GraphQL(ctx, sess, `mutation metafieldsSet($metafields: [MetafieldsSetInput!]!) {
+			metafieldsSet(metafields: $metafields) {
+				metafields { `+metafieldFields+` }
+				userErrors { field message code }
+			}
+		}`, map[string]any{"metafields": batch}, &out)
+		var userErrs UserErrors
+		if errors.As(err, &userErrs) {
+			for _, e := range userErrs {
+				errs = append(errs, MetafieldError{Index: start + metafieldIndex(e), UserError: e})
+			}
+			continue
+		} else if err != nil {
+			return set, fmt.Errorf("failed to set metafields: %w", err)
+		}
+		set = append(set, out.MetafieldsSet.Metafields...)
+	}
+	if len(errs) > 0 {
+		return set, errs
+	}
+	return set, nil
+}
+
+// DeleteMetafield deletes the metafield by its identifier, as deleting by ID
+// is deprecated.
+func (c *Client) DeleteMetafield(ctx context.Context, sess *Session, ownerGID string, namespace string, key string) error {
+	err := c.GraphQL(ctx, sess, `mutation metafieldsDelete($metafields: [MetafieldIdentifierInput!]!) {
+		metafieldsDelete(metafields: $metafields) {
+			deletedMetafields { key }
+			userErrors { field message }
+		}
+	}`, map[string]any{"metafields": []map[string]string{{"ownerId": ownerGID, "namespace": namespace, "key": key}}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete metafield: %w", err)
+	}
+	return nil
+}
+
+// metafieldIndex extracts the input index from a user error field such as
+// ["metafields", "3", "value"]. Errors not attributed to an input map to 0.
+func metafieldIndex(e UserError) int {
+	if len(e.Field) < 2 || e.Field[0] != "metafields" {
+		return 0
+	}
+	i, err := strconv.Atoi(e.Field[1])
+	if err != nil {
+		return 0
+	}
+	return i
+}
@@ -0,0 +1,93 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Error classes of RequestMetrics.
+const (
+	ErrorClassNetwork         = "network"
+	ErrorClassTimeout         = "timeout"
+	ErrorClassCanceled        = "canceled"
+	ErrorClassThrottled       = "throttled"
+	ErrorClassClient          = "client_error"
+	ErrorClassServer          = "server_error"
+	ErrorClassShopUnavailable = "shop_unavailable"
+)
+
+// RequestMetrics describes a request made by Client.Do, including all retries.
+// Endpoint is the path below the API version with IDs replaced, e.g.
+// products/:id.json, to keep metric cardinality low.
+type RequestMetrics struct {
+	Shop         string
+	Endpoint     string
+	Method       string
+	Status       int
+	Duration     time.Duration
+	Retries      int
+	ThrottleWait time.Duration
+	ErrorClass   string
+}
+
+// MetricsRecorder receives request metrics, e.g. to bridge them to Prometheus
+// or OpenTelemetry. Implementations must be safe for concurrent use.
+type MetricsRecorder interface {
+	// RecordRequest is called once per request after the last attempt.
+	RecordRequest(m RequestMetrics)
+	// RecordRetry is called for every retried attempt with the status of the
+	// failed attempt, 0 for network errors.
+	RecordRetry(shop string, endpoint string, status int)
+	// RecordThrottleWait is called whenever a GraphQL request waits for the
+	// shop's cost bucket to refill.
+	RecordThrottleWait(shop string, d time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RecordRequest(RequestMetrics)             {}
+func (noopMetrics) RecordRetry(string, string, int)          {}
+func (noopMetrics) RecordThrottleWait(string, time.Duration) {}
+
+var (
+	apiPathRegexp  = regexp.MustCompile(`^/admin/api/[^/]+/`)
+	pathIDRegexp   = regexp.MustCompile(`/\d+(/|\.json$|$)`)
+	pathIDReplacer = "/:id$1"
+)
+
+// metricsEndpoint strips the API prefix and replaces numeric IDs of path.
+func metricsEndpoint(path string) string {
+	endpoint := apiPathRegexp.ReplaceAllString(path, "")
+	// replace twice as adjacent IDs share the separating slash
+	endpoint = pathIDRegexp.ReplaceAllString(endpoint, pathIDReplacer)
+	return pathIDRegexp.ReplaceAllString(endpoint, pathIDReplacer)
+}
+
+func errorClass(resp *http.Response, err error) string {
+	if err != nil {
+		var urlErr interface{ Timeout() bool }
+		switch {
+		case errors.Is(err, context.Canceled):
+			return ErrorClassCanceled
+		case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &urlErr) && urlErr.Timeout():
+			return ErrorClassTimeout
+		case errors.Is(err, ErrShopFrozen) || errors.Is(err, ErrShopLocked):
+			return ErrorClassShopUnavailable
+		}
+		return ErrorClassNetwork
+	}
+	switch {
+	case resp == nil:
+		return ""
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return ErrorClassThrottled
+	case resp.StatusCode >= 500:
+		return ErrorClassServer
+	case resp.StatusCode >= 400:
+		return ErrorClassClient
+	}
+	return ""
+}
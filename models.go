@@ -0,0 +1,210 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// MoneyBag holds an amount in the shop's and in the customer's currency, e.g.
+// total_price_set of orders.
+type MoneyBag struct {
+	ShopMoney        Money `json:"shop_money"`
+	PresentmentMoney Money `json:"presentment_money"`
+}
+
+type Product struct {
+	ID          int             `json:"id"`
+	Title       string          `json:"title"`
+	BodyHTML    *string         `json:"body_html"`
+	Vendor      string          `json:"vendor"`
+	ProductType string          `json:"product_type"`
+	Handle      string          `json:"handle"`
+	Status      string          `json:"status"`
+	Tags        string          `json:"tags"`
+	Variants    []Variant       `json:"variants"`
+	Options     []ProductOption `json:"options"`
+	Images      []ProductImage  `json:"images"`
+	PublishedAt *time.Time      `json:"published_at"`
+	CreatedAt   *time.Time      `json:"created_at"`
+	UpdatedAt   *time.Time      `json:"updated_at"`
+}
+
+type ProductOption struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Position int      `json:"position"`
+	Values   []string `json:"values"`
+}
+
+type ProductImage struct {
+	ID         int     `json:"id"`
+	Position   int     `json:"position"`
+	Src        string  `json:"src"`
+	Alt        *string `json:"alt"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	VariantIDs []int   `json:"variant_ids"`
+}
+
+type Variant struct {
+	ID                int        `json:"id"`
+	ProductID         int        `json:"product_id"`
+	Title             string     `json:"title"`
+	Price             Money      `json:"price"`
+	CompareAtPrice    *Money     `json:"compare_at_price"`
+	SKU               *string    `json:"sku"`
+	Barcode           *string    `json:"barcode"`
+	Position          int        `json:"position"`
+	Option1           *string    `json:"option1"`
+	Option2           *string    `json:"option2"`
+	Option3           *string    `json:"option3"`
+	Taxable           bool       `json:"taxable"`
+	InventoryItemID   int        `json:"inventory_item_id"`
+	InventoryQuantity int        `json:"inventory_quantity"`
+	InventoryPolicy   string     `json:"inventory_policy"`
+	CreatedAt         *time.Time `json:"created_at"`
+	UpdatedAt         *time.Time `json:"updated_at"`
+}
+
+type Customer struct {
+	ID            int        `json:"id"`
+	Email         string     `json:"email"`
+	Phone         string     `json:"phone"`
+	FirstName     *string    `json:"first_name,omitempty"`
+	LastName      *string    `json:"last_name,omitempty"`
+	State         string     `json:"state,omitempty"`
+	Tags          string     `json:"tags,omitempty"`
+	VerifiedEmail bool       `json:"verified_email,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+type Order struct {
+	ID                int           `json:"id"`
+	Name              string        `json:"name"`
+	OrderNumber       int           `json:"order_number"`
+	Email             *string       `json:"email"`
+	Currency          string        `json:"currency"`
+	FinancialStatus   *string       `json:"financial_status"`
+	FulfillmentStatus *string       `json:"fulfillment_status"`
+	TotalPrice        Money         `json:"total_price"`
+	SubtotalPrice     Money         `json:"subtotal_price"`
+	TotalTax          Money         `json:"total_tax"`
+	TotalPriceSet     *MoneyBag     `json:"total_price_set"`
+	Customer          *Customer     `json:"customer"`
+	LineItems         []LineItem    `json:"line_items"`
+	Fulfillments      []Fulfillment `json:"fulfillments"`
+	Tags              string        `json:"tags"`
+	Test              bool          `json:"test"`
+	ProcessedAt       *time.Time    `json:"processed_at"`
+	CancelledAt       *time.Time    `json:"cancelled_at"`
+	ClosedAt          *time.Time    `json:"closed_at"`
+	CreatedAt         *time.Time    `json:"created_at"`
+	UpdatedAt         *time.Time    `json:"updated_at"`
+}
+
+type LineItem struct {
+	ID                int     `json:"id"`
+	ProductID         *int    `json:"product_id"`
+	VariantID         *int    `json:"variant_id"`
+	Title             string  `json:"title"`
+	VariantTitle      *string `json:"variant_title"`
+	SKU               *string `json:"sku"`
+	Quantity          int     `json:"quantity"`
+	Price             Money   `json:"price"`
+	FulfillmentStatus *string `json:"fulfillment_status"`
+}
+
+type Fulfillment struct {
+	ID              int        `json:"id"`
+	OrderID         int        `json:"order_id"`
+	Status          string     `json:"status"`
+	LocationID      *int       `json:"location_id"`
+	TrackingCompany *string    `json:"tracking_company"`
+	TrackingNumber  *string    `json:"tracking_number"`
+	TrackingNumbers []string   `json:"tracking_numbers"`
+	TrackingURLs    []string   `json:"tracking_urls"`
+	LineItems       []LineItem `json:"line_items"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+}
+
+func (c *Client) GetProduct(ctx context.Context, sess *Session, id int) (*Product, error) {
+	var out struct {
+		Product *Product `json:"product"`
+	}
+	if err := c.GetContext(ctx, sess, fmt.Sprintf("products/%d.json", id), &out); err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	return out.Product, nil
+}
+
+// ListProducts returns all products matching params, fetching all pages.
+func (c *Client) ListProducts(ctx context.Context, sess *Session, params url.Values) ([]Product, error) {
+	return listResources[Product](ctx, c, sess, "products.json", params)
+}
+
+func (c *Client) GetVariant(ctx context.Context, sess *Session, id int) (*Variant, error) {
+	var out struct {
+		Variant *Variant `json:"variant"`
+	}
+	if err := c.GetContext(ctx, sess, fmt.Sprintf("variants/%d.json", id), &out); err != nil {
+		return nil, fmt.Errorf("failed to get variant: %w", err)
+	}
+	return out.Variant, nil
+}
+
+func (c *Client) GetOrder(ctx context.Context, sess *Session, id int) (*Order, error) {
+	var out struct {
+		Order *Order `json:"order"`
+	}
+	if err := c.GetContext(ctx, sess, fmt.Sprintf("orders/%d.json", id), &out); err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return out.Order, nil
+}
+
+// ListOrders returns all orders matching params, fetching all pages. Shopify
+// only returns open orders unless params sets status.
+func (c *Client) ListOrders(ctx context.Context, sess *Session, params url.Values) ([]Order, error) {
+	return listResources[Order](ctx, c, sess, "orders.json", params)
+}
+
+func (c *Client) GetCustomer(ctx context.Context, sess *Session, id int) (*Customer, error) {
+	var out struct {
+		Customer *Customer `json:"customer"`
+	}
+	if err := c.GetContext(ctx, sess, fmt.Sprintf("customers/%d.json", id), &out); err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+	return out.Customer, nil
+}
+
+func (c *Client) ListCustomers(ctx context.Context, sess *Session, params url.Values) ([]Customer, error) {
+	return listResources[Customer](ctx, c, sess, "customers.json", params)
+}
+
+func (c *Client) ListFulfillments(ctx context.Context, sess *Session, orderID int) ([]Fulfillment, error) {
+	return listResources[Fulfillment](ctx, c, sess, fmt.Sprintf("orders/%d/fulfillments.json", orderID), nil)
+}
+
+func listResources[T any](ctx context.Context, c *Client, sess *Session, endpoint string, params url.Values) ([]T, error) {
+	var resources []T
+	err := c.Paginate(ctx, sess, endpoint, params, func(page []json.RawMessage) error {
+		for _, raw := range page {
+			var r T
+			if err := jsonUnmarshal(raw, &r); err != nil {
+				return fmt.Errorf("failed to decode resource: %w", err)
+			}
+			resources = append(resources, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", endpoint, err)
+	}
+	return resources, nil
+}
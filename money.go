@@ -0,0 +1,343 @@
+package shopigo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned by the arithmetic of Money in different
+// currencies.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// maxDecimalDigits is the number of digits an int64 coefficient can hold.
+const maxDecimalDigits = 18
+
+// Decimal is an exact decimal number. Shopify's decimal strings such as
+// "19.99" are parsed without the rounding errors of floats. The zero value
+// is 0.
+type Decimal struct {
+	coef  int64
+	scale int
+}
+
+// ParseDecimal parses a decimal string like "-19.99". Numbers with more than
+// 18 significant digits aren't supported.
+func ParseDecimal(s string) (Decimal, error) {
+	str := s
+	neg := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "-"), "+")
+	whole, frac, _ := strings.Cut(str, ".")
+	digits := strings.TrimLeft(whole+frac, "0")
+	if whole+frac == "" || len(digits) > maxDecimalDigits {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	var coef int64
+	for _, ch := range whole + frac {
+		if ch < '0' || ch > '9' {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		coef = coef*10 + int64(ch-'0')
+	}
+	if neg {
+		coef = -coef
+	}
+	return Decimal{coef: coef, scale: len(frac)}.normalize(), nil
+}
+
+// MustParseDecimal is ParseDecimal panicking on invalid input, for constants.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewDecimal returns the decimal units * 10^-scale, e.g. cents with scale 2.
+func NewDecimal(units int64, scale int) Decimal {
+	return Decimal{coef: units, scale: scale}.normalize()
+}
+
+// normalize strips trailing zeros, so equal numbers are equal structs.
+func (d Decimal) normalize() Decimal {
+	if d.coef == 0 {
+		return Decimal{}
+	}
+	for d.scale > 0 && d.coef%10 == 0 {
+		d.coef /= 10
+		d.scale--
+	}
+	return d
+}
+
+// rescale returns the coefficient of d at the larger scale.
+func (d Decimal) rescale(scale int) (int64, error) {
+	coef := d.coef
+	for s := d.scale; s < scale; s++ {
+		if coef > math.MaxInt64/10 || coef < math.MinInt64/10 {
+			return 0, errors.New("decimal overflow")
+		}
+		coef *= 10
+	}
+	return coef, nil
+}
+
+func (d Decimal) Add(o Decimal) (Decimal, error) {
+	scale := max(d.scale, o.scale)
+	a, err := d.rescale(scale)
+	if err != nil {
+		return Decimal{}, err
+	}
+	b, err := o.rescale(scale)
+	if err != nil {
+		return Decimal{}, err
+	}
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return Decimal{}, errors.New("decimal overflow")
+	}
+	return Decimal{coef: a + b, scale: scale}.normalize(), nil
+}
+
+func (d Decimal) Sub(o Decimal) (Decimal, error) {
+	return d.Add(o.Neg())
+}
+
+func (d Decimal) Mul(o Decimal) (Decimal, error) {
+	if d.coef != 0 && o.coef != 0 {
+		p := d.coef * o.coef
+		if p/o.coef != d.coef || (d.coef == -1 && o.coef == math.MinInt64) {
+			return Decimal{}, errors.New("decimal overflow")
+		}
+		return Decimal{coef: p, scale: d.scale + o.scale}.normalize(), nil
+	}
+	return Decimal{}, nil
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: -d.coef, scale: d.scale}
+}
+
+// Cmp returns -1, 0 or 1 if d is less than, equal to or greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	if ds, os := d.sign(), o.sign(); ds != os {
+		return cmpInt(int64(ds), int64(os))
+	}
+	// compare the integer parts, then the fractions at the same scale,
+	// which may exceed int64
+	di, df := d.split()
+	oi, of := o.split()
+	if c := cmpInt(di, oi); c != 0 {
+		return c
+	}
+	scale := max(d.scale, o.scale)
+	a := new(big.Int).Mul(big.NewInt(df), pow10(scale-d.scale))
+	b := new(big.Int).Mul(big.NewInt(of), pow10(scale-o.scale))
+	return a.Cmp(b)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) sign() int {
+	return cmpInt(d.coef, 0)
+}
+
+// split returns the integer part and the fraction digits of d, both with the
+// sign of d.
+func (d Decimal) split() (int64, int64) {
+	if d.scale > maxDecimalDigits {
+		// 10^scale exceeds every coefficient
+		return 0, d.coef
+	}
+	pow := int64(1)
+	for s := 0; s < d.scale; s++ {
+		pow *= 10
+	}
+	return d.coef / pow, d.coef % pow
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) IsZero() bool {
+	return d.coef == 0
+}
+
+// Round rounds d half away from zero to places decimal places.
+func (d Decimal) Round(places int) Decimal {
+	if d.scale <= places {
+		return d
+	}
+	coef := d.coef
+	var rem int64
+	for s := d.scale; s > places; s-- {
+		rem = coef % 10
+		coef /= 10
+	}
+	if rem >= 5 {
+		coef++
+	} else if rem <= -5 {
+		coef--
+	}
+	return Decimal{coef: coef, scale: places}.normalize()
+}
+
+// Float64 returns the nearest float, for display or statistics only.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func (d Decimal) String() string {
+	s := strconv.FormatInt(d.coef, 10)
+	if d.scale == 0 {
+		return s
+	}
+	sign := ""
+	if d.coef < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= d.scale {
+		s = strings.Repeat("0", d.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(d.String())
+}
+
+// UnmarshalJSON accepts decimal strings and numbers.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var n json.Number
+	if err := jsonUnmarshal(data, &n); err != nil {
+		return fmt.Errorf("failed to decode decimal: %w", err)
+	}
+	if n == "" {
+		*d = Decimal{}
+		return nil
+	}
+	parsed, err := ParseDecimal(string(n))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+type Money struct {
+	Amount       Decimal `json:"amount"`
+	CurrencyCode string  `json:"currencyCode"`
+}
+
+// NewMoney parses the amount in the currency, e.g. NewMoney("19.99", "USD").
+func NewMoney(amount string, currencyCode string) (Money, error) {
+	d, err := ParseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: d, CurrencyCode: currencyCode}, nil
+}
+
+// MustMoney is NewMoney panicking on invalid amounts, for constants.
+func MustMoney(amount string, currencyCode string) Money {
+	m, err := NewMoney(amount, currencyCode)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// sameCurrency checks the currencies match. Bare REST amounts have no
+// currency, they take the currency of the other operand.
+func (m Money) sameCurrency(o Money) (string, error) {
+	switch {
+	case m.CurrencyCode == o.CurrencyCode || o.CurrencyCode == "":
+		return m.CurrencyCode, nil
+	case m.CurrencyCode == "":
+		return o.CurrencyCode, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.CurrencyCode, o.CurrencyCode)
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.sameCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	sum, err := m.Amount.Add(o.Amount)
+	return Money{Amount: sum, CurrencyCode: currency}, err
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	currency, err := m.sameCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	diff, err := m.Amount.Sub(o.Amount)
+	return Money{Amount: diff, CurrencyCode: currency}, err
+}
+
+// Mul multiplies the amount by factor, e.g. a quantity or tax rate. Round
+// the result to the currency's precision before charging it.
+func (m Money) Mul(factor Decimal) (Money, error) {
+	product, err := m.Amount.Mul(factor)
+	return Money{Amount: product, CurrencyCode: m.CurrencyCode}, err
+}
+
+func (m Money) String() string {
+	if m.CurrencyCode == "" {
+		return m.Amount.String()
+	}
+	return m.Amount.String() + " " + m.CurrencyCode
+}
+
+// MarshalJSON encodes the MoneyV2 and MoneyInput form, amount as string.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(struct {
+		Amount       string `json:"amount"`
+		CurrencyCode string `json:"currencyCode,omitempty"`
+	}{m.Amount.String(), m.CurrencyCode})
+}
+
+// UnmarshalJSON decodes MoneyV2 objects, REST money objects with
+// currency_code and bare REST amounts such as "19.99", which have no currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(bytes.TrimSpace(data)) > 0 && bytes.TrimSpace(data)[0] != '{' {
+		return m.Amount.UnmarshalJSON(data)
+	}
+	var v struct {
+		Amount           Decimal `json:"amount"`
+		CurrencyCode     string  `json:"currencyCode"`
+		RESTCurrencyCode string  `json:"currency_code"`
+	}
+	if err := jsonUnmarshal(data, &v); err != nil {
+		return fmt.Errorf("failed to decode money: %w", err)
+	}
+	m.Amount = v.Amount
+	m.CurrencyCode = v.CurrencyCode
+	if m.CurrencyCode == "" {
+		m.CurrencyCode = v.RESTCurrencyCode
+	}
+	return nil
+}
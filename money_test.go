@@ -0,0 +1,83 @@
+package shopigo
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type MoneyTestSuite struct {
+	suite.Suite
+}
+
+func TestMoneyTestSuite(t *testing.T) {
+	suite.Run(t, new(MoneyTestSuite))
+}
+
+func (s *MoneyTestSuite) TestParseDecimal() {
+	for in, out := range map[string]string{
+		"19.99": "19.99", "100.0": "100", "-0.50": "-0.5", "0.07": "0.07", "+3": "3", ".5": "0.5",
+	} {
+		d, err := ParseDecimal(in)
+		s.Require().NoError(err, in)
+		s.Equal(out, d.String(), in)
+	}
+	for _, in := range []string{"", "-", "1.2.3", "1e5", "abc", "12345678901234567890"} {
+		_, err := ParseDecimal(in)
+		s.Error(err, in)
+	}
+}
+
+func (s *MoneyTestSuite) TestArithmetic() {
+	sum, err := MustMoney("0.1", "USD").Add(MustMoney("0.2", "USD"))
+	s.Require().NoError(err)
+	s.Equal(MustMoney("0.3", "USD"), sum)
+
+	diff, err := MustMoney("100.0", "USD").Sub(MustMoney("42.1", "USD"))
+	s.Require().NoError(err)
+	s.Equal("57.9 USD", diff.String())
+
+	product, err := MustMoney("19.99", "EUR").Mul(MustParseDecimal("3"))
+	s.Require().NoError(err)
+	s.Equal(MustMoney("59.97", "EUR"), product)
+	taxed, err := MustMoney("19.99", "EUR").Mul(MustParseDecimal("1.19"))
+	s.Require().NoError(err)
+	s.Equal("23.79", taxed.Amount.Round(2).String())
+
+	_, err = MustMoney("1", "USD").Add(MustMoney("1", "EUR"))
+	s.ErrorIs(err, ErrCurrencyMismatch)
+	_, err = MustMoney("1", "USD").Sub(MustMoney("1", "EUR"))
+	s.ErrorIs(err, ErrCurrencyMismatch)
+
+	s.Equal(-1, MustParseDecimal("9.99").Cmp(MustParseDecimal("10")))
+	s.Equal(0, MustParseDecimal("1.50").Cmp(MustParseDecimal("1.5")))
+	s.Equal(-1, MustParseDecimal("0.1").Cmp(MustParseDecimal("930000000000000000")), "rescaling would overflow")
+	s.Equal(1, MustParseDecimal("930000000000000000").Cmp(MustParseDecimal("0.1")))
+	s.Equal(1, MustParseDecimal("-0.1").Cmp(MustParseDecimal("-930000000000000000")))
+	s.Equal(-1, MustParseDecimal("-5").Cmp(MustParseDecimal("3")))
+	s.Equal(-1, MustParseDecimal("-1.25").Cmp(MustParseDecimal("-1.2")))
+	s.Equal(1, MustParseDecimal("0.000000000000000001").Cmp(Decimal{}))
+	s.Equal(-1, NewDecimal(1, 30).Cmp(MustParseDecimal("0.01")))
+	s.Equal(1, NewDecimal(5, 1).Cmp(NewDecimal(4999999999999999, 16)))
+}
+
+func (s *MoneyTestSuite) TestJSON() {
+	var v struct {
+		V2   Money  `json:"v2"`
+		REST Money  `json:"rest"`
+		Bare Money  `json:"bare"`
+		Num  Money  `json:"num"`
+		Null *Money `json:"null"`
+	}
+	s.Require().NoError(json.Unmarshal([]byte(`{"v2":{"amount":"19.99","currencyCode":"USD"},
+		"rest":{"amount":"5.00","currency_code":"CAD"},"bare":"199.65","num":12.5,"null":null}`), &v))
+	s.Equal(MustMoney("19.99", "USD"), v.V2)
+	s.Equal(MustMoney("5", "CAD"), v.REST)
+	s.Equal(MustMoney("199.65", ""), v.Bare)
+	s.Equal(MustMoney("12.5", ""), v.Num)
+	s.Nil(v.Null)
+
+	out, err := json.Marshal(MustMoney("19.99", "USD"))
+	s.Require().NoError(err)
+	s.JSONEq(`{"amount":"19.99","currencyCode":"USD"}`, string(out))
+}
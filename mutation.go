@@ -0,0 +1,153 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Nullable is an optional mutation input field. A nil *Nullable is omitted,
+// leaving the field unchanged, Null clears the field and Set sets it.
+type Nullable[T any] struct {
+	value T
+	null  bool
+}
+
+// Set returns a field set to v.
+func Set[T any](v T) *Nullable[T] {
+	return &Nullable[T]{value: v}
+}
+
+// Null returns a field sent as null, clearing it.
+func Null[T any]() *Nullable[T] {
+	return &Nullable[T]{null: true}
+}
+
+// Value returns the value of the field and false if it's null.
+func (n Nullable[T]) Value() (T, bool) {
+	return n.value, !n.null
+}
+
+func (n Nullable[T]) MarshalJSON() ([]byte, error) {
+	if n.null {
+		return []byte("null"), nil
+	}
+	return jsonMarshal(n.value)
+}
+
+type ProductSEOInput struct {
+	Title       *Nullable[string] `json:"title,omitempty"`
+	Description *Nullable[string] `json:"description,omitempty"`
+}
+
+// ProductInput is the ProductCreateInput and ProductUpdateInput of API
+// version 2024-10 and later. ID is only set for updates.
+type ProductInput struct {
+	ID              string              `json:"id,omitempty"`
+	Title           *Nullable[string]   `json:"title,omitempty"`
+	DescriptionHTML *Nullable[string]   `json:"descriptionHtml,omitempty"`
+	Handle          *Nullable[string]   `json:"handle,omitempty"`
+	Vendor          *Nullable[string]   `json:"vendor,omitempty"`
+	ProductType     *Nullable[string]   `json:"productType,omitempty"`
+	Status          *Nullable[string]   `json:"status,omitempty"`
+	Tags            *Nullable[[]string] `json:"tags,omitempty"`
+	TemplateSuffix  *Nullable[string]   `json:"templateSuffix,omitempty"`
+	SEO             *ProductSEOInput    `json:"seo,omitempty"`
+}
+
+type ProductResult struct {
+	ID     string   `json:"id"`
+	Title  string   `json:"title"`
+	Handle string   `json:"handle"`
+	Status string   `json:"status"`
+	Tags   []string `json:"tags"`
+}
+
+// CustomerInput updates the customer with ID.
+type CustomerInput struct {
+	ID        string              `json:"id"`
+	Email     *Nullable[string]   `json:"email,omitempty"`
+	Phone     *Nullable[string]   `json:"phone,omitempty"`
+	FirstName *Nullable[string]   `json:"firstName,omitempty"`
+	LastName  *Nullable[string]   `json:"lastName,omitempty"`
+	Note      *Nullable[string]   `json:"note,omitempty"`
+	Tags      *Nullable[[]string] `json:"tags,omitempty"`
+}
+
+type CustomerResult struct {
+	ID        string   `json:"id"`
+	Email     *string  `json:"email"`
+	Phone     *string  `json:"phone"`
+	FirstName *string  `json:"firstName"`
+	LastName  *string  `json:"lastName"`
+	Note      *string  `json:"note"`
+	Tags      []string `json:"tags"`
+}
+
+const (
+	productResultFields  = `id title handle status tags`
+	customerResultFields = `id email phone firstName lastName note tags`
+)
+
+func (c *Client) ProductCreate(ctx context.Context, sess *Session, in ProductInput) (*ProductResult, error) {
+	if in.ID != "" {
+		return nil, errors.New("product to create must not have an ID")
+	}
+	var out struct {
+		ProductCreate struct {
+			Product *ProductResult `json:"product"`
+		} `json:"productCreate"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation productCreate($product: ProductCreateInput!) {
+		productCreate(product: $product) {
+			product { `+productResultFields+` }
+			userErrors { field message }
+		}
+	}`, map[string]any{"product": in}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create product: %w", err)
+	}
+	return out.ProductCreate.Product, nil
+}
+
+func (c *Client) ProductUpdate(ctx context.Context, sess *Session, in ProductInput) (*ProductResult, error) {
+	if in.ID == "" {
+		return nil, errors.New("product to update has no ID")
+	}
+	var out struct {
+		ProductUpdate struct {
+			Product *ProductResult `json:"product"`
+		} `json:"productUpdate"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation productUpdate($product: ProductUpdateInput!) {
+		productUpdate(product: $product) {
+			product { `+productResultFields+` }
+			userErrors { field message }
+		}
+	}`, map[string]any{"product": in}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update product %s: %w", in.ID, err)
+	}
+	return out.ProductUpdate.Product, nil
+}
+
+func (c *Client) CustomerUpdate(ctx context.Context, sess *Session, in CustomerInput) (*CustomerResult, error) {
+	if in.ID == "" {
+		return nil, errors.New("customer to update has no ID")
+	}
+	var out struct {
+		CustomerUpdate struct {
+			Customer *CustomerResult `json:"customer"`
+		} `json:"customerUpdate"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation customerUpdate($input: CustomerInput!) {
+		customerUpdate(input: $input) {
+			customer { `+customerResultFields+` }
+			userErrors { field message }
+		}
+	}`, map[string]any{"input": in}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update customer %s: %w", in.ID, err)
+	}
+	return out.CustomerUpdate.Customer, nil
+}
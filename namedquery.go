@@ -0,0 +1,61 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// queryRegistry holds the named queries of a client, shared by the clients
+// bound to shops.
+type queryRegistry struct {
+	mu      sync.RWMutex
+	queries map[string]namedQuery
+}
+
+type namedQuery struct {
+	query string
+	cost  int
+}
+
+func newQueryRegistry() *queryRegistry {
+	return &queryRegistry{queries: map[string]namedQuery{}}
+}
+
+// RegisterQuery registers query under name for RunNamed. The query is parsed
+// to estimate its cost, registering an unparsable query fails. Registering a
+// name again replaces its query.
+func (c *Client) RegisterQuery(name string, query string) error {
+	cost, err := EstimateCost(query)
+	if err != nil {
+		return fmt.Errorf("invalid query %s: %w", name, err)
+	}
+	c.queries.mu.Lock()
+	defer c.queries.mu.Unlock()
+	c.queries.queries[name] = namedQuery{query: query, cost: cost}
+	return nil
+}
+
+// RunNamed runs the query registered under name like GraphQL. The full query
+// is sent, Shopify doesn't support persisted queries.
+func (c *Client) RunNamed(ctx context.Context, sess *Session, name string, vars map[string]any, out any) error {
+	q, ok := c.namedQuery(name)
+	if !ok {
+		return fmt.Errorf("unknown query %s", name)
+	}
+	return c.GraphQL(ctx, sess, q.query, vars, out)
+}
+
+// NamedQueryCost returns the estimated cost of the query registered under
+// name, see EstimateCost.
+func (c *Client) NamedQueryCost(name string) (int, bool) {
+	q, ok := c.namedQuery(name)
+	return q.cost, ok
+}
+
+func (c *Client) namedQuery(name string) (namedQuery, bool) {
+	c.queries.mu.RLock()
+	defer c.queries.mu.RUnlock()
+	q, ok := c.queries.queries[name]
+	return q, ok
+}
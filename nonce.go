@@ -0,0 +1,82 @@
+package shopigo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultNonceTTL = 10 * time.Minute
+
+var ErrInvalidNonce = errors.New("invalid oauth state")
+
+// NonceStore keeps the state parameter between the begin and callback request
+// of the OAuth flow. Verify must consume the nonce so a callback can't be
+// replayed.
+type NonceStore interface {
+	Set(c *gin.Context, shop string, nonce string, expires time.Time) error
+	Verify(c *gin.Context, shop string, nonce string) error
+}
+
+type SignedCookieNonceStore struct {
+	secret  string
+	path    string
+	cookies CookieOptions
+}
+
+func NewSignedCookieNonceStore(secret string, path string) *SignedCookieNonceStore {
+	return &SignedCookieNonceStore{secret: secret, path: path, cookies: CookieOptions{Secure: true}}
+}
+
+func (s *SignedCookieNonceStore) Set(c *gin.Context, _ string, nonce string, expires time.Time) error {
+	val := fmt.Sprintf("%s.%d", nonce, expires.Unix())
+	s.cookies.setSigned(c, s.secret, AppStateCookie, val, s.path, &expires)
+	return nil
+}
+
+func (s *SignedCookieNonceStore) Verify(c *gin.Context, _ string, nonce string) error {
+	defer s.delete(c)
+	if nonce == "" {
+		return fmt.Errorf("%w: missing state", ErrInvalidNonce)
+	}
+	if err := ValidateCookieSignature(c, s.secret, s.cookies.name(AppStateCookie)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidNonce, err)
+	}
+	val, err := c.Cookie(s.cookies.name(AppStateCookie))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidNonce, err)
+	}
+	stored, exp, ok := strings.Cut(val, ".")
+	if !ok {
+		return fmt.Errorf("%w: malformed state cookie", ErrInvalidNonce)
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed state cookie", ErrInvalidNonce)
+	}
+	if time.Now().After(time.Unix(expires, 0)) {
+		return fmt.Errorf("%w: state expired", ErrInvalidNonce)
+	}
+	if !hmac.Equal([]byte(stored), []byte(nonce)) {
+		return fmt.Errorf("%w: state mismatch", ErrInvalidNonce)
+	}
+	return nil
+}
+
+func (s *SignedCookieNonceStore) delete(c *gin.Context) {
+	s.cookies.delete(c, s.path, AppStateCookie, AppStateCookieSig)
+}
+
+func newNonce() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
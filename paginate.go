@@ -0,0 +1,173 @@
+package shopigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrStopPagination can be returned from a pagination callback to stop
+	// fetching further pages without failing.
+	ErrStopPagination = errors.New("stop pagination")
+	// ErrTooManyResults is returned by ListAll if the connection has more
+	// nodes than allowed.
+	ErrTooManyResults = errors.New("too many results")
+)
+
+// defaultListAllLimit is the number of nodes ListAll collects if no limit is
+// given.
+const defaultListAllLimit = 10000
+
+func (c *Client) Paginate(ctx context.Context, sess *Session, endpoint string, params url.Values, fn func(page []json.RawMessage) error) error {
+	sess = c.sessionOr(sess)
+	next := c.ShopURL(sess.Shop, endpoint)
+	if len(params) > 0 {
+		next += "?" + params.Encode()
+	}
+	for n := 1; next != ""; n++ {
+		page, link, err := c.fetchPage(ctx, sess, next)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", n, err)
+		}
+		if err = fn(page); errors.Is(err, ErrStopPagination) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("page %d: %w", n, err)
+		}
+		next = withLimit(nextPageURL(link), params.Get("limit"))
+	}
+	return nil
+}
+
+func (c *Client) fetchPage(ctx context.Context, sess *Session, u string) ([]json.RawMessage, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.For(sess)(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, "", newShopifyAPIError(resp)
+	}
+	var body map[string]json.RawMessage
+	if err = decodeJSON(resp.Body, &body); err != nil {
+		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	// list responses wrap the resources in a single key, e.g. {"products": [...]}
+	for _, raw := range body {
+		var page []json.RawMessage
+		if err = jsonUnmarshal(raw, &page); err == nil {
+			return page, resp.Header.Get("Link"), nil
+		}
+	}
+	return nil, "", errors.New("response doesn't contain a list of resources")
+}
+
+// nextPageURL extracts the rel="next" target of a Link header such as
+// <https://...?page_info=abc>; rel="previous", <https://...?page_info=def>; rel="next"
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(part, ";")
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func withLimit(next string, limit string) string {
+	if next == "" || limit == "" {
+		return next
+	}
+	u, err := url.Parse(next)
+	if err != nil {
+		return next
+	}
+	q := u.Query()
+	if q.Get("limit") == "" {
+		q.Set("limit", limit)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// CursorVariable returns a cursor setter for PaginateGraphQL setting the
+// variable name.
+func CursorVariable(name string) func(vars map[string]any, cursor string) {
+	return func(vars map[string]any, cursor string) {
+		vars[name] = cursor
+	}
+}
+
+// PaginateGraphQL runs the query once per page of a connection and calls each
+// for every node. extract returns the nodes and page info of the connection
+// from the response data. setCursor sets the cursor of the next page in the
+// variables, nil sets $after. each can return ErrStopPagination to stop early.
+func PaginateGraphQL[T any](ctx context.Context, c *Client, sess *Session, query string, vars map[string]any, setCursor func(vars map[string]any, cursor string), extract func(data json.RawMessage) ([]T, PageInfo, error), each func(node T) error) error {
+	if setCursor == nil {
+		setCursor = CursorVariable("after")
+	}
+	// copied, so the caller's variables don't get the cursor
+	pageVars := make(map[string]any, len(vars)+1)
+	for k, v := range vars {
+		pageVars[k] = v
+	}
+	for n := 1; ; n++ {
+		var data json.RawMessage
+		if err := c.GraphQL(ctx, sess, query, pageVars, &data); err != nil {
+			return fmt.Errorf("page %d: %w", n, err)
+		}
+		nodes, pageInfo, err := extract(data)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", n, err)
+		}
+		for _, node := range nodes {
+			if err = each(node); errors.Is(err, ErrStopPagination) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("page %d: %w", n, err)
+			}
+		}
+		if !pageInfo.HasNextPage {
+			return nil
+		}
+		if pageInfo.EndCursor == "" {
+			return fmt.Errorf("page %d: next page without end cursor, is endCursor queried?", n)
+		}
+		setCursor(pageVars, pageInfo.EndCursor)
+	}
+}
+
+// ListAll collects the nodes of all pages of a connection, see
+// PaginateGraphQL. The query has to take the cursor as $after. More than limit
+// nodes fail with ErrTooManyResults, a limit of 0 allows 10000.
+func ListAll[T any](ctx context.Context, c *Client, sess *Session, query string, vars map[string]any, extract func(data json.RawMessage) ([]T, PageInfo, error), limit int) ([]T, error) {
+	if limit <= 0 {
+		limit = defaultListAllLimit
+	}
+	var all []T
+	err := PaginateGraphQL(ctx, c, sess, query, vars, nil, extract, func(node T) error {
+		if len(all) == limit {
+			return fmt.Errorf("%w: more than %d nodes, use a bulk operation for large result sets", ErrTooManyResults, limit)
+		}
+		all = append(all, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
@@ -0,0 +1,183 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+type RedisSessionStore struct {
+	client redis.UniversalClient
+	prefix string
+	closer closeOnce
+}
+
+func NewRedisSessionStore(client redis.UniversalClient, prefix string) *RedisSessionStore {
+	return &RedisSessionStore{client: client, prefix: prefix}
+}
+
+func (r *RedisSessionStore) Get(ctx context.Context, id SessionID) (*Session, error) {
+	bs, err := r.client.Get(ctx, r.key(id.String())).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	var sess Session
+	if err = jsonUnmarshal(bs, &sess); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &sess, nil
+}
+
+func (r *RedisSessionStore) Store(ctx context.Context, session *Session) error {
+	var ttl time.Duration
+	if session.Expires != nil {
+		if ttl = time.Until(*session.Expires); ttl <= 0 {
+			return r.Delete(ctx, session.SessionID())
+		}
+	}
+	bs, err := jsonMarshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err = r.client.Set(ctx, r.key(session.ID), bs, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisSessionStore) Delete(ctx context.Context, id SessionID) error {
+	if err := r.client.Del(ctx, r.key(id.String())).Err(); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// DeleteShop deletes the shop's offline session and the online sessions keyed
+// by shop and user. Online sessions of non-embedded apps use random IDs and
+// are left to expire. On a cluster every master is scanned and the sessions are
+// deleted key by key, as they are spread over slots.
+func (r *RedisSessionStore) DeleteShop(ctx context.Context, shop string) error {
+	keys := []string{r.key(GetOfflineSessionID(shop))}
+	var mu sync.Mutex
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, r.key(GetOnlineSessionID(shop, "*")), 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	var err error
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, r.client)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisSessionStore) RecordInstall(ctx context.Context, shop string) (bool, error) {
+	ok, err := r.client.SetNX(ctx, r.key("installed_"+shop), 1, 0).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record install: %w", err)
+	}
+	return ok, nil
+}
+
+// Close closes the Redis client.
+func (r *RedisSessionStore) Close(_ context.Context) error {
+	return r.closer.close(r.client.Close)
+}
+
+func (r *RedisSessionStore) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisSessionStore) key(id string) string {
+	return r.prefix + id
+}
+
+func (r *RedisSessionStore) SeenBefore(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	ok, err := r.client.SetNX(ctx, r.key("webhook_"+id), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook: %w", err)
+	}
+	return !ok, nil
+}
+
+func (r *RedisSessionStore) Seen(ctx context.Context, id string) (bool, error) {
+	n, err := r.client.Exists(ctx, r.key("webhook_"+id)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check webhook: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (r *RedisSessionStore) Forget(ctx context.Context, id string) error {
+	if err := r.client.Del(ctx, r.key("webhook_"+id)).Err(); err != nil {
+		return fmt.Errorf("failed to forget webhook: %w", err)
+	}
+	return nil
+}
+
+// RedisShopInfoCache is a ShopInfoCache shared by the instances of an app.
+type RedisShopInfoCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisShopInfoCache(client redis.UniversalClient, prefix string) *RedisShopInfoCache {
+	return &RedisShopInfoCache{client: client, prefix: prefix}
+}
+
+func (r *RedisShopInfoCache) Get(ctx context.Context, shop string) (*ShopInfo, bool, error) {
+	bs, err := r.client.Get(ctx, r.prefix+shop).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to get shop info: %w", err)
+	}
+	var info ShopInfo
+	if err = jsonUnmarshal(bs, &info); err != nil {
+		return nil, false, fmt.Errorf("failed to decode shop info: %w", err)
+	}
+	return &info, true, nil
+}
+
+func (r *RedisShopInfoCache) Set(ctx context.Context, shop string, info *ShopInfo, ttl time.Duration) error {
+	bs, err := jsonMarshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode shop info: %w", err)
+	}
+	if err = r.client.Set(ctx, r.prefix+shop, bs, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache shop info: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisShopInfoCache) Invalidate(ctx context.Context, shop string) error {
+	if err := r.client.Del(ctx, r.prefix+shop).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate shop info: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package shopigo
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type RedisTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	store  *RedisSessionStore
+}
+
+func TestRedisTestSuite(t *testing.T) {
+	suite.Run(t, new(RedisTestSuite))
+}
+
+func (s *RedisTestSuite) SetupTest() {
+	s.server = miniredis.RunT(s.T())
+	s.store = NewRedisSessionStore(redis.NewClient(&redis.Options{Addr: s.server.Addr()}), "shopigo:")
+}
+
+func (s *RedisTestSuite) TestRoundTrip() {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	sess := &Session{
+		ID:          GetOnlineSessionID("test.myshopify.com", "42"),
+		Shop:        "test.myshopify.com",
+		State:       "state",
+		IsOnline:    true,
+		UserID:      42,
+		AccessToken: "token",
+		Scopes:      "read_orders,read_products",
+		Expires:     &expires,
+		OnlineAccessInfo: &OnlineAccessInfo{
+			Exp:       3600,
+			UserScope: "read_products",
+			User:      &User{ID: 42, FirstName: "John", LastName: "Doe", Email: "john@example.com"},
+		},
+	}
+	s.Require().NoError(s.store.Store(ctx, sess))
+	s.True(s.server.Exists("shopigo:" + sess.ID))
+	s.InDelta(time.Hour, s.server.TTL("shopigo:"+sess.ID), float64(time.Second))
+
+	got, err := s.store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.True(expires.Equal(*got.Expires))
+	got.Expires = sess.Expires
+	s.Equal(sess, got)
+
+	s.Require().NoError(s.store.Delete(ctx, sess.SessionID()))
+	_, err = s.store.Get(ctx, sess.SessionID())
+	s.True(IsNotFound(err))
+}
+
+func (s *RedisTestSuite) TestOfflineSessionHasNoTTL() {
+	ctx := context.Background()
+	sess := &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com", AccessToken: "token"}
+	s.Require().NoError(s.store.Store(ctx, sess))
+	s.Zero(s.server.TTL("shopigo:" + sess.ID))
+}
+
+func (s *RedisTestSuite) TestNotFound() {
+	_, err := s.store.Get(context.Background(), OpaqueSessionID("unknown"))
+	s.True(IsNotFound(err))
+}
+
+func (s *RedisTestSuite) TestSeenBefore() {
+	ctx := context.Background()
+	seen, err := s.store.Seen(ctx, "webhook-1")
+	s.Require().NoError(err)
+	s.False(seen)
+	seen, err = s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen)
+	seen, err = s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.True(seen)
+	seen, err = s.store.Seen(ctx, "webhook-1")
+	s.Require().NoError(err)
+	s.True(seen)
+
+	s.server.FastForward(time.Minute)
+	seen, err = s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen)
+}
+
+func (s *RedisTestSuite) TestRecordInstall() {
+	ctx := context.Background()
+	first, err := s.store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.True(first)
+	s.Require().NoError(s.store.DeleteShop(ctx, "test.myshopify.com"))
+	first, err = s.store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.False(first, "installs must be remembered after uninstall")
+}
+
+func (s *RedisTestSuite) TestDeleteShop() {
+	ctx := context.Background()
+	shop := "test.myshopify.com"
+	for _, sess := range []*Session{
+		{ID: GetOfflineSessionID(shop), Shop: shop},
+		{ID: GetOnlineSessionID(shop, "42"), Shop: shop, IsOnline: true},
+		{ID: GetOfflineSessionID("other.myshopify.com"), Shop: "other.myshopify.com"},
+	} {
+		s.Require().NoError(s.store.Store(ctx, sess))
+	}
+	s.Require().NoError(s.store.DeleteShop(ctx, shop))
+	s.Equal([]string{"shopigo:" + GetOfflineSessionID("other.myshopify.com")}, s.server.Keys())
+	s.NoError(s.store.DeleteShop(ctx, "unknown.myshopify.com"))
+}
+
+func (s *RedisTestSuite) TestDeleteShopCluster() {
+	ctx := context.Background()
+	store := NewRedisSessionStore(redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{s.server.Addr()}}), "shopigo:")
+	shop := "test.myshopify.com"
+	for _, sess := range []*Session{
+		{ID: GetOfflineSessionID(shop), Shop: shop},
+		{ID: GetOnlineSessionID(shop, "42"), Shop: shop, IsOnline: true},
+		{ID: GetOnlineSessionID(shop, "43"), Shop: shop, IsOnline: true},
+		{ID: GetOfflineSessionID("other.myshopify.com"), Shop: "other.myshopify.com"},
+	} {
+		s.Require().NoError(store.Store(ctx, sess))
+	}
+	s.Require().NoError(store.DeleteShop(ctx, shop))
+	s.Equal([]string{"shopigo:" + GetOfflineSessionID("other.myshopify.com")}, s.server.Keys())
+}
+
+func (s *RedisTestSuite) TestHealthHandler() {
+	app, err := NewApp(testAppConfig(), WithSessionStore(s.store))
+	s.Require().NoError(err)
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/healthz", app.HealthHandler())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusOK, rec.Code)
+
+	s.server.Close()
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusServiceUnavailable, rec.Code)
+}
+
+func (s *RedisTestSuite) TestClose() {
+	app, err := NewApp(testAppConfig(), WithSessionStore(s.store), WithWebhookDedup(s.store, time.Hour))
+	s.Require().NoError(err)
+	s.NoError(app.Close(context.Background()))
+	s.NoError(app.Close(context.Background()), "close must be idempotent")
+	s.Error(s.store.Ping(context.Background()))
+}
+
+func (s *RedisTestSuite) TestShopInfoCache() {
+	ctx := context.Background()
+	cache := NewRedisShopInfoCache(redis.NewClient(&redis.Options{Addr: s.server.Addr()}), "shopigo:info:")
+	_, ok, err := cache.Get(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.False(ok)
+
+	info := &ShopInfo{Name: "Test", CurrencyCode: "EUR"}
+	s.Require().NoError(cache.Set(ctx, "test.myshopify.com", info, time.Minute))
+	s.InDelta(time.Minute, s.server.TTL("shopigo:info:test.myshopify.com"), float64(time.Second))
+	got, ok, err := cache.Get(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.True(ok)
+	s.Equal(info, got)
+
+	s.Require().NoError(cache.Invalidate(ctx, "test.myshopify.com"))
+	_, ok, err = cache.Get(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.False(ok)
+}
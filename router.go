@@ -0,0 +1,178 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "log/slog"
+	"net/http"
+)
+
+const (
+	XTopicHeader     = "X-Shopify-Topic"
+	XWebhookIDHeader = "X-Shopify-Webhook-Id"
+)
+
+// TopicAppScopesUpdate webhooks invalidate the cached client and shop info of
+// the shop before they are dispatched.
+const TopicAppScopesUpdate = "app/scopes_update"
+
+type WebhookContext struct {
+	Shop           string
+	Topic          string
+	WebhookID      string
+	DeliveryMethod DeliveryMethod
+	// APIVersion is the version the payload is shaped for, that of the
+	// webhook subscription.
+	APIVersion Version
+	// Body is the raw body as signed by Shopify, decode it according to
+	// ContentType.
+	Body        []byte
+	ContentType string
+	// Client is bound to the offline session of the shop, set by the
+	// WebhookRouter before calling the handler. It's nil and HasSession false
+	// if the shop has no session, e.g. because it uninstalled the app.
+	Client     *Client
+	HasSession bool
+}
+
+// WebhookVersionHandler is called for webhooks of an API version older than
+// the app's, see WithWebhookVersionCheck.
+type WebhookVersionHandler func(ctx context.Context, wh *WebhookContext, expected Version)
+
+// WebhookHandler handles a webhook. Failed webhooks are acknowledged, so
+// Shopify doesn't retry deliveries which fail permanently, unless the error is
+// a RetryLaterError.
+type WebhookHandler func(ctx context.Context, wh *WebhookContext) error
+
+// RetryLaterError makes the WebhookRouter respond with 429 Too Many Requests,
+// so the webhook is delivered again, e.g. while a database is down. Shopify
+// retries failed deliveries with exponential backoff for a few hours, 8 times
+// within 4 hours at the time of writing, and may remove subscriptions whose
+// deliveries keep failing. Webhooks which can't be processed after that are
+// lost, reconcile them with the Admin API if they matter.
+type RetryLaterError struct {
+	Err error
+}
+
+// RetryLater wraps err in a RetryLaterError.
+func RetryLater(err error) error {
+	return &RetryLaterError{Err: err}
+}
+
+func (e *RetryLaterError) Error() string {
+	if e.Err == nil {
+		return "retry later"
+	}
+	return "retry later: " + e.Err.Error()
+}
+
+func (e *RetryLaterError) Unwrap() error {
+	return e.Err
+}
+
+// handlerError is a failure of the webhook handler, as opposed to the
+// router's own failures which are always retried.
+type handlerError struct {
+	topic string
+	err   error
+}
+
+func (e *handlerError) Error() string {
+	return fmt.Sprintf("webhook handler for %s failed: %v", e.topic, e.err)
+}
+
+func (e *handlerError) Unwrap() error {
+	return e.err
+}
+
+type WebhookRouter struct {
+	app      *App
+	handlers map[string]WebhookHandler
+}
+
+func (a *App) NewWebhookRouter() *WebhookRouter {
+	return &WebhookRouter{app: a, handlers: map[string]WebhookHandler{}}
+}
+
+func (r *WebhookRouter) On(topic string, h WebhookHandler) *WebhookRouter {
+	r.handlers[topic] = h
+	return r
+}
+
+// Handle verifies the webhook and dispatches it to the handler registered for
+// its topic. Webhooks without a handler are acknowledged so Shopify doesn't
+// retry the delivery.
+func (r *WebhookRouter) Handle(c *gin.Context) {
+	r.app.verifyWebhook(c)
+	if c.IsAborted() {
+		return
+	}
+	wh := &WebhookContext{
+		Shop:           c.GetHeader(XDomainHeader),
+		Topic:          c.GetHeader(XTopicHeader),
+		WebhookID:      c.GetHeader(XWebhookIDHeader),
+		DeliveryMethod: DeliveryHTTP,
+		APIVersion:     Version(c.GetHeader(XAPIVersionHeader)),
+		Body:           WebhookBody(c),
+		ContentType:    c.ContentType(),
+	}
+	logger := r.app.logger(c).With(log.String("shop", wh.Shop), log.String("topic", wh.Topic),
+		log.String("webhook", wh.WebhookID))
+	if _, ok := r.handlers[wh.Topic]; !ok {
+		logger.Info("no handler registered for webhook topic")
+	}
+	r.respond(c, wh, r.Dispatch(c.Request.Context(), wh))
+}
+
+// respond translates the result of dispatching wh into the status for
+// Shopify or the queue: 429 to retry later, 500 for failures of the router
+// and 200 otherwise, also for failed handlers. Webhooks answered with an
+// error are dropped from the dedup store, so their retry isn't skipped.
+func (r *WebhookRouter) respond(c *gin.Context, wh *WebhookContext, err error) {
+	var retry *RetryLaterError
+	var handlerErr *handlerError
+	switch {
+	case err == nil:
+		c.Status(http.StatusOK)
+	case errors.As(err, &retry):
+		r.app.forgetWebhook(c.Request.Context(), wh.WebhookID)
+		_ = c.AbortWithError(http.StatusTooManyRequests, err)
+	case errors.As(err, &handlerErr):
+		r.app.logger(c).Error("webhook handler failed, acknowledging it", log.String("shop", wh.Shop),
+			log.String("topic", wh.Topic), log.String("webhook", wh.WebhookID), log.Any("error", err))
+		_ = c.Error(err)
+		c.Status(http.StatusOK)
+	default:
+		r.app.forgetWebhook(c.Request.Context(), wh.WebhookID)
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
+func (r *WebhookRouter) Dispatch(ctx context.Context, wh *WebhookContext) error {
+	if h := r.app.webhookVersionHandler; h != nil && wh.APIVersion != "" && wh.APIVersion < r.app.v {
+		h(ctx, wh, r.app.v)
+	}
+	if wh.Topic == TopicAppScopesUpdate {
+		if err := r.app.invalidateShop(ctx, wh.Shop); err != nil {
+			return err
+		}
+	}
+	h, ok := r.handlers[wh.Topic]
+	if !ok {
+		return nil
+	}
+	if wh.Client == nil && wh.Shop != "" {
+		client, err := r.app.ClientFor(ctx, wh.Shop)
+		if err != nil && !IsNotFound(err) {
+			return fmt.Errorf("failed to get client for webhook of %s: %w", wh.Shop, err)
+		}
+		wh.Client = client
+	}
+	wh.HasSession = wh.Client != nil
+	if err := h(ctx, wh); err != nil {
+		return &handlerError{topic: wh.Topic, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package shopigo
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Scopes is a set of access scopes such as read_products, sorted unless
+// parsed by ParseScopesUnsorted.
+type Scopes []string
+
+// ParseScopes parses a comma separated list of scopes as granted by Shopify.
+// Whitespace and duplicates are removed.
+func ParseScopes(s string) Scopes {
+	scopes := ParseScopesUnsorted(s)
+	sort.Strings(scopes)
+	return scopes
+}
+
+// ParseScopesUnsorted is ParseScopes keeping the order of the scopes.
+func ParseScopesUnsorted(s string) Scopes {
+	seen := map[string]bool{}
+	scopes := Scopes{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Has reports whether scope is granted, directly or implied. Write access
+// implies read access to the same resource.
+func (s Scopes) Has(scope string) bool {
+	for _, granted := range s {
+		if granted == scope || impliedScope(granted) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether both contain the same scopes, regardless of order.
+func (s Scopes) Equal(other Scopes) bool {
+	a, b := ParseScopes(s.String()), ParseScopes(other.String())
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Missing returns the scopes in required not covered by s.
+func (s Scopes) Missing(required Scopes) Scopes {
+	var missing Scopes
+	for _, scope := range required {
+		if !s.Has(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// orderedBy returns s in the order of the scopes in order, followed by the
+// scopes not in order.
+func (s Scopes) orderedBy(order Scopes) Scopes {
+	ordered := Scopes{}
+	for _, scope := range order {
+		if slices.Contains(s, scope) {
+			ordered = append(ordered, scope)
+		}
+	}
+	for _, scope := range s {
+		if !slices.Contains(order, scope) {
+			ordered = append(ordered, scope)
+		}
+	}
+	return ordered
+}
+
+func (s Scopes) String() string {
+	return strings.Join(s, ",")
+}
+
+func impliedScope(scope string) string {
+	if resource, ok := strings.CutPrefix(scope, "write_"); ok {
+		return "read_" + resource
+	}
+	if resource, ok := strings.CutPrefix(scope, "unauthenticated_write_"); ok {
+		return "unauthenticated_read_" + resource
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// ScopesUpdatePayload is the payload of the app/scopes_update webhook, sent
+// when the merchant grants or revokes optional or declarative scopes.
+type ScopesUpdatePayload struct {
+	ID        int       `json:"id"`
+	Previous  []string  `json:"previous"`
+	Current   []string  `json:"current"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HandleScopesUpdate records the currently granted scopes in the offline
+// session of the shop, so the scopes check doesn't trigger OAuth again for
+// scopes Shopify already updated. Register it on a WebhookRouter for
+// app/scopes_update or use ScopesUpdateHandler.
+func (a *App) HandleScopesUpdate(ctx context.Context, wh *WebhookContext) error {
+	var p ScopesUpdatePayload
+	if err := jsonUnmarshal(wh.Body, &p); err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", wh.Topic, err)
+	}
+	sess, err := a.SessionStore.Get(ctx, OfflineSessionID(wh.Shop))
+	if IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return RetryLater(fmt.Errorf("failed to get session of %s: %w", wh.Shop, err))
+	}
+	scopes := ParseScopesUnsorted(Scopes(p.Current).String())
+	if a.unsortedScopes {
+		scopes = scopes.orderedBy(a.scopes)
+	} else {
+		scopes = ParseScopes(scopes.String())
+	}
+	sess = copySession(sess)
+	sess.Scopes = scopes.String()
+	if err = a.SessionStore.Store(ctx, sess); err != nil {
+		return RetryLater(fmt.Errorf("failed to store session of %s: %w", wh.Shop, err))
+	}
+	return nil
+}
+
+// ScopesUpdateHandler verifies and handles the app/scopes_update webhook.
+func (a *App) ScopesUpdateHandler(c *gin.Context) {
+	a.NewWebhookRouter().On(TopicAppScopesUpdate, a.HandleScopesUpdate).Handle(c)
+}
@@ -5,6 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,55 +17,347 @@ type Session struct {
 	Shop             string
 	State            string
 	IsOnline         bool
+	UserID           int
 	AccessToken      string
 	Scopes           string
+	RequestedScopes  string
 	Expires          *time.Time
 	OnlineAccessInfo *OnlineAccessInfo
+	// Metadata holds small app specific values of the shop, e.g. flags. It's
+	// kept when the app is reinstalled. Stores not supporting it drop it.
+	Metadata map[string]string
 }
 
+// SessionID returns the ID of the session, structured if ID is the offline
+// or online ID of the shop and user, opaque otherwise.
+func (s *Session) SessionID() SessionID {
+	switch {
+	case !s.IsOnline && s.ID == GetOfflineSessionID(s.Shop):
+		return OfflineSessionID(s.Shop)
+	case s.IsOnline && s.ID == GetOnlineSessionID(s.Shop, strconv.Itoa(s.UserID)):
+		return OnlineSessionID(s.Shop, strconv.Itoa(s.UserID))
+	}
+	return OpaqueSessionID(s.ID)
+}
+
+// SessionStore stores sessions by their ID. Store keys a session by its ID
+// field, which is the String form of its SessionID. Use
+// FromStringSessionStore for stores keyed by string.
 type SessionStore interface {
-	Get(ctx context.Context, ID string) (*Session, error)
+	Get(ctx context.Context, id SessionID) (*Session, error)
 	Store(ctx context.Context, session *Session) error
-	Delete(ctx context.Context, ID string) error
+	Delete(ctx context.Context, id SessionID) error
+}
+
+// StringSessionStore is a SessionStore keyed by the String form of session
+// IDs, the interface of session stores before SessionID.
+type StringSessionStore interface {
+	Get(ctx context.Context, id string) (*Session, error)
+	Store(ctx context.Context, session *Session) error
+	Delete(ctx context.Context, id string) error
+}
+
+// FromStringSessionStore plugs a store keyed by string into an App. The
+// optional interfaces of the store, e.g. ShopSessionDeleter, are passed on.
+func FromStringSessionStore(s StringSessionStore) SessionStore {
+	return stringStoreAdapter{store: s}
+}
+
+type stringStoreAdapter struct {
+	store StringSessionStore
+}
+
+func (s stringStoreAdapter) Get(ctx context.Context, id SessionID) (*Session, error) {
+	return s.store.Get(ctx, id.String())
+}
+
+func (s stringStoreAdapter) Store(ctx context.Context, session *Session) error {
+	return s.store.Store(ctx, session)
+}
+
+func (s stringStoreAdapter) Delete(ctx context.Context, id SessionID) error {
+	return s.store.Delete(ctx, id.String())
+}
+
+func (s stringStoreAdapter) DeleteShop(ctx context.Context, shop string) error {
+	if d, ok := s.store.(ShopSessionDeleter); ok {
+		return d.DeleteShop(ctx, shop)
+	}
+	if err := s.store.Delete(ctx, GetOfflineSessionID(shop)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s stringStoreAdapter) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if d, ok := s.store.(ExpiredSessionDeleter); ok {
+		return d.DeleteExpired(ctx, now)
+	}
+	return 0, nil
+}
+
+func (s stringStoreAdapter) RecordInstall(ctx context.Context, shop string) (bool, error) {
+	if r, ok := s.store.(InstallRecorder); ok {
+		return r.RecordInstall(ctx, shop)
+	}
+	return true, nil
+}
+
+func (s stringStoreAdapter) Ping(ctx context.Context) error {
+	if p, ok := s.store.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (s stringStoreAdapter) Close(ctx context.Context) error {
+	if c, ok := s.store.(Closer); ok {
+		return c.Close(ctx)
+	}
+	return nil
+}
+
+// InstallRecorder is implemented by session stores which remember installed
+// shops beyond their sessions, which are deleted on uninstall. RecordInstall
+// records shop and reports whether it wasn't recorded before, so reinstalls
+// aren't reported as first installs.
+type InstallRecorder interface {
+	RecordInstall(ctx context.Context, shop string) (bool, error)
+}
+
+// LegacySessionStore is a SessionStore without context support. Use
+// FromLegacySessionStore to plug it into an App.
+type LegacySessionStore interface {
+	Get(ID string) (*Session, error)
+	Store(session *Session) error
+	Delete(ID string) error
+}
+
+func FromLegacySessionStore(s LegacySessionStore) SessionStore {
+	return legacyStoreAdapter{store: s}
+}
+
+type legacyStoreAdapter struct {
+	store LegacySessionStore
+}
+
+func (l legacyStoreAdapter) Get(_ context.Context, id SessionID) (*Session, error) {
+	return l.store.Get(id.String())
+}
+
+func (l legacyStoreAdapter) Store(_ context.Context, session *Session) error {
+	return l.store.Store(session)
+}
+
+func (l legacyStoreAdapter) Delete(_ context.Context, id SessionID) error {
+	return l.store.Delete(id.String())
 }
 
 var ErrNotFound = errors.New("session not found")
 
+// IsNotFound reports whether err is ErrNotFound or a 404 API response.
 func IsNotFound(err error) bool {
 	if err == nil {
 		return false
 	}
-	return errors.Is(err, ErrNotFound)
+	var apiErr *ShopifyAPIError
+	return errors.Is(err, ErrNotFound) || errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
 }
 
 var InMemSessionStore = &inMemSessionStore{}
 
-type inMemSessionStore map[string]*Session
+type inMemSessionStore struct {
+	mu        sync.RWMutex
+	sessions  map[string]*Session
+	installed map[string]bool
+}
 
-func (i inMemSessionStore) Get(_ context.Context, id string) (*Session, error) {
-	sess, ok := i[id]
+func (i *inMemSessionStore) Get(_ context.Context, id SessionID) (*Session, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	sess, ok := i.sessions[id.String()]
 	if !ok {
 		return nil, ErrNotFound
 	}
 	return sess, nil
 }
 
-func (i inMemSessionStore) Store(_ context.Context, session *Session) error {
-	i[session.ID] = session
+func (i *inMemSessionStore) Store(_ context.Context, session *Session) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.sessions == nil {
+		i.sessions = map[string]*Session{}
+	}
+	i.sessions[session.ID] = session
+	return nil
+}
+
+func (i *inMemSessionStore) Delete(_ context.Context, id SessionID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.sessions, id.String())
+	return nil
+}
+
+func (i *inMemSessionStore) DeleteShop(_ context.Context, shop string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for id, sess := range i.sessions {
+		if sess.Shop == shop {
+			delete(i.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (i *inMemSessionStore) DeleteExpired(_ context.Context, now time.Time) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	n := 0
+	for id, sess := range i.sessions {
+		if sess.Expires != nil && sess.Expires.Before(now) {
+			delete(i.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (i *inMemSessionStore) RecordInstall(_ context.Context, shop string) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.installed[shop] {
+		return false, nil
+	}
+	if i.installed == nil {
+		i.installed = map[string]bool{}
+	}
+	i.installed[shop] = true
+	return true, nil
+}
+
+func (i *inMemSessionStore) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.sessions)
+}
+
+func (i *inMemSessionStore) Ping(_ context.Context) error {
 	return nil
 }
 
-func (i inMemSessionStore) Delete(_ context.Context, id string) error {
-	delete(i, id)
+func (i *inMemSessionStore) Close(_ context.Context) error {
 	return nil
 }
 
+func (i *inMemSessionStore) Clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sessions = nil
+	i.installed = nil
+}
+
+// SessionID identifies the offline session of a shop or, with UserID, the
+// online session of one of its users, so a store holds the sessions of all
+// users of a shop side by side. Sessions with IDs of another form, e.g. the
+// random IDs of online sessions of non-embedded apps, have an opaque Key.
+// Stores are keyed by the String form, the IDs of GetOfflineSessionID and
+// GetOnlineSessionID.
+type SessionID struct {
+	Shop   string
+	UserID string
+	// Key is the ID of sessions identified neither by shop nor by user.
+	Key string
+}
+
+func OfflineSessionID(shop string) SessionID {
+	return SessionID{Shop: shop}
+}
+
+func OnlineSessionID(shop string, userID string) SessionID {
+	return SessionID{Shop: shop, UserID: userID}
+}
+
+// OpaqueSessionID returns the ID of a session with a key of its own, e.g. one
+// returned by HookSessionID.
+func OpaqueSessionID(key string) SessionID {
+	return SessionID{Key: key}
+}
+
+// ParseSessionID parses the String form of a SessionID, e.g. a session ID
+// kept in a cookie. Offline IDs are recognized by their prefix, all others are
+// returned as opaque IDs with the same String form, since the user of an
+// online ID can't be told apart from a shop domain containing underscores.
+func ParseSessionID(s string) (SessionID, error) {
+	if s == "" || s == "offline_" {
+		return SessionID{}, fmt.Errorf("invalid session id %q", s)
+	}
+	if shop, ok := strings.CutPrefix(s, "offline_"); ok {
+		return OfflineSessionID(shop), nil
+	}
+	return OpaqueSessionID(s), nil
+}
+
+func (id SessionID) IsOnline() bool {
+	return id.UserID != ""
+}
+
+func (id SessionID) String() string {
+	if id.Key != "" {
+		return id.Key
+	}
+	if id.IsOnline() {
+		return fmt.Sprintf("%s_%s", id.Shop, id.UserID)
+	}
+	return fmt.Sprintf("offline_%s", id.Shop)
+}
+
 func GetOnlineSessionID(shop string, user string) string {
-	return fmt.Sprintf("%s_%s", shop, user)
+	return OnlineSessionID(shop, user).String()
 }
 
 func GetOfflineSessionID(shop string) string {
-	return fmt.Sprintf("offline_%s", shop)
+	return OfflineSessionID(shop).String()
+}
+
+// GetOfflineSession returns the offline session of shop, used for background
+// work. It coexists with the online sessions of the shop's users.
+func GetOfflineSession(ctx context.Context, store SessionStore, shop string) (*Session, error) {
+	return store.Get(ctx, OfflineSessionID(shop))
+}
+
+// GetOnlineSession returns the online session of the shop's user.
+func GetOnlineSession(ctx context.Context, store SessionStore, shop string, userID string) (*Session, error) {
+	return store.Get(ctx, OnlineSessionID(shop, userID))
+}
+
+// SetShopMetadata sets the metadata value k of the shop's offline session.
+func (a *App) SetShopMetadata(ctx context.Context, shop string, k string, v string) error {
+	sess, err := GetOfflineSession(ctx, a.SessionStore, shop)
+	if err != nil {
+		return fmt.Errorf("failed to get session of %s: %w", shop, err)
+	}
+	sess = copySession(sess)
+	if sess.Metadata == nil {
+		sess.Metadata = map[string]string{}
+	}
+	sess.Metadata[k] = v
+	if err = a.SessionStore.Store(ctx, sess); err != nil {
+		return fmt.Errorf("failed to store session of %s: %w", shop, err)
+	}
+	a.clients.invalidate(shop)
+	return nil
+}
+
+// GetShopMetadata returns the metadata value k of the shop's offline session
+// and whether it's set.
+func (a *App) GetShopMetadata(ctx context.Context, shop string, k string) (string, bool, error) {
+	sess, err := GetOfflineSession(ctx, a.SessionStore, shop)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get session of %s: %w", shop, err)
+	}
+	v, ok := sess.Metadata[k]
+	return v, ok, nil
 }
 
 func MustGetShopSession(c *gin.Context) *Session {
@@ -92,3 +388,16 @@ func MustGetShop(c *gin.Context) string {
 	}
 	return s.Shop
 }
+
+type sessionKey struct{}
+
+// ContextWithSession attaches sess to ctx, for net/http middleware such as
+// RequireScopeMiddleware following the app's own session handling.
+func ContextWithSession(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+func SessionFromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(sessionKey{}).(*Session)
+	return sess
+}
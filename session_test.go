@@ -0,0 +1,179 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+}
+
+func TestSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
+
+// run with -race to detect unsynchronized access
+func (s *SessionTestSuite) TestInMemStoreConcurrency() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			shop := fmt.Sprintf("shop-%d.myshopify.com", g%4)
+			for i := 0; i < 100; i++ {
+				id := OnlineSessionID(shop, fmt.Sprint(i))
+				s.NoError(store.Store(ctx, &Session{ID: id.String(), Shop: shop}))
+				_, _ = store.Get(ctx, id)
+				if i%10 == 0 {
+					s.NoError(store.Delete(ctx, id))
+				}
+				if i%50 == 0 {
+					s.NoError(store.DeleteShop(ctx, shop))
+				}
+				_ = store.Len()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	first, err := store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.True(first)
+
+	store.Clear()
+	s.Zero(store.Len())
+	s.NoError(store.Store(ctx, &Session{ID: "id"}))
+	s.Equal(1, store.Len())
+	first, err = store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.True(first, "Clear must forget installs")
+}
+
+func (s *SessionTestSuite) TestShopMetadata() {
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	app, err := NewApp(testAppConfig(), WithSessionStore(store))
+	s.Require().NoError(err)
+	s.Error(app.SetShopMetadata(ctx, "test.myshopify.com", "onboarding", "done"))
+
+	sess := &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com", AccessToken: "token"}
+	s.Require().NoError(store.Store(ctx, sess))
+	_, ok, err := app.GetShopMetadata(ctx, "test.myshopify.com", "onboarding")
+	s.Require().NoError(err)
+	s.False(ok)
+	s.Require().NoError(app.SetShopMetadata(ctx, "test.myshopify.com", "onboarding", "done"))
+	v, ok, err := app.GetShopMetadata(ctx, "test.myshopify.com", "onboarding")
+	s.Require().NoError(err)
+	s.True(ok)
+	s.Equal("done", v)
+	s.Nil(sess.Metadata, "stored session must not be modified in place")
+}
+
+func (s *SessionTestSuite) TestSessionGC() {
+	store := &inMemSessionStore{}
+	app, err := NewApp(testAppConfig(), WithSessionStore(store))
+	s.Require().NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expired, fresh := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	s.Require().NoError(store.Store(ctx, &Session{ID: "expired", Shop: "test.myshopify.com", Expires: &expired}))
+	s.Require().NoError(store.Store(ctx, &Session{ID: "fresh", Shop: "test.myshopify.com", Expires: &fresh}))
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com"}))
+
+	app.StartSessionGC(ctx, time.Millisecond)
+	s.Eventually(func() bool { return store.Len() == 2 }, time.Second, time.Millisecond)
+	_, err = store.Get(ctx, OpaqueSessionID("expired"))
+	s.ErrorIs(err, ErrNotFound)
+	_, err = store.Get(ctx, OpaqueSessionID("fresh"))
+	s.NoError(err)
+
+	s.NotPanics(func() { app.StartSessionGC(ctx, 0) })
+}
+
+func (s *SessionTestSuite) TestSessionID() {
+	s.Equal(GetOfflineSessionID("test.myshopify.com"), OfflineSessionID("test.myshopify.com").String())
+	s.Equal(GetOnlineSessionID("test.myshopify.com", "42"), OnlineSessionID("test.myshopify.com", "42").String())
+	parsed, err := ParseSessionID(GetOfflineSessionID("my_shop.myshopify.com"))
+	s.Require().NoError(err)
+	s.Equal(OfflineSessionID("my_shop.myshopify.com"), parsed)
+	for _, id := range []SessionID{
+		OnlineSessionID("test.myshopify.com", "42"),
+		OnlineSessionID("my_shop.myshopify.com", "42"),
+		OpaqueSessionID("hooked"),
+	} {
+		parsed, err := ParseSessionID(id.String())
+		s.Require().NoError(err)
+		s.Equal(id.String(), parsed.String())
+	}
+	for _, invalid := range []string{"", "offline_"} {
+		_, err := ParseSessionID(invalid)
+		s.Error(err, invalid)
+	}
+
+	s.Equal(OfflineSessionID("test.myshopify.com"),
+		(&Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com"}).SessionID())
+	s.Equal(OnlineSessionID("my_shop.myshopify.com", "42"),
+		(&Session{ID: GetOnlineSessionID("my_shop.myshopify.com", "42"), Shop: "my_shop.myshopify.com", IsOnline: true, UserID: 42}).SessionID())
+	s.Equal(OpaqueSessionID("hooked"), (&Session{ID: "hooked", Shop: "test.myshopify.com"}).SessionID())
+
+	ctx := context.Background()
+	store := &inMemSessionStore{}
+	for _, userID := range []string{"1", "2"} {
+		s.Require().NoError(store.Store(ctx, &Session{ID: GetOnlineSessionID("test.myshopify.com", userID),
+			Shop: "test.myshopify.com", IsOnline: true, AccessToken: "token-" + userID}))
+	}
+	sess, err := store.Get(ctx, OnlineSessionID("test.myshopify.com", "2"))
+	s.Require().NoError(err)
+	s.Equal("token-2", sess.AccessToken)
+	s.Require().NoError(store.Delete(ctx, OnlineSessionID("test.myshopify.com", "2")))
+	_, err = store.Get(ctx, OnlineSessionID("test.myshopify.com", "1"))
+	s.NoError(err, "the sessions of other users must be kept")
+}
+
+type stringSessionStore struct {
+	sessions map[string]*Session
+}
+
+func (m *stringSessionStore) Get(_ context.Context, id string) (*Session, error) {
+	sess, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+func (m *stringSessionStore) Store(_ context.Context, session *Session) error {
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *stringSessionStore) Delete(_ context.Context, id string) error {
+	delete(m.sessions, id)
+	return nil
+}
+
+func (s *SessionTestSuite) TestFromStringSessionStore() {
+	ctx := context.Background()
+	strs := &stringSessionStore{sessions: map[string]*Session{}}
+	store := FromStringSessionStore(strs)
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOnlineSessionID("test.myshopify.com", "42"), Shop: "test.myshopify.com"}))
+	s.Require().NoError(store.Store(ctx, &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com"}))
+	s.Contains(strs.sessions, "test.myshopify.com_42")
+
+	sess, err := store.Get(ctx, OnlineSessionID("test.myshopify.com", "42"))
+	s.Require().NoError(err)
+	s.Equal("test.myshopify.com", sess.Shop)
+	s.Require().NoError(store.Delete(ctx, OnlineSessionID("test.myshopify.com", "42")))
+	_, err = store.Get(ctx, OnlineSessionID("test.myshopify.com", "42"))
+	s.ErrorIs(err, ErrNotFound)
+
+	s.Require().NoError(store.(ShopSessionDeleter).DeleteShop(ctx, "test.myshopify.com"))
+	s.Empty(strs.sessions)
+}
@@ -1,6 +1,168 @@
 package shopigo
 
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// shopInfoTTL is how long ShopInfo caches the info of a shop.
+const shopInfoTTL = 5 * time.Minute
+
 type Shop struct {
 	Address string
 	Token   string
 }
+
+type ShopInfo struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	MyshopifyDomain string `json:"myshopifyDomain"`
+	PrimaryDomain   struct {
+		URL  string `json:"url"`
+		Host string `json:"host"`
+	} `json:"primaryDomain"`
+	// CurrencyCode is the ISO 4217 code of the shop's currency, e.g. EUR.
+	CurrencyCode string `json:"currencyCode"`
+	// IanaTimezone is the shop's time zone, e.g. Europe/Berlin.
+	IanaTimezone string `json:"ianaTimezone"`
+	Plan         struct {
+		DisplayName        string `json:"displayName"`
+		PartnerDevelopment bool   `json:"partnerDevelopment"`
+		ShopifyPlus        bool   `json:"shopifyPlus"`
+	} `json:"plan"`
+	Features struct {
+		// Storefront is whether the Online Store channel is enabled.
+		Storefront bool `json:"storefront"`
+		GiftCards  bool `json:"giftCards"`
+	} `json:"features"`
+}
+
+// IsDevelopmentStore reports whether the shop is a development store of a
+// partner.
+func (i *ShopInfo) IsDevelopmentStore() bool {
+	return i.Plan.PartnerDevelopment
+}
+
+// Location returns the shop's time zone, UTC if it's unknown.
+func (i *ShopInfo) Location() *time.Location {
+	loc, err := time.LoadLocation(i.IanaTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ShopInfoCache caches the results of App.ShopInfo by shop. Use a shared
+// cache such as the RedisShopInfoCache if several instances serve the app, so
+// invalidations on one instance are seen by all.
+type ShopInfoCache interface {
+	Get(ctx context.Context, shop string) (*ShopInfo, bool, error)
+	Set(ctx context.Context, shop string, info *ShopInfo, ttl time.Duration) error
+	Invalidate(ctx context.Context, shop string) error
+}
+
+type shopInfoEntry struct {
+	info    *ShopInfo
+	expires time.Time
+}
+
+// shopInfoCache is the in-memory ShopInfoCache used by default.
+type shopInfoCache struct {
+	mu    sync.Mutex
+	infos map[string]shopInfoEntry
+}
+
+func newShopInfoCache() *shopInfoCache {
+	return &shopInfoCache{infos: map[string]shopInfoEntry{}}
+}
+
+func (s *shopInfoCache) Get(_ context.Context, shop string) (*ShopInfo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.infos[shop]
+	if !ok || time.Now().After(e.expires) {
+		delete(s.infos, shop)
+		return nil, false, nil
+	}
+	return e.info, true, nil
+}
+
+func (s *shopInfoCache) Set(_ context.Context, shop string, info *ShopInfo, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.infos[shop] = shopInfoEntry{info: info, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *shopInfoCache) Invalidate(_ context.Context, shop string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.infos, shop)
+	return nil
+}
+
+// WithShopInfoCache caches the results of App.ShopInfo in cache instead of
+// memory.
+func WithShopInfoCache(cache ShopInfoCache) Opt {
+	return func(a *App) {
+		a.shopInfos = cache
+	}
+}
+
+// invalidateShop drops the cached client and shop info of shop, e.g. after
+// it reauthorized or its scopes changed.
+func (a *App) invalidateShop(ctx context.Context, shop string) error {
+	a.clients.invalidate(shop)
+	a.grantedScopes.invalidate(shop)
+	if err := a.shopInfos.Invalidate(ctx, shop); err != nil {
+		return fmt.Errorf("failed to invalidate shop info of %s: %w", shop, err)
+	}
+	return nil
+}
+
+// InvalidateShopCache drops the cached shop info and client of shop, so they
+// are loaded again on next use. Clients of an App share the cache, so the
+// invalidation is seen by all of them. It's a no-op for clients created
+// without App.
+func (c *Client) InvalidateShopCache(ctx context.Context, shop string) error {
+	if c.invalidateShop == nil {
+		return nil
+	}
+	return c.invalidateShop(ctx, shop)
+}
+
+// ShopInfo returns the plan, domain, currency, time zone and features of the
+// shop using its offline session. Results are cached for a few minutes.
+func (a *App) ShopInfo(ctx context.Context, shop string) (*ShopInfo, error) {
+	if info, ok, err := a.shopInfos.Get(ctx, shop); err != nil {
+		a.Client.logger.Warn("failed to get cached shop info", "shop", shop, "error", err)
+	} else if ok {
+		return info, nil
+	}
+	client, err := a.ClientFor(ctx, shop)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Shop ShopInfo `json:"shop"`
+	}
+	err = client.GraphQL(ctx, client.Session(), `query shopInfo {
+		shop {
+			id name email myshopifyDomain
+			primaryDomain { url host }
+			currencyCode ianaTimezone
+			plan { displayName partnerDevelopment shopifyPlus }
+			features { storefront giftCards }
+		}
+	}`, nil, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shop info of %s: %w", shop, err)
+	}
+	if err = a.shopInfos.Set(ctx, shop, &out.Shop, shopInfoTTL); err != nil {
+		a.Client.logger.Warn("failed to cache shop info", "shop", shop, "error", err)
+	}
+	return &out.Shop, nil
+}
@@ -0,0 +1,80 @@
+package shopigotest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// TokenOpt customizes the claims of a session token minted by
+// NewTestSessionToken.
+type TokenOpt func(claims jwt.MapClaims)
+
+// WithUserID sets the user the token is issued for, "1" by default.
+func WithUserID(id string) TokenOpt {
+	return func(claims jwt.MapClaims) {
+		claims["sub"] = id
+	}
+}
+
+// WithSessionID sets the sid claim.
+func WithSessionID(sid string) TokenOpt {
+	return func(claims jwt.MapClaims) {
+		claims["sid"] = sid
+	}
+}
+
+// WithExpiry sets the expiration, a minute from now by default. Use a time in
+// the past to test expired tokens.
+func WithExpiry(exp time.Time) TokenOpt {
+	return func(claims jwt.MapClaims) {
+		claims["exp"] = exp.Unix()
+	}
+}
+
+// WithClaim sets any claim, a nil value removes it.
+func WithClaim(name string, value any) TokenOpt {
+	return func(claims jwt.MapClaims) {
+		if value == nil {
+			delete(claims, name)
+			return
+		}
+		claims[name] = value
+	}
+}
+
+// NewTestSessionToken mints a session token of shop as App Bridge sends it,
+// signed with the app's client secret and accepted by App.DecodeSessionToken.
+func NewTestSessionToken(secret string, clientID string, shop string, opts ...TokenOpt) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":  "https://" + shop + "/admin",
+		"dest": "https://" + shop,
+		"aud":  clientID,
+		"sub":  "1",
+		"exp":  now.Add(time.Minute).Unix(),
+		"nbf":  now.Add(-5 * time.Second).Unix(),
+		"iat":  now.Add(-5 * time.Second).Unix(),
+		"jti":  base64.RawURLEncoding.EncodeToString([]byte(now.String())),
+		"sid":  "test-session-id",
+	}
+	for _, opt := range opts {
+		opt(claims)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		// HMAC signing only fails for invalid keys, which []byte can't be
+		panic(err)
+	}
+	return token
+}
+
+// SignWebhook returns the X-Shopify-Hmac-SHA256 header of a webhook with
+// body, accepted by App.VerifyWebhook.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
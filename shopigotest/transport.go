@@ -0,0 +1,206 @@
+package shopigotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type Mode int
+
+const (
+	// Replay serves recorded responses, requests without recording fail.
+	Replay Mode = iota
+	// Record proxies requests to the API and records them.
+	Record
+)
+
+const redacted = "REDACTED"
+
+// redactedFields are removed from recorded JSON bodies, e.g. the client
+// secret of token requests and the access token of their responses.
+var redactedFields = map[string]bool{
+	"access_token":  true,
+	"client_secret": true,
+	"subject_token": true,
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// RecordingTransport records the requests sent to the Shopify API into a
+// fixture file and replays them in tests. Requests are matched on method,
+// path and body, repeated requests are served in recorded order. Request
+// headers aren't recorded, tokens in bodies are redacted.
+type RecordingTransport struct {
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecordingTransport creates a transport for the fixture at path. In
+// replay mode the fixture is loaded, in record mode it's overwritten with the
+// requests sent through upstream, http.DefaultTransport if nil.
+func NewRecordingTransport(path string, mode Mode, upstream http.RoundTripper) (*RecordingTransport, error) {
+	if upstream == nil {
+		upstream = http.DefaultTransport
+	}
+	t := &RecordingTransport{mode: mode, path: path, transport: upstream}
+	if mode == Record {
+		return t, nil
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	if err = json.Unmarshal(bs, &t.interactions); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture: %w", err)
+	}
+	t.used = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := RecordedRequest{Method: req.Method, Path: req.URL.Path, Body: normalize(body)}
+	if t.mode == Record {
+		return t.record(req, recorded)
+	}
+	return t.replay(req, recorded)
+}
+
+func (t *RecordingTransport) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interactions = append(t.interactions, Interaction{Request: recorded, Response: RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       normalize(body),
+	}})
+	if err = t.save(); err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (t *RecordingTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, interaction := range t.interactions {
+		if t.used[i] || interaction.Request != recorded {
+			continue
+		}
+		t.used[i] = true
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode: interaction.Response.StatusCode,
+			Header:     interaction.Response.Header.Clone(),
+			Body:       io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			Request:    req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded response for %s %s", recorded.Method, recorded.Path)
+}
+
+// Unused returns the recorded interactions not replayed yet.
+func (t *RecordingTransport) Unused() []Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []Interaction
+	for i, used := range t.used {
+		if !used {
+			unused = append(unused, t.interactions[i])
+		}
+	}
+	return unused
+}
+
+func (t *RecordingTransport) save() error {
+	bs, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(t.path, bs, 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// normalize re-encodes JSON bodies with sorted keys and redacted tokens, so
+// recordings match regardless of field order. Other bodies are kept as is.
+func normalize(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return string(body)
+	}
+	bs, err := json.Marshal(redact(v))
+	if err != nil {
+		return string(body)
+	}
+	return string(bs)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if redactedFields[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redact(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package shopigotest
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type TransportTestSuite struct {
+	suite.Suite
+	server  *httptest.Server
+	fixture string
+	calls   int
+}
+
+func TestTransportTestSuite(t *testing.T) {
+	suite.Run(t, new(TransportTestSuite))
+}
+
+func (s *TransportTestSuite) SetupTest() {
+	s.calls = 0
+	s.fixture = filepath.Join(s.T().TempDir(), "fixture.json")
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls++
+		bs, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(bs), "client_secret") {
+			_, _ = w.Write([]byte(`{"access_token":"shpat_secret","scope":"read_products"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"product":{"id":"gid://shopify/Product/9007199254740993"}}}`))
+	}))
+}
+
+func (s *TransportTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *TransportTestSuite) post(client *http.Client, path string, body string) string {
+	resp, err := client.Post(s.server.URL+path, "application/json", strings.NewReader(body))
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return string(bs)
+}
+
+func (s *TransportTestSuite) TestRecordReplay() {
+	recorder, err := NewRecordingTransport(s.fixture, Record, nil)
+	s.Require().NoError(err)
+	client := &http.Client{Transport: recorder}
+	s.post(client, "/admin/oauth/access_token", `{"client_id":"id","client_secret":"secret"}`)
+	s.post(client, "/admin/api/graphql.json", `{"variables":{"id":1},"query":"{ product }"}`)
+
+	bs, err := os.ReadFile(s.fixture)
+	s.Require().NoError(err)
+	s.NotContains(string(bs), "shpat_secret")
+	s.NotContains(string(bs), `\"client_secret\":\"secret\"`)
+
+	replayer, err := NewRecordingTransport(s.fixture, Replay, nil)
+	s.Require().NoError(err)
+	client = &http.Client{Transport: replayer}
+	s.Equal(`{"data":{"product":{"id":"gid://shopify/Product/9007199254740993"}}}`,
+		s.post(client, "/admin/api/graphql.json", `{"query":"{ product }","variables":{"id":1}}`))
+	s.Len(replayer.Unused(), 1)
+	s.Equal(`{"access_token":"REDACTED","scope":"read_products"}`,
+		s.post(client, "/admin/oauth/access_token", `{"client_id":"id","client_secret":"other"}`))
+	s.Empty(replayer.Unused())
+	s.Equal(2, s.calls)
+
+	_, err = client.Post(s.server.URL+"/admin/api/graphql.json", "application/json", strings.NewReader(`{}`))
+	s.Error(err)
+}
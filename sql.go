@@ -0,0 +1,293 @@
+package shopigo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Dialect int
+
+const (
+	DialectPostgres Dialect = iota
+	DialectMySQL
+	DialectSQLite
+)
+
+const (
+	sessionsTable = "shopify_sessions"
+	webhooksTable = "shopify_webhooks"
+	installsTable = "shopify_installs"
+)
+
+type SQLSessionStore struct {
+	db      *sql.DB
+	dialect Dialect
+	closer  closeOnce
+}
+
+func NewSQLSessionStore(db *sql.DB, dialect Dialect) *SQLSessionStore {
+	return &SQLSessionStore{db: db, dialect: dialect}
+}
+
+func (s *SQLSessionStore) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+sessionsTable+` (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		shop VARCHAR(255) NOT NULL,
+		state VARCHAR(255) NOT NULL,
+		is_online BOOLEAN NOT NULL,
+		user_id BIGINT NOT NULL,
+		access_token TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		requested_scope TEXT NULL,
+		expires_at BIGINT NULL,
+		online_access_info TEXT NULL,
+		metadata TEXT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+	if err = s.addColumn(ctx, sessionsTable, "requested_scope", "TEXT NULL"); err != nil {
+		return err
+	}
+	if err = s.addColumn(ctx, sessionsTable, "metadata", "TEXT NULL"); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE INDEX `+s.ifNotExists()+`shopify_sessions_shop_idx ON `+sessionsTable+` (shop)`)
+	if err != nil && !s.duplicateIndex(err) {
+		return fmt.Errorf("failed to create sessions index: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+webhooksTable+` (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		expires_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhooks table: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+installsTable+` (
+		shop VARCHAR(255) NOT NULL PRIMARY KEY,
+		installed_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create installs table: %w", err)
+	}
+	return nil
+}
+
+// addColumn adds a column introduced after the table was first created.
+func (s *SQLSessionStore) addColumn(ctx context.Context, table string, column string, definition string) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+column+` FROM `+table+` WHERE 1 = 0`)
+	if err == nil {
+		return rows.Close()
+	}
+	if _, err = s.db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %w", column, table, err)
+	}
+	return nil
+}
+
+func (s *SQLSessionStore) Get(ctx context.Context, id SessionID) (*Session, error) {
+	row := s.db.QueryRowContext(ctx, s.bind(`SELECT id, shop, state, is_online, user_id, access_token, scope,
+		requested_scope, expires_at, online_access_info, metadata FROM `+sessionsTable+` WHERE id = ?`), id.String())
+	var sess Session
+	var expiresAt sql.NullInt64
+	var requested, info, metadata sql.NullString
+	err := row.Scan(&sess.ID, &sess.Shop, &sess.State, &sess.IsOnline, &sess.UserID, &sess.AccessToken,
+		&sess.Scopes, &requested, &expiresAt, &info, &metadata)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	sess.RequestedScopes = requested.String
+	if expiresAt.Valid {
+		exp := time.Unix(expiresAt.Int64, 0)
+		sess.Expires = &exp
+	}
+	if info.Valid {
+		if err = jsonUnmarshal([]byte(info.String), &sess.OnlineAccessInfo); err != nil {
+			return nil, fmt.Errorf("failed to decode online access info: %w", err)
+		}
+	}
+	if metadata.Valid {
+		if err = jsonUnmarshal([]byte(metadata.String), &sess.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+	return &sess, nil
+}
+
+// Store upserts the session by its ID, which is derived from the shop and,
+// for online sessions, the associated user.
+func (s *SQLSessionStore) Store(ctx context.Context, session *Session) error {
+	var expiresAt sql.NullInt64
+	if session.Expires != nil {
+		expiresAt = sql.NullInt64{Int64: session.Expires.Unix(), Valid: true}
+	}
+	var info sql.NullString
+	if session.OnlineAccessInfo != nil {
+		bs, err := jsonMarshal(session.OnlineAccessInfo)
+		if err != nil {
+			return fmt.Errorf("failed to encode online access info: %w", err)
+		}
+		info = sql.NullString{String: string(bs), Valid: true}
+	}
+	var metadata sql.NullString
+	if len(session.Metadata) > 0 {
+		bs, err := jsonMarshal(session.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		metadata = sql.NullString{String: string(bs), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, s.bind(`INSERT INTO `+sessionsTable+` (id, shop, state, is_online, user_id,
+		access_token, scope, requested_scope, expires_at, online_access_info, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) `+s.upsert("shop", "state", "is_online", "user_id", "access_token",
+		"scope", "requested_scope", "expires_at", "online_access_info", "metadata")),
+		session.ID, session.Shop, session.State, session.IsOnline, session.UserID, session.AccessToken,
+		session.Scopes, session.RequestedScopes, expiresAt, info, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLSessionStore) Delete(ctx context.Context, id SessionID) error {
+	if _, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+sessionsTable+` WHERE id = ?`), id.String()); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLSessionStore) DeleteShop(ctx context.Context, shop string) error {
+	if _, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+sessionsTable+` WHERE shop = ?`), shop); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLSessionStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+sessionsTable+` WHERE expires_at IS NOT NULL AND expires_at < ?`), now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired sessions: %w", err)
+	}
+	return int(n), nil
+}
+
+// Close closes the database.
+func (s *SQLSessionStore) Close(_ context.Context) error {
+	return s.closer.close(s.db.Close)
+}
+
+func (s *SQLSessionStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLSessionStore) SeenBefore(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+webhooksTable+` WHERE id = ? AND expires_at <= ?`),
+		id, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to expire webhook: %w", err)
+	}
+	insert := `INSERT INTO ` + webhooksTable + ` (id, expires_at) VALUES (?, ?) ON CONFLICT (id) DO NOTHING`
+	if s.dialect == DialectMySQL {
+		insert = `INSERT IGNORE INTO ` + webhooksTable + ` (id, expires_at) VALUES (?, ?)`
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(insert), id, now.Add(ttl).Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook: %w", err)
+	}
+	return n == 0, nil
+}
+
+func (s *SQLSessionStore) Seen(ctx context.Context, id string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, s.bind(`SELECT COUNT(*) FROM `+webhooksTable+` WHERE id = ? AND expires_at > ?`),
+		id, time.Now().Unix()).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to check webhook: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *SQLSessionStore) Forget(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, s.bind(`DELETE FROM `+webhooksTable+` WHERE id = ?`), id); err != nil {
+		return fmt.Errorf("failed to forget webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLSessionStore) RecordInstall(ctx context.Context, shop string) (bool, error) {
+	insert := `INSERT INTO ` + installsTable + ` (shop, installed_at) VALUES (?, ?) ON CONFLICT (shop) DO NOTHING`
+	if s.dialect == DialectMySQL {
+		insert = `INSERT IGNORE INTO ` + installsTable + ` (shop, installed_at) VALUES (?, ?)`
+	}
+	res, err := s.db.ExecContext(ctx, s.bind(insert), shop, time.Now().Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to record install: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record install: %w", err)
+	}
+	return n > 0, nil
+}
+
+// bind rewrites ? placeholders into the dialect's placeholder style.
+func (s *SQLSessionStore) bind(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLSessionStore) upsert(columns ...string) string {
+	set := make([]string, len(columns))
+	for i, col := range columns {
+		if s.dialect == DialectMySQL {
+			set[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+		} else {
+			set[i] = fmt.Sprintf("%s = excluded.%s", col, col)
+		}
+	}
+	if s.dialect == DialectMySQL {
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	}
+	return "ON CONFLICT (id) DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// MySQL doesn't support IF NOT EXISTS on indexes, the duplicate index error
+// is ignored instead.
+func (s *SQLSessionStore) ifNotExists() string {
+	if s.dialect == DialectMySQL {
+		return ""
+	}
+	return "IF NOT EXISTS "
+}
+
+func (s *SQLSessionStore) duplicateIndex(err error) bool {
+	return s.dialect == DialectMySQL && strings.Contains(err.Error(), "Duplicate key name")
+}
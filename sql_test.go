@@ -0,0 +1,211 @@
+package shopigo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/suite"
+	_ "modernc.org/sqlite"
+	"testing"
+	"time"
+)
+
+type SQLTestSuite struct {
+	suite.Suite
+	db    *sql.DB
+	store *SQLSessionStore
+}
+
+func TestSQLTestSuite(t *testing.T) {
+	suite.Run(t, new(SQLTestSuite))
+}
+
+func (s *SQLTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite", ":memory:")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+	s.db = db
+	s.store = NewSQLSessionStore(db, DialectSQLite)
+	s.Require().NoError(s.store.Migrate(context.Background()))
+	s.Require().NoError(s.store.Migrate(context.Background()), "migrate must be idempotent")
+}
+
+func (s *SQLTestSuite) TearDownTest() {
+	s.NoError(s.db.Close())
+}
+
+func (s *SQLTestSuite) TestInsertAndFetch() {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	sess := &Session{
+		ID:          GetOnlineSessionID("test.myshopify.com", "42"),
+		Shop:        "test.myshopify.com",
+		State:       "state",
+		IsOnline:    true,
+		UserID:      42,
+		AccessToken: "token",
+		Scopes:      "read_orders,read_products",
+		Expires:     &expires,
+		OnlineAccessInfo: &OnlineAccessInfo{
+			Exp:       3600,
+			UserScope: "read_products",
+			User:      &User{ID: 42, Email: "john@example.com"},
+		},
+		Metadata: map[string]string{"onboarding": "done"},
+	}
+	s.Require().NoError(s.store.Store(ctx, sess))
+	got, err := s.store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.True(expires.Equal(*got.Expires))
+	got.Expires = sess.Expires
+	s.Equal(sess, got)
+}
+
+func (s *SQLTestSuite) TestMigrateAddsColumns() {
+	ctx := context.Background()
+	_, err := s.db.ExecContext(ctx, `DROP TABLE `+sessionsTable)
+	s.Require().NoError(err)
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE `+sessionsTable+` (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		shop VARCHAR(255) NOT NULL,
+		state VARCHAR(255) NOT NULL,
+		is_online BOOLEAN NOT NULL,
+		user_id BIGINT NOT NULL,
+		access_token TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		expires_at BIGINT NULL,
+		online_access_info TEXT NULL
+	)`)
+	s.Require().NoError(err)
+	_, err = s.db.ExecContext(ctx, `INSERT INTO `+sessionsTable+` (id, shop, state, is_online, user_id, access_token, scope)
+		VALUES ('offline_old.myshopify.com', 'old.myshopify.com', '', FALSE, 0, 'token', 'read_products')`)
+	s.Require().NoError(err)
+	s.Require().NoError(s.store.Migrate(ctx))
+	old, err := s.store.Get(ctx, OfflineSessionID("old.myshopify.com"))
+	s.Require().NoError(err, "sessions stored before the migration must stay readable")
+	s.Equal("read_products", old.Scopes)
+	s.Empty(old.RequestedScopes)
+
+	sess := &Session{ID: "offline_test.myshopify.com", Shop: "test.myshopify.com", RequestedScopes: "read_products",
+		Metadata: map[string]string{"k": "v"}}
+	s.Require().NoError(s.store.Store(ctx, sess))
+	got, err := s.store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.Equal(sess.RequestedScopes, got.RequestedScopes)
+	s.Equal(sess.Metadata, got.Metadata)
+}
+
+func (s *SQLTestSuite) TestOverwrite() {
+	ctx := context.Background()
+	sess := &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com", AccessToken: "old"}
+	s.Require().NoError(s.store.Store(ctx, sess))
+	sess.AccessToken = "new"
+	sess.Scopes = "read_products"
+	s.Require().NoError(s.store.Store(ctx, sess))
+
+	got, err := s.store.Get(ctx, sess.SessionID())
+	s.Require().NoError(err)
+	s.Equal("new", got.AccessToken)
+	s.Equal("read_products", got.Scopes)
+	s.Nil(got.Expires)
+	s.Nil(got.OnlineAccessInfo)
+}
+
+func (s *SQLTestSuite) TestDelete() {
+	ctx := context.Background()
+	for _, sess := range []*Session{
+		{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com"},
+		{ID: GetOnlineSessionID("test.myshopify.com", "42"), Shop: "test.myshopify.com", IsOnline: true, UserID: 42},
+		{ID: GetOfflineSessionID("other.myshopify.com"), Shop: "other.myshopify.com"},
+	} {
+		s.Require().NoError(s.store.Store(ctx, sess))
+	}
+
+	s.Require().NoError(s.store.Delete(ctx, OfflineSessionID("other.myshopify.com")))
+	_, err := s.store.Get(ctx, OfflineSessionID("other.myshopify.com"))
+	s.True(IsNotFound(err))
+
+	s.Require().NoError(s.store.DeleteShop(ctx, "test.myshopify.com"))
+	for _, id := range []SessionID{OfflineSessionID("test.myshopify.com"), OnlineSessionID("test.myshopify.com", "42")} {
+		_, err = s.store.Get(ctx, id)
+		s.True(IsNotFound(err))
+	}
+}
+
+func (s *SQLTestSuite) TestBindPostgres() {
+	store := NewSQLSessionStore(nil, DialectPostgres)
+	s.Equal("SELECT 1 WHERE a = $1 AND b = $2", store.bind("SELECT 1 WHERE a = ? AND b = ?"))
+}
+
+func (s *SQLTestSuite) TestSeenBefore() {
+	ctx := context.Background()
+	seen, err := s.store.Seen(ctx, "webhook-1")
+	s.Require().NoError(err)
+	s.False(seen)
+	seen, err = s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen)
+	seen, err = s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.True(seen)
+	seen, err = s.store.Seen(ctx, "webhook-1")
+	s.Require().NoError(err)
+	s.True(seen)
+
+	seen, err = s.store.SeenBefore(ctx, "webhook-2", -time.Minute)
+	s.Require().NoError(err)
+	s.False(seen)
+	seen, err = s.store.SeenBefore(ctx, "webhook-2", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen, "expired ids must be recorded again")
+	seen, err = s.store.Seen(ctx, "webhook-3")
+	s.Require().NoError(err)
+	s.False(seen, "checking must not record ids")
+	seen, err = s.store.SeenBefore(ctx, "webhook-3", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen)
+}
+
+func (s *SQLTestSuite) TestRecordInstall() {
+	ctx := context.Background()
+	first, err := s.store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.True(first)
+	s.Require().NoError(s.store.DeleteShop(ctx, "test.myshopify.com"))
+	first, err = s.store.RecordInstall(ctx, "test.myshopify.com")
+	s.Require().NoError(err)
+	s.False(first, "installs must be remembered after uninstall")
+}
+
+func (s *SQLTestSuite) TestForget() {
+	ctx := context.Background()
+	var _ DedupForgetter = s.store
+	_, err := s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.Require().NoError(s.store.Forget(ctx, "webhook-1"))
+	seen, err := s.store.SeenBefore(ctx, "webhook-1", time.Minute)
+	s.Require().NoError(err)
+	s.False(seen, "forgotten ids must be recorded again")
+	s.NoError(s.store.Forget(ctx, "unknown"))
+}
+
+func (s *SQLTestSuite) TestDeleteExpired() {
+	ctx := context.Background()
+	now := time.Now()
+	expired, fresh := now.Add(-time.Minute), now.Add(time.Hour)
+	for _, sess := range []*Session{
+		{ID: "expired", Shop: "test.myshopify.com", IsOnline: true, Expires: &expired},
+		{ID: "fresh", Shop: "test.myshopify.com", IsOnline: true, Expires: &fresh},
+		{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com"},
+	} {
+		s.Require().NoError(s.store.Store(ctx, sess))
+	}
+	n, err := DeleteExpiredSessions(ctx, s.store, now)
+	s.Require().NoError(err)
+	s.Equal(1, n)
+	_, err = s.store.Get(ctx, OpaqueSessionID("expired"))
+	s.ErrorIs(err, ErrNotFound)
+	for _, id := range []SessionID{OpaqueSessionID("fresh"), OfflineSessionID("test.myshopify.com")} {
+		_, err = s.store.Get(ctx, id)
+		s.NoError(err, id)
+	}
+}
@@ -0,0 +1,221 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// WebhookSubscription describes a desired subscription for a topic such as
+// orders/create. Exactly one target must be set: a callback URL, which may be
+// relative to the app's HostURL, a Pub/Sub project and topic, or an
+// EventBridge ARN. Only one subscription per topic is supported.
+type WebhookSubscription struct {
+	Topic          string
+	CallbackURL    string
+	PubSubProject  string
+	PubSubTopic    string
+	EventBridgeARN string
+}
+
+type webhookSubscriptionNode struct {
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Endpoint struct {
+		Typename      string `json:"__typename"`
+		CallbackURL   string `json:"callbackUrl"`
+		ARN           string `json:"arn"`
+		PubSubProject string `json:"pubSubProject"`
+		PubSubTopic   string `json:"pubSubTopic"`
+	} `json:"endpoint"`
+}
+
+func (n *webhookSubscriptionNode) subscription() WebhookSubscription {
+	return WebhookSubscription{
+		Topic:          n.Topic,
+		CallbackURL:    n.Endpoint.CallbackURL,
+		PubSubProject:  n.Endpoint.PubSubProject,
+		PubSubTopic:    n.Endpoint.PubSubTopic,
+		EventBridgeARN: n.Endpoint.ARN,
+	}
+}
+
+// kind is the prefix of the mutations managing the subscription's target.
+func (w WebhookSubscription) kind() string {
+	switch {
+	case w.EventBridgeARN != "":
+		return "eventBridgeWebhook"
+	case w.PubSubProject != "":
+		return "pubSubWebhook"
+	}
+	return "webhook"
+}
+
+// topicEnum converts a topic such as orders/create into Shopify's
+// WebhookSubscriptionTopic enum value ORDERS_CREATE.
+func topicEnum(topic string) string {
+	return strings.ToUpper(strings.NewReplacer("/", "_", ".", "_").Replace(topic))
+}
+
+// EnsureWebhooks reconciles the shop's webhook subscriptions with the desired
+// ones: missing subscriptions are created, subscriptions with a different
+// target are updated and subscriptions for other topics are deleted. Running
+// it against an already reconciled shop doesn't change anything.
+func (c *Client) EnsureWebhooks(ctx context.Context, sess *Session, desired []WebhookSubscription) error {
+	existing, err := c.listWebhookSubscriptions(ctx, sess)
+	if err != nil {
+		return err
+	}
+	byTopic := map[string][]webhookSubscriptionNode{}
+	for _, n := range existing {
+		byTopic[n.Topic] = append(byTopic[n.Topic], n)
+	}
+	for _, want := range desired {
+		if want, err = c.resolveSubscription(want); err != nil {
+			return err
+		}
+		nodes := byTopic[want.Topic]
+		delete(byTopic, want.Topic)
+		match := -1
+		for i := range nodes {
+			if nodes[i].subscription() == want {
+				match = i
+				break
+			}
+		}
+		for i := range nodes {
+			if match == -1 && nodes[i].subscription().kind() == want.kind() {
+				match = i
+			}
+		}
+		switch {
+		case match == -1:
+			err = c.createWebhookSubscription(ctx, sess, want)
+		case nodes[match].subscription() != want:
+			err = c.updateWebhookSubscription(ctx, sess, nodes[match].ID, want)
+		}
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			if i == match {
+				continue
+			}
+			if err = c.deleteWebhookSubscription(ctx, sess, nodes[i].ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, nodes := range byTopic {
+		for _, n := range nodes {
+			if err = c.deleteWebhookSubscription(ctx, sess, n.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) resolveSubscription(w WebhookSubscription) (WebhookSubscription, error) {
+	w.Topic = topicEnum(w.Topic)
+	if w.kind() != "webhook" || strings.HasPrefix(w.CallbackURL, "https://") {
+		return w, nil
+	}
+	u, err := url.JoinPath(c.hostURL, w.CallbackURL)
+	if err != nil {
+		return w, fmt.Errorf("invalid callback url %s: %w", w.CallbackURL, err)
+	}
+	w.CallbackURL = u
+	return w, nil
+}
+
+func (c *Client) listWebhookSubscriptions(ctx context.Context, sess *Session) ([]webhookSubscriptionNode, error) {
+	var nodes []webhookSubscriptionNode
+	vars := map[string]any{}
+	for {
+		var out struct {
+			WebhookSubscriptions struct {
+				Nodes    []webhookSubscriptionNode `json:"nodes"`
+				PageInfo PageInfo                  `json:"pageInfo"`
+			} `json:"webhookSubscriptions"`
+		}
+		err := c.GraphQL(ctx, sess, `query webhookSubscriptions($after: String) {
+			webhookSubscriptions(first: 100, after: $after) {
+				nodes {
+					id
+					topic
+					endpoint {
+						__typename
+						... on WebhookHttpEndpoint { callbackUrl }
+						... on WebhookEventBridgeEndpoint { arn }
+						... on WebhookPubSubEndpoint { pubSubProject pubSubTopic }
+					}
+				}
+				pageInfo { hasNextPage endCursor }
+			}
+		}`, vars, &out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+		}
+		nodes = append(nodes, out.WebhookSubscriptions.Nodes...)
+		if !out.WebhookSubscriptions.PageInfo.HasNextPage {
+			return nodes, nil
+		}
+		vars["after"] = out.WebhookSubscriptions.PageInfo.EndCursor
+	}
+}
+
+func subscriptionInput(w WebhookSubscription) map[string]any {
+	switch w.kind() {
+	case "eventBridgeWebhook":
+		return map[string]any{"arn": w.EventBridgeARN}
+	case "pubSubWebhook":
+		return map[string]any{"pubSubProject": w.PubSubProject, "pubSubTopic": w.PubSubTopic}
+	}
+	return map[string]any{"callbackUrl": w.CallbackURL}
+}
+
+func subscriptionInputType(w WebhookSubscription) string {
+	switch w.kind() {
+	case "eventBridgeWebhook":
+		return "EventBridgeWebhookSubscriptionInput"
+	case "pubSubWebhook":
+		return "PubSubWebhookSubscriptionInput"
+	}
+	return "WebhookSubscriptionInput"
+}
+
+func (c *Client) createWebhookSubscription(ctx context.Context, sess *Session, w WebhookSubscription) error {
+	mutation := w.kind() + "SubscriptionCreate"
+	input := subscriptionInput(w)
+	input["format"] = "JSON"
+	err := c.GraphQL(ctx, sess, fmt.Sprintf(`mutation create($topic: WebhookSubscriptionTopic!, $sub: %s!) {
+		%s(topic: $topic, webhookSubscription: $sub) { userErrors { field message } }
+	}`, subscriptionInputType(w), mutation), map[string]any{"topic": w.Topic, "sub": input}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create %s webhook subscription: %w", w.Topic, err)
+	}
+	return nil
+}
+
+func (c *Client) updateWebhookSubscription(ctx context.Context, sess *Session, id string, w WebhookSubscription) error {
+	mutation := w.kind() + "SubscriptionUpdate"
+	err := c.GraphQL(ctx, sess, fmt.Sprintf(`mutation update($id: ID!, $sub: %s!) {
+		%s(id: $id, webhookSubscription: $sub) { userErrors { field message } }
+	}`, subscriptionInputType(w), mutation), map[string]any{"id": id, "sub": subscriptionInput(w)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update %s webhook subscription: %w", w.Topic, err)
+	}
+	return nil
+}
+
+func (c *Client) deleteWebhookSubscription(ctx context.Context, sess *Session, id string) error {
+	err := c.GraphQL(ctx, sess, `mutation delete($id: ID!) {
+		webhookSubscriptionDelete(id: $id) { userErrors { field message } }
+	}`, map[string]any{"id": id}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package shopigo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGraphQLMaxAvailable = 1000
+	defaultGraphQLRestoreRate  = 50
+)
+
+type GraphQLCost struct {
+	RequestedQueryCost float64        `json:"requestedQueryCost"`
+	ActualQueryCost    float64        `json:"actualQueryCost"`
+	ThrottleStatus     ThrottleStatus `json:"throttleStatus"`
+}
+
+type ThrottleStatus struct {
+	MaximumAvailable   float64 `json:"maximumAvailable"`
+	CurrentlyAvailable float64 `json:"currentlyAvailable"`
+	RestoreRate        float64 `json:"restoreRate"`
+}
+
+// restoreTime returns how long it takes until the bucket can afford the
+// requested query cost.
+func (c *GraphQLCost) restoreTime() time.Duration {
+	status := c.ThrottleStatus
+	missing := c.RequestedQueryCost - status.CurrentlyAvailable
+	if missing <= 0 || status.RestoreRate <= 0 {
+		return 0
+	}
+	return time.Duration(missing / status.RestoreRate * float64(time.Second))
+}
+
+type bucketLimits struct {
+	maxAvailable float64
+	restoreRate  float64
+}
+
+// graphQLThrottle keeps a local estimate of each shop's leaky bucket based on
+// the cost extension returned with every GraphQL response. Before a request
+// is sent, it waits until the bucket restored enough points to afford the
+// cost of the previous query for the shop.
+type graphQLThrottle struct {
+	mu       sync.Mutex
+	enabled  bool
+	defaults bucketLimits
+	limits   map[string]bucketLimits
+	buckets  map[string]*costBucket
+	// shopKey returns the bucket key of a shop in limits, see bucketKey.
+	shopKey func(shop string) string
+}
+
+type costBucket struct {
+	bucketLimits
+	available float64
+	cost      float64
+	updated   time.Time
+}
+
+func newGraphQLThrottle() *graphQLThrottle {
+	return &graphQLThrottle{
+		defaults: bucketLimits{maxAvailable: defaultGraphQLMaxAvailable, restoreRate: defaultGraphQLRestoreRate},
+		limits:   map[string]bucketLimits{},
+		buckets:  map[string]*costBucket{},
+	}
+}
+
+func (t *graphQLThrottle) bucket(shop string) *costBucket {
+	b, ok := t.buckets[shop]
+	if !ok {
+		limits := t.limitsFor(shop)
+		b = &costBucket{bucketLimits: limits, available: limits.maxAvailable, updated: time.Now()}
+		t.buckets[shop] = b
+	}
+	return b
+}
+
+func (t *graphQLThrottle) limitsFor(key string) bucketLimits {
+	for shop, limits := range t.limits {
+		if shop == key || t.shopKey != nil && t.shopKey(shop) == key {
+			return limits
+		}
+	}
+	return t.defaults
+}
+
+// reserve takes the expected cost from the shop's bucket and returns how long
+// to wait before the request can be sent.
+func (t *graphQLThrottle) reserve(shop string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(shop)
+	now := time.Now()
+	b.available = min(b.maxAvailable, b.available+now.Sub(b.updated).Seconds()*b.restoreRate)
+	b.updated = now
+	b.available -= b.cost
+	if b.available >= 0 || b.restoreRate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / b.restoreRate * float64(time.Second))
+}
+
+func (t *graphQLThrottle) update(shop string, cost *GraphQLCost) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(shop)
+	status := cost.ThrottleStatus
+	if status.MaximumAvailable > 0 {
+		b.maxAvailable = status.MaximumAvailable
+	}
+	if status.RestoreRate > 0 {
+		b.restoreRate = status.RestoreRate
+	}
+	b.available = status.CurrentlyAvailable
+	b.cost = cost.RequestedQueryCost
+	b.updated = time.Now()
+}
+
+func (t *graphQLThrottle) wait(req *http.Request, v Version) time.Duration {
+	d := t.reserve(bucketKey(req, v))
+	if d > 0 {
+		SleepContext(req.Context(), d)
+	}
+	return d
+}
+
+// observe reads the cost extension from a GraphQL response and restores the
+// body for the caller. Errors reading the body are returned, e.g.
+// ErrResponseTooLarge.
+func (t *graphQLThrottle) observe(req *http.Request, v Version, resp *http.Response) error {
+	bs, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	var body struct {
+		Extensions struct {
+			Cost *GraphQLCost `json:"cost"`
+		} `json:"extensions"`
+	}
+	if err = jsonUnmarshal(bs, &body); err != nil || body.Extensions.Cost == nil {
+		return nil
+	}
+	t.update(bucketKey(req, v), body.Extensions.Cost)
+	return nil
+}
+
+func (t *graphQLThrottle) applies(req *http.Request) bool {
+	return t != nil && t.enabled && strings.HasSuffix(req.URL.Path, "/graphql.json")
+}
+
+const (
+	XCallLimitHeader = "X-Shopify-Shop-Api-Call-Limit"
+
+	defaultRESTBucketSize = 40
+	// restLeakDivisor derives the leak rate from the bucket size, 2 requests
+	// per second for the standard bucket of 40 and 4 for Plus shops' 80.
+	restLeakDivisor = 20
+)
+
+// restThrottle keeps a local estimate of each shop's REST leaky bucket, one
+// request per call. It's corrected with the X-Shopify-Shop-Api-Call-Limit
+// header, so the larger buckets of Plus shops are picked up automatically.
+type restThrottle struct {
+	mu      sync.Mutex
+	enabled bool
+	size    float64
+	buckets map[string]*restBucket
+}
+
+type restBucket struct {
+	size    float64
+	used    float64
+	updated time.Time
+}
+
+func newRESTThrottle() *restThrottle {
+	return &restThrottle{enabled: true, size: defaultRESTBucketSize, buckets: map[string]*restBucket{}}
+}
+
+func (t *restThrottle) bucket(shop string) *restBucket {
+	b, ok := t.buckets[shop]
+	if !ok {
+		b = &restBucket{size: t.size, updated: time.Now()}
+		t.buckets[shop] = b
+	}
+	now := time.Now()
+	b.used = max(0, b.used-now.Sub(b.updated).Seconds()*b.size/restLeakDivisor)
+	b.updated = now
+	return b
+}
+
+// reserve takes a call from the shop's bucket and returns how long to wait
+// before the request can be sent.
+func (t *restThrottle) reserve(shop string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(shop)
+	b.used++
+	if b.used <= b.size {
+		return 0
+	}
+	return time.Duration((b.used - b.size) / (b.size / restLeakDivisor) * float64(time.Second))
+}
+
+// update applies the call limit header, e.g. 32/40. Calls reserved locally
+// but not yet counted by Shopify are kept.
+func (t *restThrottle) update(shop string, callLimit string) {
+	used, size, ok := strings.Cut(callLimit, "/")
+	if !ok {
+		return
+	}
+	u, err := strconv.ParseFloat(used, 64)
+	if err != nil {
+		return
+	}
+	s, err := strconv.ParseFloat(size, 64)
+	if err != nil || s <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(shop)
+	b.size = s
+	b.used = max(b.used, u)
+}
+
+func (t *restThrottle) wait(req *http.Request, v Version) time.Duration {
+	d := t.reserve(bucketKey(req, v))
+	if d > 0 {
+		SleepContext(req.Context(), d)
+	}
+	return d
+}
+
+func (t *restThrottle) observe(req *http.Request, v Version, resp *http.Response) {
+	if h := resp.Header.Get(XCallLimitHeader); h != "" {
+		t.update(bucketKey(req, v), h)
+	}
+}
+
+// applies reports whether req is a REST call of API version v. Only the
+// version segment of ShopURL is matched, so admin URLs moved by
+// WithAPIHostOverride are throttled too.
+func (t *restThrottle) applies(req *http.Request, v Version) bool {
+	return t != nil && t.enabled && strings.Contains(req.URL.Path, "/api/"+v.String()+"/") &&
+		!strings.HasSuffix(req.URL.Path, "/graphql.json")
+}
+
+// bucketKey identifies the shop of an API call by its admin URL, e.g.
+// test.myshopify.com/admin, which tells shops apart on a shared override host.
+func bucketKey(req *http.Request, v Version) string {
+	base, _, _ := strings.Cut(req.URL.Path, "/api/"+v.String()+"/")
+	return req.URL.Host + base
+}
+
+// shopBucketKey returns the bucketKey of the requests built by ShopURL.
+func (c *Client) shopBucketKey(shop string) string {
+	u, err := url.Parse(c.adminURL(shop))
+	if err != nil {
+		return shop
+	}
+	return u.Host + u.Path
+}
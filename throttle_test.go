@@ -0,0 +1,53 @@
+package shopigo
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ThrottleTestSuite struct {
+	suite.Suite
+}
+
+func TestThrottleTestSuite(t *testing.T) {
+	suite.Run(t, new(ThrottleTestSuite))
+}
+
+func (s *ThrottleTestSuite) TestReserve() {
+	t := newGraphQLThrottle()
+	s.Zero(t.reserve("test.myshopify.com"), "unknown shops start with a full bucket")
+
+	t.update("test.myshopify.com", &GraphQLCost{
+		RequestedQueryCost: 100,
+		ThrottleStatus:     ThrottleStatus{MaximumAvailable: 1000, CurrentlyAvailable: 50, RestoreRate: 50},
+	})
+	d := t.reserve("test.myshopify.com")
+	s.InDelta(time.Second, d, float64(50*time.Millisecond))
+
+	s.Zero(t.reserve("other.myshopify.com"), "buckets are tracked per shop")
+}
+
+func (s *ThrottleTestSuite) TestShopLimits() {
+	t := newGraphQLThrottle()
+	t.limits["plus.myshopify.com"] = bucketLimits{maxAvailable: 2000, restoreRate: 100}
+	b := t.bucket("plus.myshopify.com")
+	s.Equal(float64(2000), b.available)
+	s.Equal(float64(100), b.restoreRate)
+}
+
+func (s *ThrottleTestSuite) TestRESTReserve() {
+	t := newRESTThrottle()
+	for i := 0; i < defaultRESTBucketSize; i++ {
+		s.Zero(t.reserve("test.myshopify.com"))
+	}
+	s.InDelta(500*time.Millisecond, t.reserve("test.myshopify.com"), float64(50*time.Millisecond),
+		"the standard bucket leaks 2 requests per second")
+	s.Zero(t.reserve("other.myshopify.com"), "buckets are tracked per shop")
+
+	t.update("plus.myshopify.com", "80/80")
+	s.InDelta(250*time.Millisecond, t.reserve("plus.myshopify.com"), float64(50*time.Millisecond),
+		"bucket size and leak rate adapt to the call limit header")
+	t.update("plus.myshopify.com", "1/80")
+	s.InDelta(81, t.buckets["plus.myshopify.com"].used, 0.5, "calls in flight are kept")
+}
@@ -2,13 +2,34 @@ package shopigo
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
-	"sort"
-	"strings"
 )
 
+type TokenType string
+
+const (
+	OfflineAccessToken TokenType = "urn:shopify:params:oauth:token-type:offline-access-token"
+	OnlineAccessToken  TokenType = "urn:shopify:params:oauth:token-type:online-access-token"
+
+	idTokenType            = "urn:ietf:params:oauth:token-type:id_token"
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// TokenParam is an extra parameter of access token requests.
+type TokenParam struct {
+	Key   string
+	Value string
+}
+
+// GrantOptions requests a token with the grant options, e.g. "per-user" for
+// online access.
+func GrantOptions(value string) TokenParam {
+	return TokenParam{Key: "grant_options[]", Value: value}
+}
+
 type AccessToken struct {
 	Token             string `json:"access_token"`
 	Scopes            string `json:"scope"`
@@ -24,7 +45,7 @@ type OnlineAccessInfo struct {
 type User struct {
 	ID            int    `json:"id"`
 	FirstName     string `json:"first_name"`
-	LastName      string `json:"'last_name'"`
+	LastName      string `json:"last_name"`
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
 	AccountOwner  bool   `json:"account_owner"`
@@ -32,28 +53,115 @@ type User struct {
 	Collaborator  bool   `json:"collaborator"`
 }
 
+type OAuthError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("oauth request failed, status: %d, detail: %s", e.StatusCode, e.Description)
+	}
+	return fmt.Sprintf("oauth request failed, status: %d, error: %s, detail: %s", e.StatusCode, e.Code, e.Description)
+}
+
 func (a *App) AccessToken(shop string, code string) (*AccessToken, error) {
-	accessTokenPath := "admin/oauth/access_token"
-	accessTokenEndPoint := fmt.Sprintf("https://%s/%s", shop, accessTokenPath)
-	params, err := json.Marshal(map[string]string{
+	return a.AccessTokenContext(context.Background(), shop, code)
+}
+
+func (a *App) AccessTokenContext(ctx context.Context, shop string, code string) (*AccessToken, error) {
+	return a.requestAccessToken(ctx, shop, map[string]string{
 		"client_id":     a.Credentials.ClientID,
 		"client_secret": a.Credentials.ClientSecret,
 		"code":          code,
 	})
+}
+
+// ExchangeCode exchanges the authorization code received by the OAuth
+// callback for a session. Unlike Install, it neither verifies the request nor
+// stores the session. The params are sent along with the code, but can't
+// replace the credentials or the code.
+func (a *App) ExchangeCode(ctx context.Context, shop string, code string, params ...TokenParam) (*Session, error) {
+	shop, err := a.sanitizeShop(shop)
 	if err != nil {
 		return nil, err
 	}
-	res, err := http.Post(accessTokenEndPoint, "application/json", bytes.NewBuffer(params))
+	body := make(map[string]string, len(params)+3)
+	for _, p := range params {
+		body[p.Key] = p.Value
+	}
+	body["client_id"] = a.Credentials.ClientID
+	body["client_secret"] = a.Credentials.ClientSecret
+	body["code"] = code
+	token, err := a.requestAccessToken(ctx, shop, body)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
-	var token AccessToken
-	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+	return a.createSession(shop, "", token), nil
+}
+
+func (a *App) TokenExchange(ctx context.Context, shop string, sessionToken string, requestedTokenType TokenType) (*Session, error) {
+	shop, err := a.sanitizeShop(shop)
+	if err != nil {
+		return nil, err
+	}
+	token, err := a.requestAccessToken(ctx, shop, map[string]string{
+		"client_id":            a.Credentials.ClientID,
+		"client_secret":        a.Credentials.ClientSecret,
+		"grant_type":           tokenExchangeGrantType,
+		"subject_token":        sessionToken,
+		"subject_token_type":   idTokenType,
+		"requested_token_type": string(requestedTokenType),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sess := a.createSession(shop, "", token)
+	if err = a.SessionStore.Store(ctx, sess); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+	if err = a.invalidateShop(ctx, shop); err != nil {
+		a.Client.logger.Warn("failed to invalidate shop cache", "shop", shop, "error", err)
+	}
+	a.notify(func(obs LifecycleObserver) { obs.OnTokenRefreshed(ctx, shop) })
+	return sess, nil
+}
+
+func (a *App) requestAccessToken(ctx context.Context, shop string, params map[string]string) (*AccessToken, error) {
+	body, err := jsonMarshal(params)
+	if err != nil {
 		return nil, err
 	}
-	scopes := strings.Split(token.Scopes, ",")
-	sort.Slice(scopes, func(i, j int) bool { return i < j })
-	token.Scopes = strings.Join(scopes, ",")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		a.adminURL(shop)+a.tokenEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("access token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	resp.Body = limitBody(resp.Body, a.maxResponseBytes)
+	if resp.StatusCode >= 400 {
+		bs, _ := io.ReadAll(resp.Body)
+		oauthErr := OAuthError{StatusCode: resp.StatusCode}
+		if err = jsonUnmarshal(bs, &oauthErr); err != nil {
+			oauthErr.Description = string(bs)
+		}
+		return nil, &oauthErr
+	}
+	var token AccessToken
+	if err = decodeJSON(resp.Body, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if a.unsortedScopes {
+		token.Scopes = ParseScopesUnsorted(token.Scopes).orderedBy(a.scopes).String()
+	} else {
+		token.Scopes = ParseScopes(token.Scopes).String()
+	}
 	return &token, nil
 }
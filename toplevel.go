@@ -0,0 +1,79 @@
+package shopigo
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const TopLevelCookie = "shopify_top_level_oauth"
+
+// defaultTopLevelRedirectTemplate breaks out of the admin iframe, Shopify
+// refuses to render the authorize page inside of it.
+var defaultTopLevelRedirectTemplate = template.Must(template.New("top-level-redirect").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Redirecting</title></head>
+<body>
+<script>window.top.location.href = {{.RedirectURL}};</script>
+<noscript><a href="{{.RedirectURL}}" target="_top">Continue</a></noscript>
+</body>
+</html>`))
+
+// TopLevelRedirectData is passed to the template of WithTopLevelRedirectTemplate.
+type TopLevelRedirectData struct {
+	Shop        string
+	RedirectURL string
+}
+
+// WithTopLevelRedirectTemplate replaces the page served by Begin inside the
+// admin iframe, which has to navigate the top window to the RedirectURL of
+// TopLevelRedirectData.
+func WithTopLevelRedirectTemplate(tmpl *template.Template) Opt {
+	return func(a *App) {
+		a.topLevelRedirectTemplate = tmpl
+	}
+}
+
+// inIframe reports whether the request is loaded by the admin iframe. Browsers
+// send Sec-Fetch-Dest, otherwise requests of the admin marked with
+// embedded=1 are assumed to be framed unless they carry the SameSite=Lax
+// top-level cookie, which isn't sent to cross-site iframes.
+func (a *App) inIframe(c *gin.Context) bool {
+	switch c.GetHeader("Sec-Fetch-Dest") {
+	case "iframe":
+		return true
+	case "":
+		_, err := c.Cookie(a.cookies.name(TopLevelCookie))
+		return c.Query("embedded") == "1" && err != nil
+	}
+	return false
+}
+
+func (a *App) setTopLevelCookie(c *gin.Context) {
+	o := a.cookies
+	o.SameSite = http.SameSiteLaxMode
+	o.Partitioned = false
+	o.set(c, &http.Cookie{Name: TopLevelCookie, Value: "1", Path: "/", Expires: time.Now().Add(a.nonceTTL)})
+}
+
+// topLevelRedirect serves a page loading the current begin URL in the top
+// window.
+func (a *App) topLevelRedirect(c *gin.Context, shop string) {
+	query := c.Request.URL.Query()
+	query.Del("embedded")
+	query.Set("shop", shop)
+	tmpl := a.topLevelRedirectTemplate
+	if tmpl == nil {
+		tmpl = defaultTopLevelRedirectTemplate
+	}
+	var page bytes.Buffer
+	err := tmpl.Execute(&page, TopLevelRedirectData{Shop: shop, RedirectURL: a.HostURL + c.Request.URL.Path + "?" + query.Encode()})
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+	c.Abort()
+}
@@ -0,0 +1,58 @@
+package shopigo
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// TransportTuning configures the connection pool of the HTTP transport. The
+// fields have the meaning of the http.Transport fields of the same name.
+// Every shop is a host of its own, so apps serving many shops need idle
+// connections per host to avoid dialing and TLS handshakes per request.
+type TransportTuning struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// DefaultTransportTuning is the tuning of the default transport. Go's default
+// keeps only 2 idle connections per host.
+var DefaultTransportTuning = TransportTuning{
+	MaxIdleConns:        1000,
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// newTransport returns a copy of http.DefaultTransport tuned by t.
+func newTransport(t TransportTuning) *http.Transport {
+	return tuneTransport(http.DefaultTransport.(*http.Transport).Clone(), t)
+}
+
+func tuneTransport(tr *http.Transport, t TransportTuning) *http.Transport {
+	tr.MaxIdleConns = t.MaxIdleConns
+	tr.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	tr.MaxConnsPerHost = t.MaxConnsPerHost
+	tr.IdleConnTimeout = t.IdleConnTimeout
+	return tr
+}
+
+// WithTransportTuning tunes the connection pool of the HTTP client, see
+// DefaultTransportTuning. Given after WithHTTPClient it tunes a copy of the
+// client's transport, which must be an *http.Transport.
+func WithTransportTuning(t TransportTuning) Opt {
+	return func(a *App) {
+		client := *a.http
+		switch tr := client.Transport.(type) {
+		case nil:
+			client.Transport = newTransport(t)
+		case *http.Transport:
+			client.Transport = tuneTransport(tr.Clone(), t)
+		default:
+			a.optError(errors.New("transport tuning requires an *http.Transport"))
+			return
+		}
+		a.http = &client
+	}
+}
@@ -0,0 +1,53 @@
+package shopigo
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+)
+
+const TopicAppUninstalled = "app/uninstalled"
+
+// ShopSessionDeleter is implemented by session stores which can delete all
+// sessions of a shop, online and offline.
+type ShopSessionDeleter interface {
+	DeleteShop(ctx context.Context, shop string) error
+}
+
+// DeleteShopSessions deletes all sessions of the shop if the store supports
+// it, otherwise only the offline session. Online sessions expire on their own.
+func DeleteShopSessions(ctx context.Context, store SessionStore, shop string) error {
+	if d, ok := store.(ShopSessionDeleter); ok {
+		return d.DeleteShop(ctx, shop)
+	}
+	if err := store.Delete(ctx, OfflineSessionID(shop)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// HandleUninstalled deletes the sessions of the uninstalled shop and runs
+// the uninstall hook. Register it on a WebhookRouter for app/uninstalled or use
+// UninstallHandler. Failures of the session store are retried, the hook can
+// return a RetryLaterError to be retried as well.
+func (a *App) HandleUninstalled(ctx context.Context, wh *WebhookContext) error {
+	if err := DeleteShopSessions(ctx, a.SessionStore, wh.Shop); err != nil {
+		return RetryLater(fmt.Errorf("failed to delete sessions of %s: %w", wh.Shop, err))
+	}
+	if err := a.invalidateShop(ctx, wh.Shop); err != nil {
+		return RetryLater(err)
+	}
+	if a.uninstallHook != nil {
+		if err := a.uninstallHook(ctx, wh.Shop); err != nil {
+			return err
+		}
+	}
+	a.notify(func(obs LifecycleObserver) { obs.OnUninstalled(ctx, wh.Shop) })
+	return nil
+}
+
+// UninstallHandler verifies and handles the app/uninstalled webhook, mount it
+// on the path passed to WithUninstallWebhookEndpoint.
+func (a *App) UninstallHandler(c *gin.Context) {
+	a.NewWebhookRouter().On(TopicAppUninstalled, a.HandleUninstalled).Handle(c)
+}
@@ -0,0 +1,171 @@
+package shopigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+)
+
+type StagedUploadResource string
+
+const (
+	StagedUploadImage                 StagedUploadResource = "IMAGE"
+	StagedUploadFile                  StagedUploadResource = "FILE"
+	StagedUploadVideo                 StagedUploadResource = "VIDEO"
+	StagedUploadBulkMutationVariables StagedUploadResource = "BULK_MUTATION_VARIABLES"
+)
+
+// StagedUploadInput describes the file to upload. HTTPMethod defaults to POST,
+// which uploads a multipart form, PUT uploads the raw file.
+type StagedUploadInput struct {
+	Filename   string
+	MimeType   string
+	Resource   StagedUploadResource
+	FileSize   int64
+	HTTPMethod string
+}
+
+type StagedUploadParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type StagedUploadTarget struct {
+	URL         string                  `json:"url"`
+	ResourceURL string                  `json:"resourceUrl"`
+	Parameters  []StagedUploadParameter `json:"parameters"`
+}
+
+// StagedUpload creates a staged upload target and uploads r to it. The
+// returned URL is referenced in subsequent mutations, e.g. as originalSource
+// of productCreateMedia. Targets without a resource URL, such as those for
+// bulk mutation variables, return the key parameter, which is the
+// stagedUploadPath of bulkOperationRunMutation.
+func (c *Client) StagedUpload(ctx context.Context, sess *Session, in StagedUploadInput, r io.Reader) (string, error) {
+	target, err := c.StagedUploadTarget(ctx, sess, in)
+	if err != nil {
+		return "", err
+	}
+	if in.HTTPMethod == http.MethodPut {
+		err = c.uploadPut(ctx, target, in, r)
+	} else {
+		err = c.uploadPost(ctx, target, in, r)
+	}
+	if err != nil {
+		return "", err
+	}
+	if target.ResourceURL != "" {
+		return target.ResourceURL, nil
+	}
+	for _, p := range target.Parameters {
+		if p.Name == "key" {
+			return p.Value, nil
+		}
+	}
+	return "", errors.New("staged upload target has neither resource url nor key")
+}
+
+func (c *Client) StagedUploadTarget(ctx context.Context, sess *Session, in StagedUploadInput) (*StagedUploadTarget, error) {
+	input := map[string]any{
+		"filename": in.Filename,
+		"mimeType": in.MimeType,
+		"resource": in.Resource,
+	}
+	if in.FileSize > 0 {
+		input["fileSize"] = strconv.FormatInt(in.FileSize, 10)
+	}
+	if in.HTTPMethod != "" {
+		input["httpMethod"] = in.HTTPMethod
+	}
+	var out struct {
+		StagedUploadsCreate struct {
+			StagedTargets []StagedUploadTarget `json:"stagedTargets"`
+		} `json:"stagedUploadsCreate"`
+	}
+	err := c.GraphQL(ctx, sess, `mutation stagedUploadsCreate($input: [StagedUploadInput!]!) {
+		stagedUploadsCreate(input: $input) {
+			stagedTargets { url resourceUrl parameters { name value } }
+			userErrors { field message }
+		}
+	}`, map[string]any{"input": []map[string]any{input}}, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staged upload: %w", err)
+	}
+	if len(out.StagedUploadsCreate.StagedTargets) == 0 {
+		return nil, errors.New("no staged upload target returned")
+	}
+	return &out.StagedUploadsCreate.StagedTargets[0], nil
+}
+
+// uploadPost sends the target's parameters as form fields in the returned
+// order followed by the file, as required by the S3 and Google Cloud Storage
+// policies.
+func (c *Client) uploadPost(ctx context.Context, target *StagedUploadTarget, in StagedUploadInput, r io.Reader) error {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		for _, p := range target.Parameters {
+			if err := form.WriteField(p.Name, p.Value); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", in.Filename)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, pr)
+	if err != nil {
+		_ = pr.Close()
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return c.upload(req)
+}
+
+// uploadPut sends the raw file, the target's parameters are sent as headers.
+func (c *Client) uploadPut(ctx context.Context, target *StagedUploadTarget, in StagedUploadInput, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.URL, r)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	if in.FileSize > 0 {
+		req.ContentLength = in.FileSize
+	}
+	req.Header.Set("Content-Type", in.MimeType)
+	for _, p := range target.Parameters {
+		switch p.Name {
+		case "content_type":
+			req.Header.Set("Content-Type", p.Value)
+		case "acl":
+			req.Header.Set("x-goog-acl", p.Value)
+		default:
+			req.Header.Set(p.Name, p.Value)
+		}
+	}
+	return c.upload(req)
+}
+
+// upload sends the request with the plain HTTP client, the staging target
+// isn't part of the Admin API.
+func (c *Client) upload(req *http.Request) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		bs, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed, status: %d, detail: %s", resp.StatusCode, string(bs))
+	}
+	return nil
+}
@@ -4,16 +4,62 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strings"
+	"unicode"
 )
 
 func (a *App) sanitizeShop(shop string) (string, error) {
+	if a.shopValidator != nil {
+		trimmed, err := trimShop(shop)
+		if err != nil {
+			return "", err
+		}
+		if !a.shopValidator(trimmed) {
+			return "", fmt.Errorf("malformed shop: %s", shop)
+		}
+		return trimmed, nil
+	}
 	if !a.shopRegexp.MatchString(shop) {
 		return "", fmt.Errorf("malformed shop: %s", shop)
 	}
 	return shop, nil
 }
 
+// NormalizeShop turns merchant input such as "my-store" or
+// "https://my-store.myshopify.com/admin" into the canonical shop domain.
+func (a *App) NormalizeShop(input string) (string, error) {
+	shop, err := trimShop(input)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(shop, ".") {
+		shop += ".myshopify.com"
+	}
+	return a.sanitizeShop(shop)
+}
+
+// trimShop lower cases input and strips it of scheme and path.
+func trimShop(input string) (string, error) {
+	shop := strings.TrimSpace(input)
+	if shop == "" {
+		return "", errors.New("shop must not be empty")
+	}
+	if strings.ContainsFunc(shop, unicode.IsSpace) {
+		return "", fmt.Errorf("shop must not contain whitespace: %q", input)
+	}
+	if _, rest, ok := strings.Cut(shop, "://"); ok {
+		shop = rest
+	}
+	shop, _, _ = strings.Cut(shop, "/")
+	shop = strings.ToLower(shop)
+	if strings.Contains(shop, "..") || strings.HasPrefix(shop, ".") || strings.HasSuffix(shop, ".") {
+		return "", fmt.Errorf("shop contains empty domain label: %q", input)
+	}
+	return shop, nil
+}
+
 func (a *App) sanitizeHost(host string) (string, error) {
 	host, err := decodeHost(host)
 	if err != nil {
@@ -30,6 +76,29 @@ func (a *App) sanitizeHost(host string) (string, error) {
 	return host, nil
 }
 
+// HostFromRequest returns the host param of the request if it belongs to the
+// shop param, an empty string otherwise. The host is the base64 encoded admin
+// URL of the shop App Bridge is initialized with.
+func HostFromRequest(r *http.Request) string {
+	query := r.URL.Query()
+	return validHost(query.Get("host"), query.Get("shop"))
+}
+
+// validHost returns host if it decodes to the admin of shop, either
+// admin.shopify.com/store/<name> or <shop>/admin.
+func validHost(host string, shop string) string {
+	decoded, err := decodeHost(host)
+	if err != nil || shop == "" {
+		return ""
+	}
+	decoded = strings.TrimSuffix(decoded, "/")
+	name, _, _ := strings.Cut(shop, ".")
+	if decoded != shop+"/admin" && decoded != "admin.shopify.com/store/"+name {
+		return ""
+	}
+	return host
+}
+
 func decodeHost(host string) (string, error) {
 	if host == "" {
 		return "", errors.New("host must not be empty")
@@ -2,7 +2,12 @@ package shopigo
 
 import (
 	"encoding/base64"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -23,7 +28,7 @@ func (s *UtilTestSuite) TestSanitizeShop() {
 		"test.myshopify.com////",
 		"test_123-xyz.myshopify.com",
 	} {
-		a, err := NewApp(NewAppConfig())
+		a, err := NewApp(testAppConfig())
 		s.NoError(err)
 		shop, err := a.sanitizeShop(exp)
 		s.NoError(err)
@@ -36,7 +41,7 @@ func (s *UtilTestSuite) TestSanitizeShopWithCustomDomains() {
 		"test.example.com",
 		"test.another-example.com",
 	} {
-		a, err := NewApp(NewAppConfig(), WithCustomShopDomains("example.com", "another-example.com"))
+		a, err := NewApp(testAppConfig(), WithCustomShopDomains("example.com", "another-example.com"))
 		s.NoError(err)
 		shop, err := a.sanitizeShop(exp)
 		s.NoError(err)
@@ -53,18 +58,46 @@ func (s *UtilTestSuite) TestFailSanitizeShop() {
 		"sub.test.myshopify.com ",
 		"test.unknown.io",
 	} {
-		a, err := NewApp(NewAppConfig())
+		a, err := NewApp(testAppConfig())
 		s.NoError(err)
 		_, err = a.sanitizeShop(exp)
 		s.Error(err)
 	}
 }
 
+func (s *UtilTestSuite) TestShopDomains() {
+	a, err := NewApp(testAppConfig(), WithCustomShopDomains("Example.com"))
+	s.Require().NoError(err)
+	for shop, valid := range map[string]bool{
+		"test.myshopify.com":                       true,
+		"test.example.com":                         true,
+		"0-test_store.myshopify.com/":              true,
+		strings.Repeat("a", 63) + ".myshopify.com": true,
+		strings.Repeat("a", 64) + ".myshopify.com": false,
+		"foo.myshopify.com.evil.com":               false,
+		"foo.myshopify.com.":                       false,
+		"foo.myshopifyXcom":                        false,
+		"fooXmyshopify.com":                        false,
+		"foo.examplexcom":                          false,
+		"Test.myshopify.com":                       false,
+		"TEST.MYSHOPIFY.COM":                       false,
+		"-test.myshopify.com":                      false,
+		"test.myshopify.com/admin":                 false,
+		"test.myshopify.com?x=1":                   false,
+		"test.myshopify.com\n":                     false,
+		"evil.com/test.myshopify.com":              false,
+		"test.myshopify.com@evil.com":              false,
+	} {
+		_, err := a.sanitizeShop(shop)
+		s.Equal(valid, err == nil, shop)
+	}
+}
+
 func (s *UtilTestSuite) TestSanitizeHost() {
 	for _, exp := range []string{
 		"test.myshopify.com/test/another",
 	} {
-		a, err := NewApp(NewAppConfig())
+		a, err := NewApp(testAppConfig())
 		s.NoError(err)
 		shop, err := a.sanitizeHost(base64.RawURLEncoding.EncodeToString([]byte(exp)))
 		s.NoError(err)
@@ -76,9 +109,101 @@ func (s *UtilTestSuite) TestFailSanitizeHost() {
 	for _, exp := range []string{
 		base64.URLEncoding.EncodeToString([]byte("sub.test.myshopify.com/test/another")),
 	} {
-		a, err := NewApp(NewAppConfig())
+		a, err := NewApp(testAppConfig())
 		s.NoError(err)
 		_, err = a.sanitizeHost(exp)
 		s.Error(err)
 	}
 }
+
+func (s *UtilTestSuite) TestNormalizeShop() {
+	a, err := NewApp(testAppConfig(), WithCustomShopDomains("example.com"))
+	s.Require().NoError(err)
+	for in, exp := range map[string]string{
+		"my-store":                               "my-store.myshopify.com",
+		" My-Store ":                             "my-store.myshopify.com",
+		"my-store.myshopify.com":                 "my-store.myshopify.com",
+		"https://my-store.myshopify.com/admin":   "my-store.myshopify.com",
+		"my-store.myshopify.com/admin/products/": "my-store.myshopify.com",
+		"my-store.example.com":                   "my-store.example.com",
+	} {
+		shop, err := a.NormalizeShop(in)
+		s.NoError(err, in)
+		s.Equal(exp, shop, in)
+	}
+	for _, in := range []string{"", "my store", "my-store..myshopify.com", ".myshopify.com", "my-store.unknown.io"} {
+		_, err := a.NormalizeShop(in)
+		s.Error(err, in)
+	}
+}
+
+func (s *UtilTestSuite) TestShopValidator() {
+	allowed := map[string]bool{"shop.example.org": true, "my-store.myshopify.com": true}
+	a, err := NewApp(testAppConfig(), WithShopValidator(func(shop string) bool { return allowed[shop] }))
+	s.Require().NoError(err)
+	for in, exp := range map[string]string{
+		"https://Shop.Example.org/admin": "shop.example.org",
+		"my-store":                       "my-store.myshopify.com",
+	} {
+		shop, err := a.NormalizeShop(in)
+		s.NoError(err, in)
+		s.Equal(exp, shop, in)
+	}
+	_, err = a.NormalizeShop("other-store.myshopify.com")
+	s.Error(err)
+	shop, err := a.sanitizeShop("Shop.Example.org")
+	s.NoError(err)
+	s.Equal("shop.example.org", shop, "shops of requests must be normalized before being validated")
+
+	_, e := gin.CreateTestContext(httptest.NewRecorder())
+	e.GET("/auth/begin", a.Begin)
+	for shop, status := range map[string]int{"shop.example.org": http.StatusFound, "other.example.org": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/begin?shop="+shop, nil))
+		s.Equal(status, rec.Code, shop)
+	}
+}
+
+func (s *UtilTestSuite) TestHostFromRequest() {
+	encode := base64.RawURLEncoding.EncodeToString
+	for host, valid := range map[string]bool{
+		encode([]byte("admin.shopify.com/store/my-store")):  true,
+		encode([]byte("my-store.myshopify.com/admin")):      true,
+		encode([]byte("admin.shopify.com/store/evil-shop")): false,
+		encode([]byte("evil.example.com/admin")):            false,
+		"not base64!":                                       false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?shop=my-store.myshopify.com&host="+url.QueryEscape(host), nil)
+		if valid {
+			s.Equal(host, HostFromRequest(req), host)
+		} else {
+			s.Empty(HostFromRequest(req), host)
+		}
+	}
+}
+
+func (s *UtilTestSuite) TestGID() {
+	s.Equal("gid://shopify/Product/123", ProductGID(123))
+	s.Equal("gid://shopify/ProductVariant/7", GID(ResourceProductVariant, 7))
+
+	resource, id, err := ParseGID("gid://shopify/Order/450789469")
+	s.Require().NoError(err)
+	s.Equal(ResourceOrder, resource)
+	s.Equal(int64(450789469), id)
+	_, id, err = ParseGID("gid://shopify/InventoryLevel/1?inventory_item_id=2")
+	s.Require().NoError(err)
+	s.Equal(int64(1), id)
+
+	for _, gid := range []string{"", "123", "gid://other/Product/1", "gid://shopify/Product", "gid://shopify/Product/",
+		"gid://shopify//1", "gid://shopify/product/1", "gid://shopify/Product/abc", "gid://shopify/Product/-1",
+		"gid://shopify/Product/+1", "gid://shopify/Product/1/2", "gid://shopify/Product/99999999999999999999"} {
+		_, _, err = ParseGID(gid)
+		s.Error(err, gid)
+	}
+
+	id, err = LegacyID(ResourceCustomer, CustomerGID(42))
+	s.Require().NoError(err)
+	s.Equal(int64(42), id)
+	_, err = LegacyID(ResourceCustomer, OrderGID(42))
+	s.Error(err)
+}
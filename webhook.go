@@ -2,14 +2,15 @@ package shopigo
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
+	log "log/slog"
 	"net/http"
 	"net/url"
 )
@@ -18,6 +19,9 @@ const (
 	XDomainHeader = "x-shopify-shop-domain"
 	XHmacHeader   = "X-Shopify-Hmac-SHA256"
 	XAccessToken  = "X-Shopify-Access-Token"
+	// WebhookBodyKey holds the raw body of the webhook verified by
+	// VerifyWebhook.
+	WebhookBodyKey = "ShopifyWebhookBodyKey"
 )
 
 type WebhookRequest struct {
@@ -31,78 +35,198 @@ type Webhook struct {
 	Format  string   `json:"format,omitempty"`
 }
 
-type Customer struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
-	Phone string `json:"phone"`
+func (c *Client) RegisterWebhook(wh *Webhook, sess *Session) (id int, err error) {
+	return c.RegisterWebhookContext(context.Background(), wh, sess)
 }
 
-func (c *Client) RegisterWebhook(wh *Webhook, sess *Session) (id int, err error) {
-	wh.Address, err = url.JoinPath(c.hostURL, wh.Address)
-	body, err := json.Marshal(WebhookRequest{Webhook: wh})
+func (c *Client) RegisterWebhookContext(ctx context.Context, wh *Webhook, sess *Session) (id int, err error) {
+	sess = c.sessionOr(sess)
+	if wh.Address, err = url.JoinPath(c.hostURL, wh.Address); err != nil {
+		return 0, err
+	}
+	body, err := jsonMarshal(WebhookRequest{Webhook: wh})
 	if err != nil {
 		return 0, err
 	}
-	req, err := http.NewRequest(http.MethodPost, c.ShopURL(sess.Shop, "/webhooks.json"), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ShopURL(sess.Shop, "/webhooks.json"), bytes.NewBuffer(body))
 	if err != nil {
 		return 0, err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add(XAccessToken, sess.AccessToken)
+	c.authenticate(req, sess)
 	resp, err := c.Do(req)
 	if err != nil {
 		return 0, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		bs, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("failed to register webhook, status: %d, cause: %s", resp.StatusCode, string(bs))
+		return 0, fmt.Errorf("failed to register webhook: %w", newShopifyAPIError(resp))
 	}
 	var whResp = struct {
 		Webhook struct {
 			ID int `json:"id"`
 		} `json:"webhook"`
 	}{}
-	if err = json.NewDecoder(resp.Body).Decode(&whResp); err != nil {
+	if err = decodeJSON(resp.Body, &whResp); err != nil {
 		return 0, err
 	}
 	return whResp.Webhook.ID, nil
 }
 
 func (c *Client) DeleteWebhook(id int, sess *Session) error {
-	req, err := http.NewRequest(http.MethodDelete, c.ShopURL(sess.Shop, fmt.Sprintf("/webhooks/%d.json", id)), nil)
+	return c.DeleteWebhookContext(context.Background(), id, sess)
+}
+
+func (c *Client) DeleteWebhookContext(ctx context.Context, id int, sess *Session) error {
+	sess = c.sessionOr(sess)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.ShopURL(sess.Shop, fmt.Sprintf("/webhooks/%d.json", id)), nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add(XAccessToken, sess.AccessToken)
+	c.authenticate(req, sess)
 	resp, err := c.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		bs, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("status: %d, err: %s", resp.StatusCode, string(bs))
+		return fmt.Errorf("failed to delete webhook: %w", newShopifyAPIError(resp))
 	}
 	return nil
 }
 
+// VerifyWebhook checks the HMAC of the raw body, whatever its content type.
+// The body is restored for the following handlers and available through
+// WebhookBody. Webhooks are only verified once, handlers may read the body
+// in between. See VerifyWebhookStreaming for bodies too large to buffer.
+//
+// Used as middleware, the webhook is dropped from the dedup store if the
+// following handlers panic, fail with errors or respond with a status of 300
+// or more, so Shopify's retry isn't skipped as duplicate.
 func (a *App) VerifyWebhook(c *gin.Context) {
-	hash := hmac.New(sha256.New, []byte(a.ClientSecret))
+	if !a.verifyWebhook(c) {
+		return
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			a.forgetWebhook(c.Request.Context(), c.GetHeader(XWebhookIDHeader))
+		}
+	}()
+	c.Next()
+	succeeded = c.Writer.Status() < http.StatusMultipleChoices && len(c.Errors) == 0
+}
+
+// verifyWebhook verifies the webhook and records it in the dedup store. It
+// returns whether the webhook was verified and recorded by this call, false if
+// it was aborted or verified before.
+func (a *App) verifyWebhook(c *gin.Context) bool {
+	if _, ok := c.Get(WebhookBodyKey); ok {
+		return false
+	}
 	bs, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return false
 	}
-	if _, err = hash.Write(bs); err != nil {
-		_ = c.AbortWithError(http.StatusUnauthorized, err)
-		return
+	if len(bs) == 0 && len(c.Request.PostForm) > 0 {
+		_ = c.AbortWithError(http.StatusInternalServerError,
+			errors.New("webhook body was parsed as form before its verification"))
+		return false
 	}
 	c.Request.Body = io.NopCloser(bytes.NewReader(bs))
-	mac := base64.StdEncoding.EncodeToString(hash.Sum(nil))
-	if !hmac.Equal([]byte(mac), []byte(c.GetHeader(XHmacHeader))) {
+	if !VerifyWebhookHMAC(bs, c.GetHeader(XHmacHeader), a.ClientSecret) {
 		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("invalid webhook header"))
+		return false
+	}
+	c.Set(WebhookBodyKey, bs)
+	id := c.GetHeader(XWebhookIDHeader)
+	seen, err := a.seenWebhook(c.Request.Context(), id)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	if seen {
+		a.logger(c).Info("skipping duplicate webhook", log.String("webhook", id))
+		c.AbortWithStatus(http.StatusOK)
+		return false
+	}
+	return true
+}
+
+// WebhookBody returns the raw body of the webhook verified by VerifyWebhook,
+// nil if it wasn't verified.
+func WebhookBody(c *gin.Context) []byte {
+	bs, _ := c.Get(WebhookBodyKey)
+	body, _ := bs.([]byte)
+	return body
+}
+
+func (a *App) seenWebhook(ctx context.Context, id string) (bool, error) {
+	if a.webhookDedup == nil || id == "" {
+		return false, nil
+	}
+	return a.webhookDedup.SeenBefore(ctx, id, a.webhookDedupTTL)
+}
+
+// checkWebhook reports whether id was seen before without recording it,
+// false if the dedup store can't check IDs.
+func (a *App) checkWebhook(ctx context.Context, id string) (bool, error) {
+	checker, ok := a.webhookDedup.(DedupChecker)
+	if !ok || id == "" {
+		return false, nil
+	}
+	return checker.Seen(ctx, id)
+}
+
+// forgetWebhook removes id from the dedup store, so the retried delivery
+// isn't skipped.
+func (a *App) forgetWebhook(ctx context.Context, id string) {
+	if id == "" {
 		return
 	}
+	f, ok := a.webhookDedup.(DedupForgetter)
+	if !ok {
+		if a.webhookDedup != nil {
+			a.Client.logger.Warn("dedup store can't forget webhooks, the retry will be skipped", "webhook", id)
+		}
+		return
+	}
+	if err := f.Forget(ctx, id); err != nil {
+		a.Client.logger.Warn("failed to forget webhook", "webhook", id, "error", err)
+	}
+}
+
+// VerifyWebhook returns net/http middleware rejecting webhooks whose HMAC
+// doesn't match secret, the app's ClientSecret, with 401. The body is restored
+// for next. Use App.VerifyWebhook on gin, which also deduplicates webhooks.
+func VerifyWebhook(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bs, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed to read webhook body", http.StatusInternalServerError)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(bs))
+			if !VerifyWebhookHMAC(bs, r.Header.Get(XHmacHeader), secret) {
+				http.Error(w, "invalid webhook header", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// VerifyWebhookHMAC checks the base64 encoded HMAC-SHA256 of the raw webhook
+// body as sent in the X-Shopify-Hmac-SHA256 header.
+func VerifyWebhookHMAC(body []byte, header string, secret string) bool {
+	mac, err := base64.StdEncoding.DecodeString(header)
+	if err != nil || len(mac) == 0 {
+		return false
+	}
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write(body)
+	return hmac.Equal(mac, hash.Sum(nil))
 }
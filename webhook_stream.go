@@ -0,0 +1,120 @@
+package shopigo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"hash"
+	"io"
+	log "log/slog"
+	"net/http"
+)
+
+// ErrInvalidWebhookSignature is returned when reading the end of a webhook
+// body verified by VerifyWebhookStreaming if its HMAC doesn't match.
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// hmacReader hashes the body while it's read and fails its end if the HMAC
+// doesn't match.
+type hmacReader struct {
+	body io.Reader
+	hash hash.Hash
+	mac  []byte
+	// err is the result of the verification once the body was read.
+	err  error
+	done bool
+}
+
+func (h *hmacReader) Read(p []byte) (int, error) {
+	if h.done {
+		return 0, h.eof()
+	}
+	n, err := h.body.Read(p)
+	h.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		h.done = true
+		if !hmac.Equal(h.mac, h.hash.Sum(nil)) {
+			h.err = ErrInvalidWebhookSignature
+		}
+		return n, h.eof()
+	}
+	return n, err
+}
+
+func (h *hmacReader) eof() error {
+	if h.err != nil {
+		return h.err
+	}
+	return io.EOF
+}
+
+// verify reads the rest of the body and returns the result of the
+// verification.
+func (h *hmacReader) verify() error {
+	if _, err := io.Copy(io.Discard, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// VerifyWebhookStreaming checks the HMAC of the webhook body while the
+// following handlers read it, instead of buffering the body like
+// VerifyWebhook. Reading the end of a body with an invalid HMAC fails with
+// ErrInvalidWebhookSignature and the response is replaced by 401, unless the
+// handlers already wrote it, in which case the error is logged and added to
+// the context.
+//
+// The handlers run before the webhook is verified, so they must not act on
+// the payload before reading it to the end, e.g. by committing only after
+// decoding, or be idempotent. Use VerifyWebhook for everything else, including
+// the handlers of WebhookRouter, which need WebhookBody.
+//
+// Webhooks are only recorded in the dedup store once verified and handled
+// successfully, so forged requests can't mark deliveries as seen. Duplicates
+// are skipped up front if the store implements DedupChecker, otherwise
+// concurrent deliveries of a webhook may both be handled.
+func (a *App) VerifyWebhookStreaming(c *gin.Context) {
+	mac, err := base64.StdEncoding.DecodeString(c.GetHeader(XHmacHeader))
+	if err != nil || len(mac) == 0 {
+		_ = c.AbortWithError(http.StatusUnauthorized, errors.New("invalid webhook header"))
+		return
+	}
+	id := c.GetHeader(XWebhookIDHeader)
+	seen, err := a.checkWebhook(c.Request.Context(), id)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if seen {
+		a.logger(c).Info("skipping duplicate webhook", log.String("webhook", id))
+		c.AbortWithStatus(http.StatusOK)
+		return
+	}
+	body := &hmacReader{body: c.Request.Body, hash: hmac.New(sha256.New, []byte(a.ClientSecret)), mac: mac}
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, c.Request.Body}
+	c.Next()
+	err = body.verify()
+	if err == nil {
+		if c.Writer.Status() < http.StatusMultipleChoices && len(c.Errors) == 0 {
+			if _, err = a.seenWebhook(c.Request.Context(), id); err != nil {
+				a.logger(c).Warn("failed to record webhook", log.String("webhook", id), log.Any("error", err))
+			}
+		}
+		return
+	}
+	if c.Writer.Written() {
+		a.logger(c).Error("webhook verification failed after its response", log.String("webhook", id), log.Any("error", err))
+		_ = c.Error(err)
+		return
+	}
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrInvalidWebhookSignature) {
+		status = http.StatusUnauthorized
+	}
+	_ = c.AbortWithError(status, err)
+}
@@ -0,0 +1,505 @@
+package shopigo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jonashex/shopigo/shopigotest"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+	app *App
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}
+
+// sample orders/create payload signed with secret "hush"
+const (
+	webhookBody = `{"id":820982911946154508,"email":"jon@example.com","closed_at":null,"created_at":"2021-12-31T19:00:00-05:00","updated_at":"2021-12-31T19:00:00-05:00","number":234,"note":null,"token":"123456abcd","test":true,"total_price":"254.98","currency":"USD"}`
+	webhookHmac = "LX+ie2Q0n9a0m6bTJfTPVy97BApl0zuAanZ/HU/z7UQ="
+)
+
+func (s *WebhookTestSuite) SetupTest() {
+	c := testAppConfig()
+	c.ClientSecret = "hush"
+	app, err := NewApp(c, WithSessionStore(&inMemSessionStore{}))
+	s.Require().NoError(err)
+	s.app = app
+}
+
+func (s *WebhookTestSuite) TestVerifyWebhookHMAC() {
+	s.True(VerifyWebhookHMAC([]byte(webhookBody), webhookHmac, "hush"))
+	s.Equal(webhookHmac, shopigotest.SignWebhook("hush", []byte(webhookBody)))
+	s.False(VerifyWebhookHMAC([]byte(webhookBody), webhookHmac, "wrong-secret"))
+	s.False(VerifyWebhookHMAC([]byte(webhookBody+" "), webhookHmac, "hush"))
+	s.False(VerifyWebhookHMAC([]byte(webhookBody), "", "hush"))
+	s.False(VerifyWebhookHMAC([]byte(webhookBody), "not base64!", "hush"))
+}
+
+func (s *WebhookTestSuite) TestVerifyWebhookMiddleware() {
+	var body []byte
+	handler := VerifyWebhook("hush")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	for hmac, status := range map[string]int{
+		webhookHmac: http.StatusOK,
+		"invalid":   http.StatusUnauthorized,
+		"":          http.StatusUnauthorized,
+	} {
+		body = nil
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(webhookBody))
+		req.Header.Set(XHmacHeader, hmac)
+		handler.ServeHTTP(rec, req)
+		s.Equal(status, rec.Code, hmac)
+		if status == http.StatusOK {
+			s.Equal(webhookBody, string(body), "the body must be restored for the next handler")
+		} else {
+			s.Nil(body, "unverified webhooks must not reach the next handler")
+		}
+	}
+}
+
+func (s *WebhookTestSuite) TestVerifyWebhook() {
+	for hmac, status := range map[string]int{
+		webhookHmac: http.StatusOK,
+		"invalid":   http.StatusUnauthorized,
+	} {
+		var body []byte
+		rec := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(rec)
+		e.POST("/webhooks", s.app.VerifyWebhook, func(c *gin.Context) {
+			body, _ = io.ReadAll(c.Request.Body)
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(webhookBody))
+		req.Header.Set(XHmacHeader, hmac)
+		e.ServeHTTP(rec, req)
+
+		s.Equal(status, rec.Code)
+		if status == http.StatusOK {
+			s.Equal(webhookBody, string(body), "body must be passed on to the handler")
+		}
+	}
+}
+
+func (s *WebhookTestSuite) TestVerifyWebhookForgetsFailures() {
+	WithWebhookDedup(NewInMemDedupStore(), time.Hour)(s.app)
+	calls := 0
+	serve := func(handler gin.HandlerFunc) int {
+		rec := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(rec)
+		e.Use(gin.CustomRecovery(func(c *gin.Context, _ any) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}))
+		e.POST("/webhooks", s.app.VerifyWebhook, func(c *gin.Context) {
+			calls++
+			handler(c)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(webhookBody))
+		req.Header.Set(XHmacHeader, webhookHmac)
+		req.Header.Set(XWebhookIDHeader, "webhook-id")
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s.Equal(http.StatusServiceUnavailable, serve(func(c *gin.Context) { c.AbortWithStatus(http.StatusServiceUnavailable) }))
+	s.Equal(http.StatusInternalServerError, serve(func(c *gin.Context) { panic("handler bug") }))
+	s.Equal(http.StatusOK, serve(func(c *gin.Context) { _ = c.Error(errors.New("failed")) }))
+	s.Equal(http.StatusNoContent, serve(func(c *gin.Context) { c.Status(http.StatusNoContent) }))
+	s.Equal(4, calls, "failed deliveries must not be skipped as duplicate")
+	s.Equal(http.StatusOK, serve(func(c *gin.Context) { c.Status(http.StatusNoContent) }))
+	s.Equal(4, calls, "succeeded deliveries must be skipped")
+}
+
+type unforgettingDedupStore struct{}
+
+func (unforgettingDedupStore) SeenBefore(context.Context, string, time.Duration) (bool, error) {
+	return false, nil
+}
+
+func (s *WebhookTestSuite) TestForgetWebhookLogger() {
+	logger := &recordingLogger{}
+	WithLogger(logger)(s.app)
+	WithWebhookDedup(unforgettingDedupStore{}, time.Hour)(s.app)
+	s.app.forgetWebhook(context.Background(), "webhook-id")
+	s.Require().Len(logger.entries, 1)
+	s.Equal("webhook-id", logger.entries[0]["webhook"])
+}
+
+func (s *WebhookTestSuite) serve(router *WebhookRouter, topic string, hmac string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	_, e := gin.CreateTestContext(rec)
+	e.POST("/webhooks", router.Handle)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(webhookBody))
+	req.Header.Set(XHmacHeader, hmac)
+	req.Header.Set(XTopicHeader, topic)
+	req.Header.Set(XDomainHeader, "test.myshopify.com")
+	req.Header.Set(XWebhookIDHeader, "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043")
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *WebhookTestSuite) TestRouter() {
+	var got *WebhookContext
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, wh *WebhookContext) error {
+		got = wh
+		return nil
+	})
+
+	rec := s.serve(router, "orders/create", webhookHmac)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(&WebhookContext{
+		Shop:           "test.myshopify.com",
+		Topic:          "orders/create",
+		WebhookID:      "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043",
+		DeliveryMethod: DeliveryHTTP,
+		Body:           []byte(webhookBody),
+	}, got)
+}
+
+func (s *WebhookTestSuite) TestRouterClient() {
+	var got *WebhookContext
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, wh *WebhookContext) error {
+		got = wh
+		return nil
+	})
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Nil(got.Client)
+	s.False(got.HasSession)
+
+	sess := &Session{ID: GetOfflineSessionID("test.myshopify.com"), Shop: "test.myshopify.com", AccessToken: "token"}
+	s.Require().NoError(s.app.SessionStore.Store(context.Background(), sess))
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Require().NotNil(got.Client)
+	s.True(got.HasSession)
+	s.Equal("token", got.Client.Session().AccessToken)
+}
+
+func (s *WebhookTestSuite) TestVerifyWebhookStreaming() {
+	WithWebhookDedup(NewInMemDedupStore(), time.Minute)(s.app)
+	serve := func(hmac string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(rec)
+		e.POST("/webhooks", s.app.VerifyWebhookStreaming, handler)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(webhookBody))
+		req.Header.Set(XHmacHeader, hmac)
+		req.Header.Set(XWebhookIDHeader, "webhook-id")
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(c *gin.Context) {
+		var order struct {
+			Email string `json:"email"`
+		}
+		if err := c.ShouldBindJSON(&order); err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		s.Equal("jon@example.com", order.Email)
+		c.Status(http.StatusNoContent)
+	}
+
+	forged := base64.StdEncoding.EncodeToString([]byte("forged"))
+	var readErr error
+	s.Equal(http.StatusUnauthorized, serve(forged, func(c *gin.Context) {
+		_, readErr = io.ReadAll(c.Request.Body)
+	}).Code)
+	s.ErrorIs(readErr, ErrInvalidWebhookSignature)
+	s.Equal(http.StatusUnauthorized, serve(forged, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}).Code, "unread bodies must be verified after the handlers")
+	rec := serve(forged, func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+	s.Equal(http.StatusOK, rec.Code, "written responses can't be replaced")
+	s.Equal(http.StatusUnauthorized, serve("", decode).Code)
+
+	s.Equal(http.StatusServiceUnavailable, serve(webhookHmac, func(c *gin.Context) {
+		_, _ = io.ReadAll(c.Request.Body)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	}).Code)
+	s.Equal(http.StatusNoContent, serve(webhookHmac, decode).Code,
+		"forged and failed deliveries must not mark the webhook as seen")
+	s.Equal(http.StatusOK, serve(webhookHmac, decode).Code, "duplicates must be skipped")
+}
+
+func (s *WebhookTestSuite) TestFormEncodedBody() {
+	body := "shop=test.myshopify.com&note=a+b%26c"
+	var got *WebhookContext
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, wh *WebhookContext) error {
+		got = wh
+		return nil
+	})
+	serve := func(handlers ...gin.HandlerFunc) int {
+		rec := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(rec)
+		e.POST("/webhooks", handlers...)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(XHmacHeader, shopigotest.SignWebhook("hush", []byte(body)))
+		req.Header.Set(XTopicHeader, "orders/create")
+		req.Header.Set(XDomainHeader, "test.myshopify.com")
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s.Equal(http.StatusOK, serve(s.app.VerifyWebhook, func(c *gin.Context) {
+		s.Equal("a b&c", c.PostForm("note"), "the body must be restored after verification")
+	}, router.Handle))
+	s.Require().NotNil(got)
+	s.Equal(body, string(got.Body))
+	s.Equal("application/x-www-form-urlencoded", got.ContentType)
+
+	s.Equal(http.StatusInternalServerError, serve(func(c *gin.Context) { _ = c.Request.ParseForm() }, router.Handle),
+		"a body consumed before verification must not be reported as invalid HMAC")
+}
+
+func (s *WebhookTestSuite) TestRouterUnregisteredTopic() {
+	rec := s.serve(s.app.NewWebhookRouter(), "products/update", webhookHmac)
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *WebhookTestSuite) TestRouterInvalidHmac() {
+	called := false
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, _ *WebhookContext) error {
+		called = true
+		return nil
+	})
+	rec := s.serve(router, "orders/create", "invalid")
+	s.Equal(http.StatusUnauthorized, rec.Code)
+	s.False(called)
+}
+
+func (s *WebhookTestSuite) TestComplianceHandlers() {
+	var got *CustomersRedactPayload
+	logger := &recordingLogger{}
+	WithLogger(logger)(s.app)
+	router := s.app.NewWebhookRouter()
+	ComplianceHandlers{
+		CustomersRedact: func(_ context.Context, p *CustomersRedactPayload) error {
+			got = p
+			return nil
+		},
+	}.Register(router)
+
+	ctx := context.Background()
+	err := router.Dispatch(ctx, &WebhookContext{Topic: TopicCustomersRedact, Body: []byte(`{"shop_id":954889,
+		"shop_domain":"test.myshopify.com","customer":{"id":191167,"email":"john@example.com","phone":"555-625-1199"},
+		"orders_to_redact":[299938,280263,220458]}`)})
+	s.Require().NoError(err)
+	s.Equal(&CustomersRedactPayload{
+		ShopID:         954889,
+		ShopDomain:     "test.myshopify.com",
+		Customer:       Customer{ID: 191167, Email: "john@example.com", Phone: "555-625-1199"},
+		OrdersToRedact: []int{299938, 280263, 220458},
+	}, got)
+
+	s.NoError(router.Dispatch(ctx, &WebhookContext{Topic: TopicShopRedact, Shop: "test.myshopify.com", Body: []byte(`{}`)}),
+		"topics without callback must be acknowledged")
+	s.Equal([]map[string]any{{"msg": "no handler for compliance webhook", "topic": TopicShopRedact,
+		"shop": "test.myshopify.com"}}, logger.entries)
+}
+
+func (s *WebhookTestSuite) TestDedup() {
+	WithWebhookDedup(NewInMemDedupStore(), time.Hour)(s.app)
+	calls := 0
+	router := s.app.NewWebhookRouter().On("orders/create", func(context.Context, *WebhookContext) error {
+		calls++
+		return nil
+	})
+
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Equal(1, calls, "second delivery with the same id must be skipped")
+}
+
+// failingSessionStore fails to get sessions.
+type failingSessionStore struct {
+	SessionStore
+}
+
+func (failingSessionStore) Get(context.Context, SessionID) (*Session, error) {
+	return nil, errors.New("store down")
+}
+
+func (s *WebhookTestSuite) TestRetryLater() {
+	WithWebhookDedup(NewInMemDedupStore(), time.Hour)(s.app)
+	calls := 0
+	var result error
+	router := s.app.NewWebhookRouter().On("orders/create", func(context.Context, *WebhookContext) error {
+		calls++
+		return result
+	})
+
+	result = RetryLater(errors.New("database down"))
+	s.Equal(http.StatusTooManyRequests, s.serve(router, "orders/create", webhookHmac).Code)
+	result = errors.New("invalid order")
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code,
+		"permanent failures must be acknowledged")
+	s.Equal(2, calls, "the retried delivery must not be skipped as duplicate")
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Equal(2, calls)
+
+	s.app.webhookDedup = NewInMemDedupStore()
+	s.app.SessionStore = failingSessionStore{SessionStore: s.app.SessionStore}
+	s.Equal(http.StatusInternalServerError, s.serve(router, "orders/create", webhookHmac).Code)
+	s.app.SessionStore = s.app.SessionStore.(failingSessionStore).SessionStore
+	s.Equal(http.StatusOK, s.serve(router, "orders/create", webhookHmac).Code)
+	s.Equal(3, calls, "deliveries failed by the router must not be skipped as duplicate")
+
+	var retry *RetryLaterError
+	s.ErrorAs(fmt.Errorf("wrapped: %w", RetryLater(nil)), &retry)
+}
+
+func (s *WebhookTestSuite) TestUninstall() {
+	ctx := context.Background()
+	shop := "test.myshopify.com"
+	s.app.SessionStore = &inMemSessionStore{}
+	var uninstalled string
+	WithHooks(HookUninstall(func(_ context.Context, shop string) error {
+		uninstalled = shop
+		return nil
+	}))(s.app)
+	for _, sess := range []*Session{
+		{ID: GetOfflineSessionID(shop), Shop: shop},
+		{ID: GetOnlineSessionID(shop, "42"), Shop: shop, IsOnline: true},
+		{ID: GetOfflineSessionID("other.myshopify.com"), Shop: "other.myshopify.com"},
+	} {
+		s.Require().NoError(s.app.SessionStore.Store(ctx, sess))
+	}
+
+	router := s.app.NewWebhookRouter().On(TopicAppUninstalled, s.app.HandleUninstalled)
+	s.Equal(http.StatusOK, s.serve(router, TopicAppUninstalled, webhookHmac).Code)
+	s.Equal(shop, uninstalled)
+	s.Equal(1, s.app.SessionStore.(*inMemSessionStore).Len())
+	_, err := s.app.SessionStore.Get(ctx, OfflineSessionID("other.myshopify.com"))
+	s.NoError(err)
+
+	s.Equal(http.StatusOK, s.serve(router, TopicAppUninstalled, webhookHmac).Code, "unknown shops are a no-op")
+}
+
+func (s *WebhookTestSuite) TestPubSubWebhook() {
+	data := base64.StdEncoding.EncodeToString([]byte(webhookBody))
+	attributes := `{"X-Shopify-Topic":"orders/create","X-Shopify-Shop-Domain":"test.myshopify.com",
+		"X-Shopify-Webhook-Id":"b54557e4-bdd9-4b37-8a5f-bf7d70bcd043"}`
+	exp := &WebhookContext{
+		Shop:           "test.myshopify.com",
+		Topic:          "orders/create",
+		WebhookID:      "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043",
+		DeliveryMethod: DeliveryPubSub,
+		Body:           []byte(webhookBody),
+		ContentType:    "application/json",
+	}
+	wh, err := PubSubWebhook([]byte(`{"message":{"data":"` + data + `","attributes":` + attributes +
+		`,"messageId":"1"},"subscription":"projects/app/subscriptions/webhooks"}`))
+	s.Require().NoError(err)
+	s.Equal(exp, wh)
+
+	wh, err = PubSubWebhook([]byte(`{"data":"` + data + `","attributes":` + attributes + `}`))
+	s.Require().NoError(err)
+	s.Equal(exp, wh)
+
+	_, err = PubSubWebhook([]byte(`{"data":"` + data + `","attributes":{}}`))
+	s.Error(err)
+}
+
+func (s *WebhookTestSuite) TestEventBridgeDelivery() {
+	var got *WebhookContext
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, wh *WebhookContext) error {
+		got = wh
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	_, e := gin.CreateTestContext(rec)
+	e.POST("/webhooks", router.HandleDelivery(DeliveryEventBridge))
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(`{"version":"0",
+		"detail-type":"shopifyWebhook","detail":{"payload":`+webhookBody+`,"metadata":{"X-Shopify-Topic":"orders/create",
+		"X-Shopify-Shop-Domain":"test.myshopify.com","X-Shopify-Webhook-Id":"b54557e4-bdd9-4b37-8a5f-bf7d70bcd043"}}}`)))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Require().NotNil(got)
+	s.Equal(DeliveryEventBridge, got.DeliveryMethod)
+	s.Equal("test.myshopify.com", got.Shop)
+	s.Equal(webhookBody, string(got.Body))
+}
+
+func (s *WebhookTestSuite) TestWebhookVersionCheck() {
+	type mismatch struct {
+		got      Version
+		expected Version
+	}
+	var mismatches []mismatch
+	WithWebhookVersionCheck(func(_ context.Context, wh *WebhookContext, expected Version) {
+		mismatches = append(mismatches, mismatch{wh.APIVersion, expected})
+	})(s.app)
+	dispatched := 0
+	router := s.app.NewWebhookRouter().On("orders/create", func(_ context.Context, wh *WebhookContext) error {
+		dispatched++
+		return nil
+	})
+	for _, v := range []Version{V202401, s.app.v, ""} {
+		s.Require().NoError(router.Dispatch(context.Background(), &WebhookContext{Topic: "orders/create", APIVersion: v}))
+	}
+	s.Equal([]mismatch{{V202401, s.app.v}}, mismatches)
+	s.Equal(3, dispatched)
+
+	wh, err := EventBridgeWebhook([]byte(`{"detail":{"payload":{},"metadata":{"X-Shopify-Topic":"orders/create",
+		"X-Shopify-Shop-Domain":"test.myshopify.com","X-Shopify-API-Version":"2024-01"}}}`))
+	s.Require().NoError(err)
+	s.Equal(V202401, wh.APIVersion)
+}
+
+func (s *WebhookTestSuite) TestScopesUpdate() {
+	ctx := context.Background()
+	shop := "test.myshopify.com"
+	s.Require().NoError(s.app.SessionStore.Store(ctx, &Session{ID: GetOfflineSessionID(shop), Shop: shop,
+		AccessToken: "token", Scopes: "read_products"}))
+	router := s.app.NewWebhookRouter().On(TopicAppScopesUpdate, s.app.HandleScopesUpdate)
+	s.Require().NoError(router.Dispatch(ctx, &WebhookContext{Shop: shop, Topic: TopicAppScopesUpdate,
+		Body: []byte(`{"id":1,"previous":["read_products"],"current":["write_products","read_orders"],"updated_at":"2024-01-01T00:00:00Z"}`)}))
+	sess, err := s.app.SessionStore.Get(ctx, OfflineSessionID(shop))
+	s.Require().NoError(err)
+	s.Equal("read_orders,write_products", sess.Scopes)
+	s.Equal("token", sess.AccessToken)
+
+	s.NoError(router.Dispatch(ctx, &WebhookContext{Shop: "other.myshopify.com", Topic: TopicAppScopesUpdate,
+		Body: []byte(`{"current":["read_products"]}`)}), "shops without session are a no-op")
+	s.Error(router.Dispatch(ctx, &WebhookContext{Shop: shop, Topic: TopicAppScopesUpdate, Body: []byte(`{`)}))
+}
+
+func (s *WebhookTestSuite) TestScopesUpdateConcurrentReads() {
+	ctx := context.Background()
+	shop := "test.myshopify.com"
+	store := &inMemSessionStore{}
+	WithSessionStore(store)(s.app)
+	stored := &Session{ID: GetOfflineSessionID(shop), Shop: shop, AccessToken: "token", Scopes: "read_products"}
+	s.Require().NoError(store.Store(ctx, stored))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sess, err := store.Get(ctx, OfflineSessionID(shop))
+			s.NoError(err)
+			_ = sess.Scopes
+		}
+	}()
+	router := s.app.NewWebhookRouter().On(TopicAppScopesUpdate, s.app.HandleScopesUpdate)
+	s.Require().NoError(router.Dispatch(ctx, &WebhookContext{Shop: shop, Topic: TopicAppScopesUpdate,
+		Body: []byte(`{"current":["read_orders"]}`)}))
+	<-done
+	s.Equal("read_products", stored.Scopes, "the stored session must not be modified in place")
+}